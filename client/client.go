@@ -0,0 +1,456 @@
+// Package client is a Go client for the Dujour API. It lists the datasources a server serves and fetches
+// their records, verifying the server certificate against a pinned certificate or CA and retrying
+// requests which fail with a network error, are rate limited or get a 5xx response
+//
+//	caPEM, _ := os.ReadFile("certs/cert.pem")
+//	c, err := client.New("https://dujour.local:18651", client.WithCA(caPEM))
+//	if err != nil {
+//		return err
+//	}
+//	page, err := c.Records(ctx, "users", client.Query{Filter: map[string]string{"role": "admin"}, Limit: 20})
+//
+// Dujour serves datasources read-only, so the client has no write methods
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	API_KEY_HEADER = "X-API-Key"
+
+	DEFAULT_TIMEOUT  = 10 * time.Second
+	DEFAULT_RETRIES  = 2
+	DEFAULT_BACKOFF  = 250 * time.Millisecond
+	MAX_RETRY_AFTER  = 30 * time.Second
+	MAX_ERROR_LENGTH = 64 * 1024
+)
+
+var errPinMismatch = errors.New("server certificate does not match the pinned certificate")
+
+// Endpoint is a datasource listed by the server
+type Endpoint struct {
+	Endpoint string `json:"endpoint"`
+	Source   string `json:"source"`
+}
+
+// Record is a record of a datasource, CSV values are strings and JSON values keep their JSON types
+type Record map[string]interface{}
+
+// Query selects the records of a datasource. Filter matches records whose field has the value, compared
+// as text so "1" matches the number 1. Offset and Limit select a page of the matching records, a Limit
+// of zero returns every record after Offset, neither can be negative
+type Query struct {
+	Filter map[string]string
+	Offset int
+	Limit  int
+}
+
+// Page is a page of records, Total is the number of records matching the filter
+type Page struct {
+	Records []Record
+	Total   int
+}
+
+// Error is a problem details response from the server
+type Error struct {
+	Status    int    `json:"status"`
+	Code      string `json:"code"`
+	Title     string `json:"title"`
+	Detail    string `json:"detail"`
+	RequestID string `json:"requestId"`
+
+	// the Retry-After of a rate limited or unavailable response
+	retryAfter time.Duration
+}
+
+func (e *Error) Error() string {
+	msg := fmt.Sprintf("dujour: %d %s", e.Status, e.Code)
+	if e.Detail != "" {
+		msg += ": " + e.Detail
+	}
+	if e.RequestID != "" {
+		msg += fmt.Sprintf(" (request %s)", e.RequestID)
+	}
+	return msg
+}
+
+// IsNotFound reports whether the error is a 404 response, for a datasource or record which does not exist
+func IsNotFound(err error) bool {
+	var e *Error
+	return errors.As(err, &e) && e.Status == http.StatusNotFound
+}
+
+// Option configures a Client
+type Option func(*Client)
+
+// WithCA trusts the PEM encoded certificate authority, which can be the self-signed server certificate
+// certs/cert.pem or the CA exported with 'dujour export-ca'. The server name is verified as normal
+func WithCA(caPEM []byte) Option {
+	return func(c *Client) {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caPEM) {
+			c.err = fmt.Errorf("Could not parse CA certificate; no PEM certificates found")
+			return
+		}
+		c.tlsConfig().RootCAs = pool
+	}
+}
+
+// WithPinnedCertificate accepts only the PEM encoded server certificate, whichever name or address the
+// server is reached by. The certificate must be replaced when the server certificate is renewed
+func WithPinnedCertificate(certPEM []byte) Option {
+	return func(c *Client) {
+		block, _ := pem.Decode(certPEM)
+		if block == nil || block.Type != "CERTIFICATE" {
+			c.err = fmt.Errorf("Could not parse pinned certificate; no PEM certificate found")
+			return
+		}
+		pin := sha256.Sum256(block.Bytes)
+
+		cfg := c.tlsConfig()
+		// the chain and name are not verified, VerifyConnection accepts only the pinned certificate
+		cfg.InsecureSkipVerify = true
+		cfg.VerifyConnection = func(cs tls.ConnectionState) error {
+			if len(cs.PeerCertificates) == 0 {
+				return fmt.Errorf("server presented no certificate")
+			}
+			if sha256.Sum256(cs.PeerCertificates[0].Raw) != pin {
+				return errPinMismatch
+			}
+			return nil
+		}
+	}
+}
+
+// WithAPIKey sends the API key, so masked fields are returned unmasked
+func WithAPIKey(key string) Option {
+	return func(c *Client) {
+		c.apiKey = key
+	}
+}
+
+// WithRetries sets how many times a failed request is retried, the wait doubles from backoff after each
+// attempt unless the server sends Retry-After
+func WithRetries(retries int, backoff time.Duration) Option {
+	return func(c *Client) {
+		c.retries = retries
+		c.backoff = backoff
+	}
+}
+
+// WithHTTPClient sets the HTTP client, its transport is used as is so the certificate options do not apply
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = hc
+	}
+}
+
+// Client requests the Dujour API of a server, it is safe for concurrent use
+type Client struct {
+	baseURL    string
+	httpClient *http.Client
+	apiKey     string
+	retries    int
+	backoff    time.Duration
+
+	tls *tls.Config
+	err error
+}
+
+// New creates a client for the server at baseURL, which can include a path prefix
+func New(baseURL string, opts ...Option) (*Client, error) {
+	u, err := url.Parse(strings.TrimSuffix(baseURL, "/"))
+	if err != nil {
+		return nil, fmt.Errorf("Could not parse server URL; %v", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("Could not parse server URL; scheme must be http or https")
+	}
+
+	c := &Client{
+		baseURL: u.String(),
+		retries: DEFAULT_RETRIES,
+		backoff: DEFAULT_BACKOFF,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if c.err != nil {
+		return nil, c.err
+	}
+
+	if c.httpClient == nil {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = c.tls
+		c.httpClient = &http.Client{Transport: transport, Timeout: DEFAULT_TIMEOUT}
+	}
+
+	return c, nil
+}
+
+func (c *Client) tlsConfig() *tls.Config {
+	if c.tls == nil {
+		c.tls = &tls.Config{MinVersion: tls.VersionTLS12}
+	}
+	return c.tls
+}
+
+// List returns the datasources served
+func (c *Client) List(ctx context.Context) ([]Endpoint, error) {
+	list := []Endpoint{}
+	if err := c.get(ctx, "/list", &list); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// GetAll decodes the whole of the datasource into v, which can be a slice of structs for an array
+// datasource or a struct for an object
+func (c *Client) GetAll(ctx context.Context, datasource string, v interface{}) error {
+	return c.get(ctx, "/"+url.PathEscape(datasource), v)
+}
+
+// Get decodes the record with the id into v, IsNotFound reports whether the datasource or record exists
+func (c *Client) Get(ctx context.Context, datasource, id string, v interface{}) error {
+	return c.get(ctx, "/"+url.PathEscape(datasource)+"/"+url.PathEscape(id), v)
+}
+
+// Records returns a page of the records of the datasource matching the query. The server returns the
+// whole datasource, the filter and page are applied by the client. A negative Offset or Limit is an error
+func (c *Client) Records(ctx context.Context, datasource string, q Query) (Page, error) {
+	if q.Offset < 0 || q.Limit < 0 {
+		return Page{}, fmt.Errorf("Could not read records of '%s'; offset and limit must not be negative", datasource)
+	}
+
+	var data interface{}
+	if err := c.GetAll(ctx, datasource, &data); err != nil {
+		return Page{}, err
+	}
+
+	records, err := records(data)
+	if err != nil {
+		return Page{}, fmt.Errorf("Could not read records of '%s'; %v", datasource, err)
+	}
+
+	matched := []Record{}
+	for _, r := range records {
+		if r.matches(q.Filter) {
+			matched = append(matched, r)
+		}
+	}
+
+	page := Page{Records: []Record{}, Total: len(matched)}
+	if q.Offset < len(matched) {
+		end := len(matched)
+		if q.Limit > 0 && q.Offset+q.Limit < end {
+			end = q.Offset + q.Limit
+		}
+		page.Records = matched[q.Offset:end]
+	}
+	return page, nil
+}
+
+// records returns the records of an array datasource, or of an object datasource with a single top level
+// array of records
+func records(data interface{}) ([]Record, error) {
+	var arr []interface{}
+	switch t := data.(type) {
+	case []interface{}:
+		arr = t
+	case map[string]interface{}:
+		keys := make([]string, 0, len(t))
+		for k := range t {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			if a, ok := t[k].([]interface{}); ok {
+				if arr != nil {
+					return nil, fmt.Errorf("object has more than one array of records")
+				}
+				arr = a
+			}
+		}
+		if arr == nil {
+			return nil, fmt.Errorf("object has no array of records")
+		}
+	default:
+		return nil, fmt.Errorf("unsupported data type %T", data)
+	}
+
+	out := make([]Record, 0, len(arr))
+	for _, v := range arr {
+		r, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("array contains values which are not records")
+		}
+		out = append(out, r)
+	}
+	return out, nil
+}
+
+func (r Record) matches(filter map[string]string) bool {
+	for k, want := range filter {
+		v, ok := r[k]
+		if !ok {
+			return false
+		}
+		got := ""
+		switch t := v.(type) {
+		case string:
+			got = t
+		case float64:
+			got = strconv.FormatFloat(t, 'f', -1, 64)
+		case nil:
+			got = ""
+		default:
+			got = fmt.Sprint(t)
+		}
+		if got != want {
+			return false
+		}
+	}
+	return true
+}
+
+// get requests the path, retrying failures, and decodes the JSON response into v
+func (c *Client) get(ctx context.Context, path string, v interface{}) error {
+	var lastErr error
+	for attempt := 0; attempt <= c.retries; attempt++ {
+		if attempt > 0 {
+			if err := sleep(ctx, c.wait(attempt, lastErr)); err != nil {
+				return err
+			}
+		}
+
+		body, err := c.do(ctx, c.baseURL+path)
+		if err == nil {
+			if err := json.Unmarshal(body, v); err != nil {
+				return fmt.Errorf("Could not decode response from '%s'; %v", path, err)
+			}
+			return nil
+		}
+		if !retryable(err) || ctx.Err() != nil {
+			return err
+		}
+		lastErr = err
+	}
+	return lastErr
+}
+
+func (c *Client) do(ctx context.Context, u string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if c.apiKey != "" {
+		req.Header.Set(API_KEY_HEADER, c.apiKey)
+	}
+
+	res, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, &networkError{err}
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(res.Body, MAX_ERROR_LENGTH))
+		e := &Error{}
+		if err := json.Unmarshal(body, e); err != nil || e.Status == 0 {
+			e = &Error{Status: res.StatusCode, Title: http.StatusText(res.StatusCode), Detail: string(bytes.TrimSpace(body))}
+		}
+		e.Status = res.StatusCode
+		e.retryAfter = retryAfter(res.Header.Get("Retry-After"))
+		return nil, e
+	}
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, &networkError{err}
+	}
+	return body, nil
+}
+
+// networkError is a failure to reach the server or read its response, which is retried
+type networkError struct {
+	err error
+}
+
+func (e *networkError) Error() string { return e.err.Error() }
+func (e *networkError) Unwrap() error { return e.err }
+
+// retryable reports whether the request may succeed if it is retried, error responses are retried when
+// rate limited or for a 5xx status
+func retryable(err error) bool {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Status == http.StatusTooManyRequests || e.Status >= http.StatusInternalServerError
+	}
+	var ne *networkError
+	if errors.As(err, &ne) {
+		// a certificate which does not verify will not on a retry
+		var unknown x509.UnknownAuthorityError
+		var hostname x509.HostnameError
+		var invalid x509.CertificateInvalidError
+		return !errors.As(err, &unknown) && !errors.As(err, &hostname) && !errors.As(err, &invalid) &&
+			!errors.Is(err, errPinMismatch)
+	}
+	return false
+}
+
+// wait returns how long to wait before the attempt, the Retry-After of the last response or the backoff
+// doubled for each earlier attempt
+func (c *Client) wait(attempt int, lastErr error) time.Duration {
+	var e *Error
+	if errors.As(lastErr, &e) && e.retryAfter > 0 {
+		return e.retryAfter
+	}
+	return c.backoff << (attempt - 1)
+}
+
+func retryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	var d time.Duration
+	if seconds, err := strconv.Atoi(value); err == nil {
+		d = time.Duration(seconds) * time.Second
+	} else if t, err := http.ParseTime(value); err == nil {
+		d = time.Until(t)
+	}
+	if d < 0 {
+		return 0
+	}
+	if d > MAX_RETRY_AFTER {
+		return MAX_RETRY_AFTER
+	}
+	return d
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,213 @@
+package client_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/spoonboy-io/dujour/client"
+	"github.com/spoonboy-io/dujour/dujourtest"
+)
+
+const people = `[
+	{"id": 1, "name": "Ann", "role": "admin"},
+	{"id": 2, "name": "Bob", "role": "user"},
+	{"id": 3, "name": "Cat", "role": "admin"},
+	{"id": 4, "name": "Dan", "role": "admin"}
+]`
+
+func newClient(t *testing.T, srv *dujourtest.Server) *client.Client {
+	t.Helper()
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+	c, err := client.New(srv.URL, client.WithCA(certPEM))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestClient(t *testing.T) {
+	srv := dujourtest.New(t,
+		dujourtest.WithTLS(),
+		dujourtest.WithFixture("people.json", people),
+		dujourtest.WithFixture("teams.json", `{"teams": [{"id": "red"}, {"id": "blue"}]}`),
+	)
+	c := newClient(t, srv)
+	ctx := context.Background()
+
+	list, err := c.List(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 2 || list[0].Endpoint != "people" || list[1].Endpoint != "teams" {
+		t.Errorf("failed got %+v wanted people and teams", list)
+	}
+
+	var person struct {
+		ID   int    `json:"id"`
+		Name string `json:"name"`
+	}
+	if err := c.Get(ctx, "people", "3", &person); err != nil || person.Name != "Cat" {
+		t.Errorf("failed got %+v %v wanted Cat", person, err)
+	}
+	if err := c.Get(ctx, "people", "9", &person); !client.IsNotFound(err) {
+		t.Errorf("failed got %v wanted not found", err)
+	}
+
+	testCases := []struct {
+		name       string
+		datasource string
+		query      client.Query
+		wantTotal  int
+		wantIDs    []interface{}
+		wantErr    bool
+	}{
+		{"all records", "people", client.Query{}, 4, []interface{}{1.0, 2.0, 3.0, 4.0}, false},
+		{"filtered", "people", client.Query{Filter: map[string]string{"role": "admin"}}, 3, []interface{}{1.0, 3.0, 4.0}, false},
+		{"filter by number", "people", client.Query{Filter: map[string]string{"id": "2"}}, 1, []interface{}{2.0}, false},
+		{"page", "people", client.Query{Filter: map[string]string{"role": "admin"}, Offset: 1, Limit: 1}, 3, []interface{}{3.0}, false},
+		{"page beyond the records", "people", client.Query{Offset: 10, Limit: 2}, 4, []interface{}{}, false},
+		{"records of an object", "teams", client.Query{Limit: 1}, 2, []interface{}{"red"}, false},
+		{"negative offset", "people", client.Query{Offset: -1}, 0, nil, true},
+		{"negative limit", "people", client.Query{Limit: -1}, 0, nil, true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			page, err := c.Records(ctx, tc.datasource, tc.query)
+			if tc.wantErr {
+				if err == nil {
+					t.Errorf("failed got %+v wanted error", page)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			ids := []interface{}{}
+			for _, r := range page.Records {
+				ids = append(ids, r["id"])
+			}
+			if page.Total != tc.wantTotal || len(ids) != len(tc.wantIDs) {
+				t.Fatalf("failed got %d %v wanted %d %v", page.Total, ids, tc.wantTotal, tc.wantIDs)
+			}
+			for i := range ids {
+				if ids[i] != tc.wantIDs[i] {
+					t.Errorf("failed got %v wanted %v", ids, tc.wantIDs)
+				}
+			}
+		})
+	}
+}
+
+func TestCertificates(t *testing.T) {
+	srv := dujourtest.New(t, dujourtest.WithTLS(), dujourtest.WithFixture("people.json", people))
+	certPEM := func(s *httptest.Server) []byte {
+		return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: s.Certificate().Raw})
+	}
+
+	// every httptest server has the same certificate
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	other, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: other})
+
+	testCases := []struct {
+		name    string
+		opts    []client.Option
+		wantErr bool
+	}{
+		{"pinned certificate", []client.Option{client.WithPinnedCertificate(certPEM(srv.Server))}, false},
+		{"other pinned certificate", []client.Option{client.WithPinnedCertificate(otherPEM)}, true},
+		{"trusted CA", []client.Option{client.WithCA(certPEM(srv.Server))}, false},
+		{"system roots", nil, true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			c, err := client.New(srv.URL, tc.opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			srv.ClearRequests()
+			_, err = c.List(context.Background())
+			if (err != nil) != tc.wantErr {
+				t.Errorf("failed got %v wanted error %v", err, tc.wantErr)
+			}
+			// a certificate which does not verify is not retried
+			if tc.wantErr && len(srv.Requests()) != 0 {
+				t.Errorf("failed got %d requests wanted none", len(srv.Requests()))
+			}
+		})
+	}
+
+	if _, err := client.New(srv.URL, client.WithCA([]byte("not a certificate"))); err == nil {
+		t.Errorf("failed got no error for invalid CA")
+	}
+}
+
+func TestRetries(t *testing.T) {
+	testCases := []struct {
+		name         string
+		failures     int32
+		status       int
+		wantAttempts int32
+		wantErr      bool
+	}{
+		{"success is not retried", 0, http.StatusOK, 1, false},
+		{"unavailable is retried", 2, http.StatusServiceUnavailable, 3, false},
+		{"rate limited is retried", 1, http.StatusTooManyRequests, 2, false},
+		{"retries are limited", 5, http.StatusBadGateway, 3, true},
+		{"not found is not retried", 5, http.StatusNotFound, 1, true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var attempts int32
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if atomic.AddInt32(&attempts, 1) <= tc.failures {
+					w.Header().Set("Retry-After", "0")
+					w.Header().Set("Content-Type", "application/problem+json")
+					w.WriteHeader(tc.status)
+					_, _ = w.Write([]byte(`{"status": 1, "code": "failed", "detail": "try again"}`))
+					return
+				}
+				_, _ = w.Write([]byte(`[]`))
+			}))
+			defer srv.Close()
+
+			c, err := client.New(srv.URL, client.WithRetries(2, time.Millisecond))
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = c.List(context.Background())
+			if (err != nil) != tc.wantErr {
+				t.Errorf("failed got %v wanted error %v", err, tc.wantErr)
+			}
+			if got := atomic.LoadInt32(&attempts); got != tc.wantAttempts {
+				t.Errorf("failed got %d attempts wanted %d", got, tc.wantAttempts)
+			}
+		})
+	}
+}
@@ -0,0 +1,391 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spoonboy-io/dujour/internal"
+	"github.com/spoonboy-io/dujour/internal/certificate"
+	"github.com/spoonboy-io/dujour/internal/config"
+	"github.com/spoonboy-io/dujour/internal/file"
+	"github.com/spoonboy-io/dujour/internal/lint"
+	"github.com/spoonboy-io/dujour/internal/mask"
+	"github.com/spoonboy-io/dujour/internal/schema"
+)
+
+// exportCA writes the certificate of the local certificate authority to the file given
+// as the first argument, or to stdout, so it can be distributed to clients
+func exportCA(args []string) {
+	flags := newFlagSet("export-ca")
+	_ = flags.Parse(args)
+	args = flags.Args()
+
+	loadConfig()
+	if cfg.TLS.Mode != config.TLS_MODE_CA {
+		logger.FatalError("Cannot export CA certificate", fmt.Errorf("tls mode is not '%s'", config.TLS_MODE_CA))
+	}
+
+	if err := certificate.MakeCA(cfg.TLS, logger); err != nil {
+		logger.FatalError("Problem creating the local certificate authority", err)
+	}
+
+	pem, err := certificate.CACertificate()
+	if err != nil {
+		logger.FatalError("Problem reading the CA certificate", err)
+	}
+
+	if len(args) == 0 {
+		_, _ = os.Stdout.Write(pem)
+		return
+	}
+
+	if err := os.WriteFile(args[0], pem, 0644); err != nil {
+		logger.FatalError("Problem writing the CA certificate", err)
+	}
+	logger.Info(fmt.Sprintf("Exported CA certificate to '%s'", args[0]))
+}
+
+// inferSchema writes a JSON Schema inferred from each datasource in the data folder and the folder of each
+// virtual host, or only those named in args, next to its data file or in the folder given by -out, laid
+// out as the schemas folder is. Existing schemas are kept unless -force is given, the written schemas are
+// a starting point for validating the datasources
+func inferSchema(args []string) {
+	flags := newFlagSet("infer-schema")
+	out := flags.String("out", "", "folder to write the schemas to, by default next to each data file")
+	force := flags.Bool("force", false, "overwrite existing schemas")
+	_ = flags.Parse(args)
+
+	loadConfig()
+
+	only := map[string]bool{}
+	for _, v := range flags.Args() {
+		only[strings.ToLower(v)] = true
+	}
+
+	datasources := map[string]internal.Datasource{}
+	// the folder within -out each schema is written to, virtual hosts have their own like the schemas folder
+	outFolders := map[string]string{}
+	for _, folder := range dataFolders(nil) {
+		loaded, err := file.LoadAndValidateDatasources(folder, cfg, logger)
+		if err != nil {
+			logger.FatalError(fmt.Sprintf("Problem loading data sources in '%s' folder", folder), err)
+		}
+		rel, err := filepath.Rel(internal.SCHEMA_FOLDER, file.SchemaFolder(folder))
+		if err != nil {
+			logger.FatalError(fmt.Sprintf("Problem finding schemas folder for '%s' folder", folder), err)
+		}
+		for k, v := range loaded {
+			datasources[k] = v
+			outFolders[k] = filepath.Join(*out, rel)
+		}
+	}
+
+	files := make([]string, 0, len(datasources))
+	for k := range datasources {
+		files = append(files, k)
+	}
+	sort.Strings(files)
+
+	written := 0
+	for _, v := range files {
+		ds := datasources[v]
+		if len(only) > 0 && !only[ds.EndpointName] {
+			continue
+		}
+		if !ds.Available() {
+			logger.Warn(fmt.Sprintf("Skipping '%s', it could not be loaded", ds.FileName))
+			continue
+		}
+
+		dest := strings.TrimSuffix(ds.FileName, filepath.Ext(ds.FileName)) + internal.SCHEMA_EXTENSION
+		if *out != "" {
+			dest = filepath.Join(outFolders[v], filepath.Base(dest))
+		}
+		if _, err := os.Stat(dest); err == nil && !*force {
+			logger.Warn(fmt.Sprintf("Skipping '%s', schema '%s' exists, use -force to overwrite", ds.FileName, dest))
+			continue
+		}
+
+		doc, err := schema.Document(schema.Describe(ds.Data), ds.EndpointName)
+		if err != nil {
+			logger.FatalError(fmt.Sprintf("Problem inferring schema for '%s'", ds.FileName), err)
+		}
+		if err := os.MkdirAll(filepath.Dir(dest), os.ModePerm); err != nil {
+			logger.FatalError(fmt.Sprintf("Problem checking/creating '%s' folder", filepath.Dir(dest)), err)
+		}
+		if err := os.WriteFile(dest, append(doc, '\n'), 0644); err != nil {
+			logger.FatalError(fmt.Sprintf("Problem writing schema '%s'", dest), err)
+		}
+		logger.Info(fmt.Sprintf("Inferred schema for '%s' written to '%s'", ds.FileName, dest))
+		written++
+	}
+
+	if written == 0 {
+		logger.Warn("No schemas were written")
+	}
+}
+
+// lintData checks the data files in the folders given, by default the data folder and the folder of each
+// virtual host, and writes the issues found to stdout as text or, with -format json, as a JSON array. It
+// exits with status 1 when there are errors, or warnings with -strict, so it can be used in CI
+func lintData(args []string) {
+	flags := newFlagSet("lint")
+	format := flags.String("format", "text", "output format, text or json")
+	strict := flags.Bool("strict", false, "fail on warnings as well as errors")
+	_ = flags.Parse(args)
+
+	if *format != "text" && *format != "json" {
+		logger.FatalError("Cannot lint data", fmt.Errorf("unsupported format '%s'", *format))
+	}
+
+	loadConfig()
+
+	issues := []lint.Issue{}
+	for _, v := range dataFolders(flags.Args()) {
+		found, err := lint.Folder(v, logger)
+		if err != nil {
+			logger.FatalError(fmt.Sprintf("Problem linting '%s' folder", v), err)
+		}
+		issues = append(issues, found...)
+	}
+
+	failed := false
+	for _, v := range issues {
+		if v.Level == lint.LEVEL_ERROR || *strict {
+			failed = true
+		}
+	}
+
+	if *format == "json" {
+		out, err := json.MarshalIndent(issues, "", "  ")
+		if err != nil {
+			logger.FatalError("Problem writing lint issues", err)
+		}
+		fmt.Println(string(out))
+	} else {
+		for _, v := range issues {
+			fmt.Println(v)
+		}
+	}
+
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// dataFolders returns the folders given as arguments, or the data folder and the folder of each
+// virtual host when there are none
+func dataFolders(args []string) []string {
+	if len(args) > 0 {
+		return args
+	}
+	folders := []string{internal.DATA_FOLDER}
+	for _, vh := range cfg.VHosts {
+		folders = append(folders, vh.DataFolder)
+	}
+	return folders
+}
+
+// validate loads the datasources in the folders given, or those the server would serve, as the server
+// would, applying schemas. It exits with status 1 when any datasource fails to load
+func validate(args []string) {
+	flags := newFlagSet("validate")
+	_ = flags.Parse(args)
+
+	loadConfig()
+
+	failed := 0
+	total := 0
+	for _, folder := range dataFolders(flags.Args()) {
+		datasources, err := file.LoadAndValidateDatasources(folder, cfg, logger)
+		if err != nil {
+			logger.FatalError(fmt.Sprintf("Problem loading '%s' folder", folder), err)
+		}
+		for _, ds := range sortedDatasources(datasources) {
+			total++
+			if ds.LastError != "" {
+				failed++
+				fmt.Printf("FAIL %s: %s\n", ds.FileName, ds.LastError)
+				continue
+			}
+			fmt.Printf("ok   %s (%d records)\n", ds.FileName, ds.RecordCount())
+		}
+	}
+
+	fmt.Printf("%d of %d datasources valid\n", total-failed, total)
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
+
+type listEntry struct {
+	Endpoint string `json:"endpoint"`
+	Source   string `json:"source"`
+	Records  int    `json:"records"`
+}
+
+// list prints the endpoint of each datasource which would be served from the folders given, or those
+// the server would serve, as text or as a JSON array with -format json
+func list(args []string) {
+	flags := newFlagSet("list")
+	format := flags.String("format", "text", "output format, text or json")
+	_ = flags.Parse(args)
+
+	if *format != "text" && *format != "json" {
+		logger.FatalError("Cannot list endpoints", fmt.Errorf("unsupported format '%s'", *format))
+	}
+
+	loadConfig()
+
+	entries := []listEntry{}
+	for _, folder := range dataFolders(flags.Args()) {
+		datasources, err := file.LoadAndValidateDatasources(folder, cfg, logger)
+		if err != nil {
+			logger.FatalError(fmt.Sprintf("Problem loading '%s' folder", folder), err)
+		}
+		for _, ds := range sortedDatasources(datasources) {
+			if ds.Available() {
+				entries = append(entries, listEntry{"/" + ds.EndpointName, ds.FileName, ds.RecordCount()})
+			}
+		}
+	}
+
+	if *format == "json" {
+		out, err := json.MarshalIndent(entries, "", "  ")
+		if err != nil {
+			logger.FatalError("Problem writing endpoints", err)
+		}
+		fmt.Println(string(out))
+		return
+	}
+	for _, v := range entries {
+		fmt.Printf("%-24s %-32s %d records\n", v.Endpoint, v.Source, v.Records)
+	}
+}
+
+func sortedDatasources(datasources map[string]internal.Datasource) []internal.Datasource {
+	out := make([]internal.Datasource, 0, len(datasources))
+	for _, v := range datasources {
+		out = append(out, v)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].FileName < out[j].FileName
+	})
+	return out
+}
+
+// export writes the datasource served at the endpoint given as the first argument to the file given as
+// the second argument, or to stdout, in another format. With -mask the masking rules are applied
+func export(args []string) {
+	flags := newFlagSet("export")
+	format := flags.String("format", file.FORMAT_JSON, "output format, csv, json or ndjson")
+	masked := flags.Bool("mask", false, "apply the masking rules for the datasource")
+	folder := flags.String("folder", internal.DATA_FOLDER, "data folder the datasource is served from")
+	_ = flags.Parse(args)
+
+	if flags.NArg() < 1 || flags.NArg() > 2 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	endpoint := strings.ToLower(flags.Arg(0))
+
+	loadConfig()
+
+	files, err := file.FindFiles(*folder, logger)
+	if err != nil {
+		logger.FatalError(fmt.Sprintf("Problem finding files in '%s' folder", *folder), err)
+	}
+
+	var ds *internal.Datasource
+	for _, v := range files {
+		if candidate := file.InitDatasource(v); candidate.EndpointName == endpoint {
+			loaded, err := file.LoadAndValidate(candidate, cfg, logger)
+			if err != nil {
+				logger.FatalError(fmt.Sprintf("Could not load datasource '%s'", v), err)
+			}
+			ds = &loaded
+			break
+		}
+	}
+	if ds == nil {
+		logger.FatalError("Cannot export datasource", fmt.Errorf("no datasource is served at '/%s' from '%s' folder", endpoint, *folder))
+	}
+
+	data := ds.Data
+	if *masked {
+		data = mask.Apply(data, cfg.MaskRules(endpoint))
+	}
+
+	out := os.Stdout
+	if flags.NArg() == 2 {
+		out, err = os.Create(flags.Arg(1))
+		if err != nil {
+			logger.FatalError("Problem creating the export file", err)
+		}
+		defer out.Close()
+	}
+
+	if err := file.Export(out, data, *format); err != nil {
+		logger.FatalError(fmt.Sprintf("Problem exporting '%s'", ds.FileName), err)
+	}
+	if out != os.Stdout {
+		logger.Info(fmt.Sprintf("Exported '%s' to '%s'", ds.FileName, flags.Arg(1)))
+	}
+}
+
+// cert generates the server certificate, as the server does on start when there is none, or prints the
+// details of a certificate, by default the server certificate
+func cert(args []string) {
+	flags := newFlagSet("cert")
+	force := flags.Bool("force", false, "replace an existing certificate when generating")
+
+	action := ""
+	if len(args) > 0 {
+		action, args = args[0], args[1:]
+	}
+	_ = flags.Parse(args)
+
+	certFile := filepath.Join(internal.TLS_FOLDER, internal.TLS_CERT_FILE)
+
+	switch action {
+	case "generate":
+		loadConfig()
+		if _, err := os.Stat(certFile); err == nil && !*force {
+			logger.FatalError("Cannot generate certificate", fmt.Errorf("'%s' exists, use -force to replace it", certFile))
+		}
+		if err := os.MkdirAll(internal.TLS_FOLDER, os.ModePerm); err != nil {
+			logger.FatalError("Problem checking/creating 'certificates' folder", err)
+		}
+		if err := certificate.Make(cfg.TLS, logger); err != nil {
+			logger.FatalError("Problem creating the certificate/key", err)
+		}
+	case "inspect":
+		if flags.NArg() > 0 {
+			certFile = flags.Arg(0)
+		}
+		c, err := certificate.Read(certFile)
+		if err != nil {
+			logger.FatalError("Problem reading the certificate", err)
+		}
+		ips := []string{}
+		for _, v := range c.IPAddresses {
+			ips = append(ips, v.String())
+		}
+		fmt.Printf("File:        %s\n", certFile)
+		fmt.Printf("Subject:     %s\n", c.Subject)
+		fmt.Printf("Issuer:      %s\n", c.Issuer)
+		fmt.Printf("DNS names:   %s\n", strings.Join(c.DNSNames, ", "))
+		fmt.Printf("IPs:         %s\n", strings.Join(ips, ", "))
+		fmt.Printf("Not before:  %s\n", c.NotBefore.Format(time.RFC3339))
+		fmt.Printf("Not after:   %s (%d days remaining)\n", c.NotAfter.Format(time.RFC3339), int(time.Until(c.NotAfter).Hours()/24))
+		fmt.Printf("Generated:   %t\n", certificate.Owned(c))
+	default:
+		flags.Usage()
+		os.Exit(2)
+	}
+}
@@ -1,25 +1,14 @@
 package main
 
 import (
-	"errors"
+	"flag"
 	"fmt"
-	"net"
-	"net/http"
+	"io"
 	"os"
-	"path/filepath"
-	"sync"
-	"time"
 
-	"github.com/spoonboy-io/dujour/internal/routes"
-
-	"github.com/spoonboy-io/dujour/internal/watcher"
-
-	"github.com/gorilla/mux"
 	"github.com/spoonboy-io/dujour/internal"
-	"github.com/spoonboy-io/dujour/internal/certificate"
-	"github.com/spoonboy-io/dujour/internal/file"
+	"github.com/spoonboy-io/dujour/internal/config"
 	"github.com/spoonboy-io/koan"
-	"github.com/spoonboy-io/reprise"
 )
 
 var (
@@ -27,90 +16,101 @@ var (
 	goversion = "Unknown"
 )
 
-var logger *koan.Logger
+var (
+	logger = &koan.Logger{}
+	cfg    *config.Config
+)
+
+// command is a subcommand of the dujour binary
+type command struct {
+	name    string
+	usage   string
+	summary string
+	run     func(args []string)
+}
+
+var commands []command
 
 func init() {
-	logger = &koan.Logger{}
+	commands = []command{
+		{"serve", "", "Serve the datasources in the data folder, the default command", serve},
+		{"validate", "[folder...]", "Load and validate the datasources the server would load", validate},
+		{"list", "[-format json] [folder...]", "List the endpoints which would be served", list},
+		{"lint", "[-format json] [-strict] [folder...]", "Check data files for problems, for use in CI", lintData},
+		{"infer-schema", "[-out folder] [-force] [datasource...]", "Write JSON Schemas inferred from the datasources", inferSchema},
+		{"export", "[-format csv|json|ndjson] [-mask] [-folder folder] datasource [file]", "Export a datasource to another format", export},
+		{"cert", "generate [-force] | inspect [file]", "Generate or inspect the server certificate", cert},
+		{"export-ca", "[file]", "Export the certificate of the local certificate authority", exportCA},
+		{"version", "", "Print the version", printVersion},
+		{"help", "", "Print this help", func([]string) { usage(os.Stdout) }},
+	}
+}
 
-	// check/create data folder
-	dataPath := filepath.Join(".", internal.DATA_FOLDER)
-	if err := os.MkdirAll(dataPath, os.ModePerm); err != nil {
-		logger.FatalError("Problem checking/creating data folder", err)
+func main() {
+	// without a command the server is run
+	if len(os.Args) < 2 {
+		serve(nil)
+		return
 	}
 
-	// check/create certificates folder
-	tlsPath := filepath.Join(".", internal.TLS_FOLDER)
-	if err := os.MkdirAll(tlsPath, os.ModePerm); err != nil {
-		logger.FatalError("Problem checking/creating 'certificates' folder", err)
+	name := os.Args[1]
+	if name == "-h" || name == "-help" || name == "--help" {
+		name = "help"
 	}
 
-	// add self-signed certificate only if folder empty, if the cert expires it
-	// it can be deleted so the code here creates a new cert.pem and key.pem file
-	checkExist := fmt.Sprintf("%s/cert.pem", internal.TLS_FOLDER)
-	if _, err := os.Stat(checkExist); errors.Is(err, os.ErrNotExist) {
-		logger.Info("Creating self-signed TLS certificate for the server")
-		if err := certificate.Make(logger); err != nil {
-			logger.FatalError("Problem creating the certificate/key", err)
+	for _, v := range commands {
+		if v.name == name {
+			v.run(os.Args[2:])
+			return
 		}
 	}
+
+	usage(os.Stderr)
+	logger.FatalError("Unknown command", fmt.Errorf("'%s' is not a dujour command", name))
 }
 
-func main() {
-	mtx := &sync.Mutex{}
-
-	// write a console banner
-	reprise.WriteSimple(&reprise.Banner{
-		Name:         "Dujour",
-		Description:  "JSON/CSV Data Server",
-		Version:      version,
-		GoVersion:    goversion,
-		WebsiteURL:   "https://spoonboy.io",
-		VcsURL:       "https://github.com/spoonboy-io/dujour",
-		VcsName:      "Github",
-		EmailAddress: "hello@spoonboy.io",
-	})
-
-	datasources, err := file.LoadAndValidateDatasources(internal.DATA_FOLDER, logger)
+// loadConfig reads the optional configuration file, it is only read by the commands which use it
+func loadConfig() {
+	var err error
+	cfg, err = config.Load(internal.CONFIG_FILE)
 	if err != nil {
-		logger.FatalError("Problem loading data sources", err)
+		logger.FatalError("Problem loading configuration", err)
 	}
+}
 
-	if len(datasources) == 0 {
-		logger.Warn(fmt.Sprintf("Currently there are datasources to serve, add JSON or CSV files to the '%s' folder", internal.DATA_FOLDER))
+// newFlagSet creates the flags for a command, -h prints the usage of the command
+func newFlagSet(name string) *flag.FlagSet {
+	cmd := command{name: name}
+	for _, v := range commands {
+		if v.name == name {
+			cmd = v
+		}
 	}
 
-	// add watch to the dta folder for hot reload using a goroutine
-	go func() {
-		if err := watcher.Monitor(datasources, logger, mtx); err != nil {
-			logger.FatalError("Could not create the file watcher", err)
+	flags := flag.NewFlagSet(name, flag.ExitOnError)
+	flags.Usage = func() {
+		out := flags.Output()
+		_, _ = fmt.Fprintf(out, "Usage: dujour %s %s\n\n%s\n", cmd.name, cmd.usage, cmd.summary)
+		count := 0
+		flags.VisitAll(func(*flag.Flag) { count++ })
+		if count > 0 {
+			_, _ = fmt.Fprintf(out, "\nOptions:\n")
+			flags.PrintDefaults()
 		}
-	}()
-
-	// handlers
-	mux := mux.NewRouter()
-	app := &routes.App{
-		Logger:      logger,
-		Datasources: datasources,
-		Mtx:         mtx,
 	}
+	return flags
+}
 
-	mux.HandleFunc(`/`, app.Home).Methods("GET")
-	mux.HandleFunc(`/list`, app.ListDatasources).Methods("GET")
-	mux.HandleFunc("/{datasource:[a-z0-9=\\-\\/]+}/{id:[a-zA-Z0-9=\\-\\/]+}", app.DatasourceGetByID).Methods("GET")
-	mux.HandleFunc("/{datasource:[a-z0-9=\\-\\/]+}", app.DatasourceGetAll).Methods("GET")
-
-	// create a server
-	hostPort := net.JoinHostPort(internal.SRV_HOST, internal.SRV_PORT)
-	srvTLS := &http.Server{
-		Addr:         hostPort,
-		Handler:      mux,
-		ReadTimeout:  3 * time.Second,
-		WriteTimeout: 5 * time.Second,
+func usage(w io.Writer) {
+	_, _ = fmt.Fprintf(w, "Dujour - JSON/CSV Data Server\n\nUsage: dujour [command] [options]\n\nCommands:\n")
+	for _, v := range commands {
+		_, _ = fmt.Fprintf(w, "  %-13s %s\n", v.name, v.summary)
 	}
+	_, _ = fmt.Fprintf(w, "\nRun 'dujour [command] -h' for the options of a command.\n")
+}
 
-	// start HTTPS server
-	logger.Info(fmt.Sprintf("Starting HTTPS server on %s", hostPort))
-	if err := srvTLS.ListenAndServeTLS(fmt.Sprintf("%s/cert.pem", internal.TLS_FOLDER), fmt.Sprintf("%s/key.pem", internal.TLS_FOLDER)); err != nil {
-		logger.FatalError("Failed to start HTTPS server", err)
-	}
+func printVersion(args []string) {
+	flags := newFlagSet("version")
+	_ = flags.Parse(args)
+	fmt.Printf("dujour %s (%s)\n", version, goversion)
 }
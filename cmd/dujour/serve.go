@@ -0,0 +1,335 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"syscall"
+
+	"github.com/spoonboy-io/dujour/internal/routes"
+
+	"github.com/spoonboy-io/dujour/internal/watcher"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/gorilla/mux"
+	"github.com/spoonboy-io/dujour/internal"
+	"github.com/spoonboy-io/dujour/internal/accesslog"
+	"github.com/spoonboy-io/dujour/internal/certificate"
+	"github.com/spoonboy-io/dujour/internal/config"
+	"github.com/spoonboy-io/dujour/internal/file"
+	"github.com/spoonboy-io/dujour/internal/metrics"
+	"github.com/spoonboy-io/dujour/internal/middleware"
+	"github.com/spoonboy-io/dujour/internal/ratelimit"
+	"github.com/spoonboy-io/dujour/internal/server"
+	"github.com/spoonboy-io/dujour/internal/store"
+	"github.com/spoonboy-io/reprise"
+)
+
+// prepare creates the data and certificates folders, and the server certificate when there is none. If
+// the certificate expires it can be deleted so a new cert.pem and key.pem are created, self-signed or
+// issued by the local certificate authority when running in CA mode
+func prepare() {
+	// check/create data folder
+	dataPath := filepath.Join(".", internal.DATA_FOLDER)
+	if err := os.MkdirAll(dataPath, os.ModePerm); err != nil {
+		logger.FatalError("Problem checking/creating data folder", err)
+	}
+
+	// check/create certificates folder
+	tlsPath := filepath.Join(".", internal.TLS_FOLDER)
+	if err := os.MkdirAll(tlsPath, os.ModePerm); err != nil {
+		logger.FatalError("Problem checking/creating 'certificates' folder", err)
+	}
+
+	checkExist := filepath.Join(internal.TLS_FOLDER, internal.TLS_CERT_FILE)
+	if _, err := os.Stat(checkExist); errors.Is(err, os.ErrNotExist) {
+		logger.Info("Creating TLS certificate for the server")
+		if err := certificate.Make(cfg.TLS, logger); err != nil {
+			logger.FatalError("Problem creating the certificate/key", err)
+		}
+	}
+}
+
+// serve runs the server until it receives SIGINT or SIGTERM, SIGHUP reloads the configuration
+// and datasources
+func serve(args []string) {
+	flags := newFlagSet("serve")
+	_ = flags.Parse(args)
+
+	loadConfig()
+	prepare()
+
+	// write a console banner
+	reprise.WriteSimple(&reprise.Banner{
+		Name:         "Dujour",
+		Description:  "JSON/CSV Data Server",
+		Version:      version,
+		GoVersion:    goversion,
+		WebsiteURL:   "https://spoonboy.io",
+		VcsURL:       "https://github.com/spoonboy-io/dujour",
+		VcsName:      "Github",
+		EmailAddress: "hello@spoonboy.io",
+	})
+
+	// cancelling ctx stops the watchers, wg tracks them so shutdown can wait
+	// for any reload in progress to complete
+	ctx, cancel := context.WithCancel(context.Background())
+	wg := &sync.WaitGroup{}
+
+	// handlers, each virtual host serves its own data folder and requests for
+	// any other host are served from the default data folder
+	router := mux.NewRouter()
+	apps := []*routes.App{}
+	for _, vh := range cfg.VHosts {
+		logger.Info(fmt.Sprintf("Serving virtual host '%s' from '%s' folder", vh.Host, vh.DataFolder))
+		app := newApp(ctx, wg, vh.DataFolder)
+		routes.Register(router.Host(vh.Host).Subrouter(), app)
+		apps = append(apps, app)
+	}
+	app := newApp(ctx, wg, internal.DATA_FOLDER)
+	routes.Register(router, app)
+	apps = append(apps, app)
+	router.Use(middleware.Metrics(known(apps)), middleware.CORS(app.CORSPolicy), middleware.RateLimit(ratelimit.New(), app.CurrentConfig))
+
+	// every request is access logged, including those which match no route
+	accessLogger, err := accesslog.New(cfg.AccessLog, logger)
+	if err != nil {
+		logger.FatalError("Problem opening the access log", err)
+	}
+	handler := middleware.RequestID(middleware.AccessLog(accessLogger)(router))
+
+	// load the certificate, renewing and reloading it as needed while the server runs
+	certManager, err := certificate.NewManager(cfg.TLS, logger)
+	if err != nil {
+		logger.FatalError("Problem loading the certificate/key", err)
+	}
+
+	registerMetrics(apps, certManager)
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if err := certManager.Monitor(ctx); err != nil {
+			logger.FatalError("Could not create the certificate watcher", err)
+		}
+	}()
+
+	// create the servers, HTTPS servers use the certificate provided by the manager
+	listeners, err := server.New(cfg, handler, certManager.GetCertificate)
+	if err != nil {
+		logger.FatalError("Problem creating the servers", err)
+	}
+
+	errs := make(chan error, len(listeners))
+	for _, l := range listeners {
+		go func(l *server.Listener) {
+			logger.Info(fmt.Sprintf("Starting %s", l))
+			if err := l.Serve(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				errs <- fmt.Errorf("%s; %v", l, err)
+			}
+		}(l)
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+
+	for {
+		select {
+		case err := <-errs:
+			logger.FatalError("Failed to start server", err)
+		case sig := <-signals:
+			if sig == syscall.SIGHUP {
+				reload(apps, certManager)
+				continue
+			}
+
+			logger.Info(fmt.Sprintf("Received %s, shutting down", sig))
+			shutdown(listeners, cancel, wg)
+			_ = accessLogger.Close()
+			return
+		}
+	}
+}
+
+// reload forces a full reload of the configuration and all datasources. Changes to listeners,
+// TLS settings and virtual hosts require a restart
+func reload(apps []*routes.App, certManager *certificate.Manager) {
+	logger.Info("Reloading configuration and datasources")
+
+	newCfg, err := config.Load(internal.CONFIG_FILE)
+	if err != nil {
+		logger.Error("Could not reload configuration, keeping current configuration", err)
+		newCfg = cfg
+	}
+	cfg = newCfg
+
+	for _, app := range apps {
+		datasources, err := file.LoadAndValidateDatasources(app.DataFolder, cfg, logger)
+		if err != nil {
+			logger.Error(fmt.Sprintf("Could not reload datasources in '%s' folder", app.DataFolder), err)
+			metrics.Reloads.Inc("failure")
+			continue
+		}
+		app.Reload(cfg, datasources)
+		metrics.Reloads.Inc("success")
+	}
+
+	if err := certManager.Reload(); err != nil {
+		logger.Error("Could not reload TLS certificate, keeping current certificate", err)
+	}
+}
+
+// shutdown stops accepting connections and waits up to the drain timeout for in-flight
+// requests to complete, then stops the watchers and waits for them to exit
+func shutdown(listeners []*server.Listener, cancel context.CancelFunc, wg *sync.WaitGroup) {
+	drainTimeout := cfg.Server.DrainTimeout
+	if drainTimeout == 0 {
+		drainTimeout = internal.SRV_DRAIN_TIMEOUT
+	}
+
+	ctx, cancelDrain := context.WithTimeout(context.Background(), drainTimeout)
+	defer cancelDrain()
+
+	srvWg := &sync.WaitGroup{}
+	for _, l := range listeners {
+		srvWg.Add(1)
+		go func(l *server.Listener) {
+			defer srvWg.Done()
+			if err := l.Server.Shutdown(ctx); err != nil {
+				logger.Error(fmt.Sprintf("Could not drain %s", l), err)
+			}
+		}(l)
+	}
+	srvWg.Wait()
+
+	cancel()
+	wg.Wait()
+
+	logger.Info("Shutdown complete")
+}
+
+// newApp loads the datasources in dataFolder and watches the folder for changes until ctx is
+// cancelled, returning the handler context which serves them
+func newApp(ctx context.Context, wg *sync.WaitGroup, dataFolder string) *routes.App {
+	if err := os.MkdirAll(filepath.Join(".", dataFolder), os.ModePerm); err != nil {
+		logger.FatalError(fmt.Sprintf("Problem checking/creating '%s' folder", dataFolder), err)
+	}
+
+	datasources, err := file.LoadAndValidateDatasources(dataFolder, cfg, logger)
+	if err != nil {
+		logger.FatalError("Problem loading data sources", err)
+	}
+
+	if len(datasources) == 0 {
+		logger.Warn(fmt.Sprintf("Currently there are no datasources to serve, add JSON or CSV files to the '%s' folder", dataFolder))
+	}
+
+	app := &routes.App{
+		Logger:     logger,
+		Store:      newStore(ctx, wg, dataFolder),
+		Config:     cfg,
+		DataFolder: dataFolder,
+		Version:    version,
+	}
+	app.Load(datasources)
+	app.Prime()
+
+	// add watch to the data folder for hot reload using a goroutine
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if err := watcher.Monitor(ctx, dataFolder, app.Store, logger, app.CurrentConfig, app.SetWatching); err != nil {
+			logger.FatalError("Could not create the file watcher", err)
+		}
+	}()
+
+	// rebuild the cached responses as datasources change
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		app.Follow(ctx)
+	}()
+
+	return app
+}
+
+// newStore creates the store for the datasources of dataFolder, a Redis store is closed when ctx is cancelled
+func newStore(ctx context.Context, wg *sync.WaitGroup, dataFolder string) store.Store {
+	if cfg.Store.Type != config.STORE_REDIS {
+		return store.NewMemory(nil)
+	}
+
+	opts := &redis.Options{
+		Addr:     cfg.Store.Address,
+		Username: cfg.Store.Username,
+		Password: cfg.Store.Password,
+		DB:       cfg.Store.DB,
+	}
+	if cfg.Store.TLS {
+		opts.TLSConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+	}
+	client := redis.NewClient(opts)
+
+	logger.Info(fmt.Sprintf("Sharing '%s' folder datasources using redis at '%s'", dataFolder, cfg.Store.Address))
+	st, err := store.NewRedis(client, cfg.Store.Prefix, dataFolder, logger)
+	if err != nil {
+		logger.FatalError("Problem connecting to the redis store", err)
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		<-ctx.Done()
+		_ = st.Close()
+		_ = client.Close()
+	}()
+
+	return st
+}
+
+// known reports whether any of the apps serves a datasource at the endpoint, the router does not tell
+// the middleware which virtual host matched
+func known(apps []*routes.App) func(string) bool {
+	return func(endpoint string) bool {
+		for _, app := range apps {
+			if app.Known(endpoint) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// registerMetrics adds gauges for the datasources served by each app and the server certificate expiry
+func registerMetrics(apps []*routes.App, certManager *certificate.Manager) {
+	datasourceSamples := func(value func(internal.Datasource) float64) []metrics.Sample {
+		samples := []metrics.Sample{}
+		for _, app := range apps {
+			for _, ds := range app.Snapshot() {
+				samples = append(samples, metrics.Sample{
+					LabelValues: []string{ds.EndpointName, ds.FileName},
+					Value:       value(ds),
+				})
+			}
+		}
+		return samples
+	}
+
+	metrics.Default.Register(
+		metrics.NewGaugeFunc("dujour_datasource_records", "Number of records in each datasource.", func() []metrics.Sample {
+			return datasourceSamples(func(ds internal.Datasource) float64 { return float64(ds.RecordCount()) })
+		}, "datasource", "source"),
+		metrics.NewGaugeFunc("dujour_datasource_bytes", "Size in bytes of the file each datasource was loaded from.", func() []metrics.Sample {
+			return datasourceSamples(func(ds internal.Datasource) float64 { return float64(ds.Size) })
+		}, "datasource", "source"),
+		metrics.NewGaugeFunc("dujour_certificate_expiry_timestamp_seconds", "Expiry time of the server certificate as a Unix timestamp.", func() []metrics.Sample {
+			return []metrics.Sample{{Value: float64(certManager.NotAfter().Unix())}}
+		}),
+	)
+}
@@ -0,0 +1,266 @@
+// Package dujour embeds the Dujour data server in other applications. A Server serves the datasources
+// held in its store, loaded from a data folder by default, as an http.Handler which can be mounted on an
+// existing router
+//
+//	srv, err := dujour.New(dujour.WithDataFolder("fixtures"), dujour.WithPrefix("/options"))
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	go srv.Watch(ctx)
+//	http.Handle("/options/", srv)
+package dujour
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/gorilla/mux"
+	"github.com/spoonboy-io/dujour/internal"
+	"github.com/spoonboy-io/dujour/internal/config"
+	"github.com/spoonboy-io/dujour/internal/file"
+	"github.com/spoonboy-io/dujour/internal/middleware"
+	"github.com/spoonboy-io/dujour/internal/ratelimit"
+	"github.com/spoonboy-io/dujour/internal/routes"
+	"github.com/spoonboy-io/dujour/internal/store"
+	"github.com/spoonboy-io/dujour/internal/watcher"
+	"github.com/spoonboy-io/koan"
+)
+
+const (
+	TYPE_CSV  = internal.TYPE_CSV
+	TYPE_JSON = internal.TYPE_JSON
+)
+
+type (
+	// Logger is the logging used by the server, it is satisfied by *koan.Logger
+	Logger = internal.Logger
+	// Datasource is the data and metadata of a datasource served at its EndpointName
+	Datasource = internal.Datasource
+	// Config is the configuration read from dujour.yaml
+	Config = config.Config
+	// Store holds the datasources served, keyed by the file each was loaded from
+	Store = store.Store
+	// Change is a datasource put in or deleted from a store
+	Change = store.Change
+	// Subscribers delivers the changes of a Store to its subscribers
+	Subscribers = store.Subscribers
+)
+
+const (
+	CHANGE_PUT    = store.CHANGE_PUT
+	CHANGE_DELETE = store.CHANGE_DELETE
+)
+
+var (
+	ErrDatasourceNotFound = store.ErrDatasourceNotFound
+	ErrRecordNotFound     = store.ErrRecordNotFound
+	ErrUnsupportedData    = store.ErrUnsupportedData
+)
+
+// FindRecord returns the record with the id from the data of a datasource, for stores which hold the data
+// as it is loaded
+func FindRecord(data interface{}, id string) (interface{}, error) {
+	return store.FindRecord(data, id)
+}
+
+// NewMemoryStore creates the default store, which keeps the datasources in memory
+func NewMemoryStore(datasources map[string]Datasource) Store {
+	return store.NewMemory(datasources)
+}
+
+// RedisStore is a Store shared through Redis by every server using the same namespace
+type RedisStore = store.Redis
+
+// NewRedisStore creates a store shared by the servers using the namespace, such as the name of the data
+// folder, with keys prefixed by prefix, 'dujour' by default. Close the store before the client
+func NewRedisStore(client redis.UniversalClient, prefix, namespace string, logger Logger) (*RedisStore, error) {
+	return store.NewRedis(client, prefix, namespace, logger)
+}
+
+// LoadConfig reads a configuration file, a missing file returns the default configuration
+func LoadConfig(path string) (*Config, error) {
+	return config.Load(path)
+}
+
+// LoadFolder loads and validates every JSON and CSV file in the data folder, keyed by file name. A file
+// which fails to load is returned with LastError set
+func LoadFolder(dataFolder string, cfg *Config, logger Logger) (map[string]Datasource, error) {
+	return file.LoadAndValidateDatasources(dataFolder, cfg, logger)
+}
+
+// LoadFile loads and validates a single JSON or CSV file
+func LoadFile(fileName string, cfg *Config, logger Logger) (Datasource, error) {
+	return file.LoadAndValidate(file.InitDatasource(fileName), cfg, logger)
+}
+
+// Option configures a Server
+type Option func(*Server)
+
+// WithDataFolder sets the folder the datasources are loaded from, the default is 'data'. An empty folder
+// loads nothing, so only the datasources put in the store are served
+func WithDataFolder(dataFolder string) Option {
+	return func(s *Server) {
+		s.dataFolder = dataFolder
+	}
+}
+
+// WithPrefix serves the routes below the path prefix, so '/people' is served at '/prefix/people'
+func WithPrefix(prefix string) Option {
+	return func(s *Server) {
+		s.prefix = "/" + strings.Trim(prefix, "/")
+	}
+}
+
+// WithLogger sets the logger, the default writes to the console
+func WithLogger(logger Logger) Option {
+	return func(s *Server) {
+		s.logger = logger
+	}
+}
+
+// WithStore sets the store the datasources are held in, the default keeps them in memory
+func WithStore(st Store) Option {
+	return func(s *Server) {
+		s.store = st
+	}
+}
+
+// WithConfig sets the configuration, the default has no masking, CORS or rate limiting rules
+func WithConfig(cfg *Config) Option {
+	return func(s *Server) {
+		s.config = cfg
+	}
+}
+
+// Server serves the datasources in its store
+type Server struct {
+	dataFolder string
+	prefix     string
+	logger     Logger
+	store      Store
+	config     *Config
+
+	app     *routes.App
+	handler http.Handler
+}
+
+// New creates a server, loading the datasources in the data folder into the store. A datasource the store
+// already holds from the same or a newer file is kept
+func New(opts ...Option) (*Server, error) {
+	s := &Server{
+		dataFolder: internal.DATA_FOLDER,
+		logger:     &koan.Logger{},
+		config:     &config.Config{},
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if s.store == nil {
+		s.store = store.NewMemory(nil)
+	}
+
+	s.app = &routes.App{
+		Logger:     s.logger,
+		Store:      s.store,
+		Config:     s.config,
+		DataFolder: s.dataFolder,
+		Version:    "embedded",
+	}
+	if s.dataFolder != "" {
+		datasources, err := file.LoadAndValidateDatasources(s.dataFolder, s.config, s.logger)
+		if err != nil {
+			return nil, fmt.Errorf("Could not load datasources in '%s' folder; %v", s.dataFolder, err)
+		}
+		s.app.Load(datasources)
+	}
+	s.app.Prime()
+
+	router := mux.NewRouter()
+	r := router
+	if s.prefix != "" && s.prefix != "/" {
+		r = router.PathPrefix(s.prefix).Subrouter()
+	}
+	routes.Register(r, s.app)
+	r.Use(middleware.Metrics(s.app.Known), middleware.CORS(s.app.CORSPolicy), middleware.RateLimit(ratelimit.New(), s.app.CurrentConfig))
+	s.handler = middleware.RequestID(router)
+
+	// without a folder to watch the server is ready once created
+	if s.dataFolder == "" {
+		s.app.SetWatching(true)
+	}
+
+	return s, nil
+}
+
+// Handler returns the handler serving the datasources
+func (s *Server) Handler() http.Handler {
+	return s.handler
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.handler.ServeHTTP(w, r)
+}
+
+// Store returns the store holding the datasources, call Changed after modifying it unless Watch is running
+func (s *Server) Store() Store {
+	return s.store
+}
+
+// Config returns the configuration, which is replaced when the server is reloaded
+func (s *Server) Config() *Config {
+	return s.app.CurrentConfig()
+}
+
+// Changed rebuilds the cached responses and OpenAPI document after the store is modified directly
+func (s *Server) Changed() {
+	s.app.Prime()
+}
+
+// Watch reloads datasources as files in the data folder change, and rebuilds the cached responses as
+// the datasources in the store change, it blocks until ctx is cancelled. Without a data folder only the
+// store is followed
+func (s *Server) Watch(ctx context.Context) error {
+	if s.dataFolder == "" {
+		s.app.Follow(ctx)
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.app.Follow(ctx)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	return watcher.Monitor(ctx, s.dataFolder, s.store, s.logger, s.app.CurrentConfig, s.app.SetWatching)
+}
+
+// Reload replaces the configuration and reloads every datasource in the data folder, a datasource the
+// store holds from the same or a newer file is kept and only datasources whose file was removed are deleted
+func (s *Server) Reload(cfg *Config) error {
+	if cfg == nil {
+		cfg = s.app.CurrentConfig()
+	}
+	datasources := map[string]Datasource{}
+	if s.dataFolder != "" {
+		var err error
+		datasources, err = file.LoadAndValidateDatasources(s.dataFolder, cfg, s.logger)
+		if err != nil {
+			return fmt.Errorf("Could not reload datasources in '%s' folder; %v", s.dataFolder, err)
+		}
+	} else {
+		// nothing to reload, keep the datasources put in the store
+		for _, v := range s.store.List() {
+			datasources[v.FileName] = v
+		}
+	}
+	s.app.Reload(cfg, datasources)
+	return nil
+}
@@ -0,0 +1,171 @@
+package dujour_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spoonboy-io/dujour"
+	"github.com/spoonboy-io/dujour/internal/config"
+)
+
+type testLogger struct{}
+
+func (testLogger) Info(string)         {}
+func (testLogger) Warn(string)         {}
+func (testLogger) Error(string, error) {}
+
+func TestServer(t *testing.T) {
+	dataFolder := t.TempDir()
+	people := `[{"id": "a1", "name": "Ann"}, {"id": "b2", "name": "Bob"}]`
+	if err := os.WriteFile(filepath.Join(dataFolder, "people.json"), []byte(people), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	srv, err := dujour.New(dujour.WithDataFolder(dataFolder), dujour.WithPrefix("/options/"), dujour.WithLogger(testLogger{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// datasources can be added to the store alongside those loaded from the folder
+	srv.Store().Put(dujour.Datasource{
+		FileName:     "memory/places.json",
+		FileType:     dujour.TYPE_JSON,
+		EndpointName: "places",
+		Data:         []map[string]interface{}{{"id": "ldn", "name": "London"}},
+	})
+	srv.Changed()
+
+	testCases := []struct {
+		name       string
+		path       string
+		wantStatus int
+		wantBody   string
+	}{
+		{"collection below the prefix", "/options/people", http.StatusOK, `"Bob"`},
+		{"record below the prefix", "/options/people/a1", http.StatusOK, `"Ann"`},
+		{"datasource put in the store", "/options/places/ldn", http.StatusOK, `"London"`},
+		{"unknown datasource", "/options/nothing", http.StatusNotFound, ""},
+		{"path without the prefix", "/people", http.StatusNotFound, ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			srv.ServeHTTP(rr, httptest.NewRequest("GET", tc.path, nil))
+			if rr.Code != tc.wantStatus {
+				t.Errorf("failed got %d wanted %d", rr.Code, tc.wantStatus)
+			}
+			if !strings.Contains(rr.Body.String(), tc.wantBody) {
+				t.Errorf("failed got %s wanted %s", rr.Body.String(), tc.wantBody)
+			}
+		})
+	}
+}
+
+func TestServerRateLimitWithPrefix(t *testing.T) {
+	cfg := &dujour.Config{Server: config.Server{RateLimit: config.RateLimit{RequestsPerSecond: 1, Burst: 1}}}
+	srv, err := dujour.New(dujour.WithDataFolder(t.TempDir()), dujour.WithPrefix("/options"), dujour.WithConfig(cfg), dujour.WithLogger(testLogger{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		name        string
+		path        string
+		wantLimited bool
+	}{
+		{"first request", "/options/list", false},
+		{"limited request", "/options/list", true},
+		{"health probe below the prefix is not limited", "/options/healthz", false},
+		{"readiness probe below the prefix is not limited", "/options/readyz", false},
+		{"metrics below the prefix are not limited", "/options/metrics", false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			srv.ServeHTTP(rr, httptest.NewRequest("GET", tc.path, nil))
+			if gotLimited := rr.Code == http.StatusTooManyRequests; gotLimited != tc.wantLimited {
+				t.Errorf("failed got status %d wanted limited %v", rr.Code, tc.wantLimited)
+			}
+		})
+	}
+}
+
+func TestServerWithoutDataFolder(t *testing.T) {
+	st := dujour.NewMemoryStore(map[string]dujour.Datasource{
+		"memory/colours.json": {
+			FileName:     "memory/colours.json",
+			FileType:     dujour.TYPE_JSON,
+			EndpointName: "colours",
+			Data:         []map[string]interface{}{{"id": "red"}},
+		},
+	})
+
+	srv, err := dujour.New(dujour.WithDataFolder(""), dujour.WithStore(st), dujour.WithLogger(testLogger{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(rr, httptest.NewRequest("GET", "/colours/red", nil))
+	if rr.Code != http.StatusOK {
+		t.Errorf("failed got %d wanted %d", rr.Code, http.StatusOK)
+	}
+
+	// there is no folder to watch, so the server is ready once created
+	rr = httptest.NewRecorder()
+	srv.ServeHTTP(rr, httptest.NewRequest("GET", "/readyz", nil))
+	if rr.Code != http.StatusOK {
+		t.Errorf("failed got %d wanted %d", rr.Code, http.StatusOK)
+	}
+
+	if err := srv.Reload(nil); err != nil {
+		t.Errorf("failed got %v wanted no error", err)
+	}
+	if _, ok := srv.Store().Get("memory/colours.json"); !ok {
+		t.Errorf("failed reload removed datasource put in the store")
+	}
+}
+
+func TestWatchFollowsStore(t *testing.T) {
+	srv, err := dujour.New(dujour.WithDataFolder(""), dujour.WithLogger(testLogger{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- srv.Watch(ctx) }()
+
+	// the OpenAPI document is regenerated once the change is followed
+	srv.Store().Put(dujour.Datasource{
+		FileName:     "memory/colours.json",
+		FileType:     dujour.TYPE_JSON,
+		EndpointName: "colours",
+		Data:         []map[string]interface{}{{"id": "red"}},
+	})
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		rr := httptest.NewRecorder()
+		srv.ServeHTTP(rr, httptest.NewRequest("GET", "/openapi.json", nil))
+		if strings.Contains(rr.Body.String(), "/colours") {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("failed store change was not followed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("failed got %v wanted no error", err)
+	}
+}
@@ -0,0 +1,290 @@
+// Package dujourtest starts a Dujour server for the tests of applications which use one. The server is
+// started with httptest from in-memory fixtures, or from a copy of a data folder, and the datasources can
+// be added, replaced and removed while the test runs. Every request is recorded for assertions
+//
+//	srv := dujourtest.New(t, dujourtest.WithFixture("colours.json", `[{"id": "red"}]`))
+//	client := options.NewClient(srv.URL)
+//	...
+//	srv.Remove("colours.json")
+package dujourtest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/spoonboy-io/dujour"
+	"github.com/spoonboy-io/dujour/internal/file"
+	"github.com/spoonboy-io/dujour/internal/middleware"
+)
+
+// Request is a request received by the server
+type Request struct {
+	Method string
+	Path   string
+	Query  url.Values
+	Header http.Header
+	Status int
+}
+
+type fixture struct {
+	name    string
+	content []byte
+}
+
+type options struct {
+	fixtures   []fixture
+	dataFolder string
+	config     *dujour.Config
+	tls        bool
+	err        error
+}
+
+// Option configures a Server
+type Option func(*options)
+
+// WithFixture adds a datasource, the name is a file name such as 'people.json' or 'people.csv' which sets
+// the endpoint and how the content is parsed
+func WithFixture(name, content string) Option {
+	return func(o *options) {
+		o.fixtures = append(o.fixtures, fixture{name, []byte(content)})
+	}
+}
+
+// WithJSON adds a datasource with the value encoded as JSON, so 'people.json' serves v at '/people'
+func WithJSON(name string, v interface{}) Option {
+	return func(o *options) {
+		content, err := json.Marshal(v)
+		if err != nil {
+			// reported when the server is created
+			o.err = fmt.Errorf("Could not encode fixture '%s'; %v", name, err)
+		}
+		o.fixtures = append(o.fixtures, fixture{name, content})
+	}
+}
+
+// WithDataFolder serves a copy of the data files and schemas in the folder, such as 'testdata'. Fixtures
+// are written to the copy, so schemas in the folder are applied to them
+func WithDataFolder(folder string) Option {
+	return func(o *options) {
+		o.dataFolder = folder
+	}
+}
+
+// WithConfig sets the configuration, for example to add masking or CORS rules
+func WithConfig(cfg *dujour.Config) Option {
+	return func(o *options) {
+		o.config = cfg
+	}
+}
+
+// WithTLS serves HTTPS, the Client of the server trusts its certificate
+func WithTLS() Option {
+	return func(o *options) {
+		o.tls = true
+	}
+}
+
+// Server is a Dujour server listening on a local address, which is closed when the test completes
+type Server struct {
+	*httptest.Server
+
+	t          testing.TB
+	srv        *dujour.Server
+	dataFolder string
+
+	mtx      sync.Mutex
+	requests []Request
+}
+
+// New starts a server, the test fails if a fixture or the data folder cannot be loaded
+func New(t testing.TB, opts ...Option) *Server {
+	t.Helper()
+
+	o := &options{config: &dujour.Config{}}
+	for _, opt := range opts {
+		opt(o)
+	}
+	if o.err != nil {
+		t.Fatal(o.err)
+	}
+
+	s := &Server{t: t}
+	if o.dataFolder != "" {
+		s.dataFolder = t.TempDir()
+		if err := copyFolder(o.dataFolder, s.dataFolder); err != nil {
+			t.Fatalf("Could not copy data folder '%s'; %v", o.dataFolder, err)
+		}
+		for _, v := range o.fixtures {
+			s.writeFile(v.name, v.content)
+		}
+	}
+
+	srv, err := dujour.New(
+		dujour.WithDataFolder(s.dataFolder),
+		dujour.WithConfig(o.config),
+		dujour.WithLogger(logger{t}),
+	)
+	if err != nil {
+		t.Fatalf("Could not create server; %v", err)
+	}
+	s.srv = srv
+
+	if s.dataFolder == "" {
+		for _, v := range o.fixtures {
+			s.put(v.name, v.content)
+		}
+		srv.Changed()
+	}
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := middleware.NewRecorder(w)
+		srv.ServeHTTP(rec, r)
+		s.record(r, rec.Status)
+	})
+	if o.tls {
+		s.Server = httptest.NewTLSServer(handler)
+	} else {
+		s.Server = httptest.NewServer(handler)
+	}
+	t.Cleanup(s.Close)
+
+	return s
+}
+
+// Put adds or replaces the datasource, the name is a file name such as 'people.json'
+func (s *Server) Put(name, content string) {
+	s.t.Helper()
+	s.put(name, []byte(content))
+	s.srv.Changed()
+}
+
+// PutJSON adds or replaces the datasource with the value encoded as JSON
+func (s *Server) PutJSON(name string, v interface{}) {
+	s.t.Helper()
+	content, err := json.Marshal(v)
+	if err != nil {
+		s.t.Fatalf("Could not encode fixture '%s'; %v", name, err)
+	}
+	s.put(name, content)
+	s.srv.Changed()
+}
+
+// Remove removes the datasource added with the name
+func (s *Server) Remove(name string) {
+	s.t.Helper()
+	fileName := name
+	if s.dataFolder != "" {
+		fileName = filepath.Join(s.dataFolder, name)
+		if err := os.Remove(fileName); err != nil && !os.IsNotExist(err) {
+			s.t.Fatalf("Could not remove fixture '%s'; %v", name, err)
+		}
+	}
+	s.srv.Store().Delete(fileName)
+	s.srv.Changed()
+}
+
+// Requests returns the requests received, in the order they were served
+func (s *Server) Requests() []Request {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return append([]Request{}, s.requests...)
+}
+
+// ClearRequests forgets the requests received so far
+func (s *Server) ClearRequests() {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.requests = nil
+}
+
+func (s *Server) record(r *http.Request, status int) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.requests = append(s.requests, Request{
+		Method: r.Method,
+		Path:   r.URL.Path,
+		Query:  r.URL.Query(),
+		Header: r.Header.Clone(),
+		Status: status,
+	})
+}
+
+// put parses the fixture into the store, when serving a data folder the fixture is written to it and
+// loaded as the server would load the file
+func (s *Server) put(name string, content []byte) {
+	s.t.Helper()
+
+	if s.dataFolder != "" {
+		ds, err := dujour.LoadFile(s.writeFile(name, content), s.srv.Config(), logger{s.t})
+		if err != nil {
+			s.t.Fatalf("Could not load fixture '%s'; %v", name, err)
+		}
+		s.srv.Store().Put(ds)
+		return
+	}
+
+	ds, err := file.Parse(file.InitDatasource(name), content)
+	if err != nil {
+		s.t.Fatalf("Could not parse fixture '%s'; %v", name, err)
+	}
+	s.srv.Store().Put(ds)
+}
+
+func (s *Server) writeFile(name string, content []byte) string {
+	s.t.Helper()
+	fileName := filepath.Join(s.dataFolder, name)
+	if err := os.MkdirAll(filepath.Dir(fileName), 0o755); err != nil {
+		s.t.Fatalf("Could not write fixture '%s'; %v", name, err)
+	}
+	if err := os.WriteFile(fileName, content, 0o644); err != nil {
+		s.t.Fatalf("Could not write fixture '%s'; %v", name, err)
+	}
+	return fileName
+}
+
+// copyFolder copies the files in src to dst, keeping the folder structure so schema folders still apply
+func copyFolder(src, dst string) error {
+	return filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		if d.IsDir() {
+			return os.MkdirAll(target, 0o755)
+		}
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(target, content, 0o644)
+	})
+}
+
+// logger writes the server log to the test log
+type logger struct {
+	t testing.TB
+}
+
+func (l logger) Info(msg string) {
+	l.t.Log(msg)
+}
+
+func (l logger) Warn(msg string) {
+	l.t.Log("WARN: " + msg)
+}
+
+func (l logger) Error(msg string, err error) {
+	l.t.Logf("ERROR: %s; %v", msg, err)
+}
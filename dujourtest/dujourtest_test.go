@@ -0,0 +1,99 @@
+package dujourtest_test
+
+import (
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/spoonboy-io/dujour/dujourtest"
+)
+
+func get(t *testing.T, client *http.Client, url string) (int, string) {
+	t.Helper()
+	res, err := client.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res.StatusCode, string(body)
+}
+
+func TestServer(t *testing.T) {
+	srv := dujourtest.New(t,
+		dujourtest.WithFixture("people.json", `[{"id": 1, "name": "Ann"}]`),
+		dujourtest.WithJSON("places.json", []map[string]interface{}{{"id": "ldn", "name": "London"}}),
+	)
+
+	testCases := []struct {
+		name       string
+		change     func()
+		path       string
+		wantStatus int
+		wantBody   string
+	}{
+		{"fixture is served", nil, "/people/1", http.StatusOK, `"Ann"`},
+		{"JSON fixture is served", nil, "/places/ldn", http.StatusOK, `"London"`},
+		{"datasource is replaced", func() { srv.Put("people.json", `[{"id": 1, "name": "Amy"}]`) }, "/people/1", http.StatusOK, `"Amy"`},
+		{"datasource is added", func() { srv.Put("things.csv", "id,name\na,Anvil\n") }, "/things/a", http.StatusOK, `"Anvil"`},
+		{"datasource is removed", func() { srv.Remove("places.json") }, "/places", http.StatusNotFound, ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.change != nil {
+				tc.change()
+			}
+			status, body := get(t, srv.Client(), srv.URL+tc.path)
+			if status != tc.wantStatus {
+				t.Errorf("failed got %d wanted %d", status, tc.wantStatus)
+			}
+			if !strings.Contains(body, tc.wantBody) {
+				t.Errorf("failed got %s wanted %s", body, tc.wantBody)
+			}
+		})
+	}
+
+	requests := srv.Requests()
+	if len(requests) != len(testCases) {
+		t.Fatalf("failed got %d requests wanted %d", len(requests), len(testCases))
+	}
+	if got := requests[0]; got.Method != "GET" || got.Path != "/people/1" || got.Status != http.StatusOK {
+		t.Errorf("failed got %+v wanted GET /people/1 200", got)
+	}
+	if got := requests[len(requests)-1].Status; got != http.StatusNotFound {
+		t.Errorf("failed got %d wanted %d", got, http.StatusNotFound)
+	}
+
+	srv.ClearRequests()
+	if got := len(srv.Requests()); got != 0 {
+		t.Errorf("failed got %d requests wanted none", got)
+	}
+}
+
+func TestServerDataFolder(t *testing.T) {
+	srv := dujourtest.New(t, dujourtest.WithDataFolder("testdata"), dujourtest.WithTLS())
+
+	status, body := get(t, srv.Client(), srv.URL+"/colours?name=Blue")
+	if status != http.StatusOK || !strings.Contains(body, `"blue"`) {
+		t.Errorf("failed got %d %s wanted the blue record", status, body)
+	}
+	if got := srv.Requests()[0].Query.Get("name"); got != "Blue" {
+		t.Errorf("failed got query %q wanted %q", got, "Blue")
+	}
+
+	// changes are made to a copy of the folder
+	srv.Remove("colours.csv")
+	if status, _ := get(t, srv.Client(), srv.URL+"/colours"); status != http.StatusNotFound {
+		t.Errorf("failed got %d wanted %d", status, http.StatusNotFound)
+	}
+	if _, err := os.Stat(filepath.Join("testdata", "colours.csv")); err != nil {
+		t.Errorf("failed original data file was removed; %v", err)
+	}
+}
@@ -3,14 +3,22 @@ module github.com/spoonboy-io/dujour
 go 1.17
 
 require (
+	github.com/alicebob/miniredis/v2 v2.30.0
+	github.com/andybalholm/brotli v1.0.4
 	github.com/fsnotify/fsnotify v1.5.3
+	github.com/go-redis/redis/v8 v8.11.4
 	github.com/gocarina/gocsv v0.0.0-20220422102445-f48ffd81e276
 	github.com/gorilla/mux v1.8.0
 	github.com/spoonboy-io/koan v0.1.0
 	github.com/spoonboy-io/reprise v0.0.1
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
 	github.com/TwiN/go-color v1.1.0 // indirect
+	github.com/alicebob/gopher-json v0.0.0-20200520072559-a9ecdc9d1d3a // indirect
+	github.com/cespare/xxhash/v2 v2.1.2 // indirect
+	github.com/dgryski/go-rendezvous v0.0.0-20200823014737-9f7001d12a5f // indirect
+	github.com/yuin/gopher-lua v0.0.0-20220504180219-658193537a64 // indirect
 	golang.org/x/sys v0.0.0-20210630005230-0f9fa26af87c // indirect
 )
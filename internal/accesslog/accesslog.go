@@ -0,0 +1,278 @@
+// Package accesslog writes structured access log entries in JSON or logfmt format, optionally to a
+// file which is rotated when it reaches a maximum size
+package accesslog
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spoonboy-io/dujour/internal"
+	"github.com/spoonboy-io/dujour/internal/config"
+)
+
+const (
+	LEVEL_DEBUG = "debug"
+	LEVEL_INFO  = "info"
+	LEVEL_WARN  = "warn"
+	LEVEL_ERROR = "error"
+)
+
+var levels = map[string]int{
+	LEVEL_DEBUG: 0,
+	LEVEL_INFO:  1,
+	LEVEL_WARN:  2,
+	LEVEL_ERROR: 3,
+}
+
+// probes are polled by orchestrators and scrapers, their successful requests are logged at debug
+var probes = map[string]bool{
+	"/healthz": true,
+	"/readyz":  true,
+	"/metrics": true,
+}
+
+// Entry is a single access log line
+type Entry struct {
+	Time       time.Time
+	Method     string
+	Path       string
+	Status     int
+	Bytes      int
+	Duration   time.Duration
+	RemoteAddr string
+	RequestID  string
+}
+
+// Level is derived from the response status, server errors are errors and client errors are warnings.
+// Successful health, readiness and metrics probes are debug
+func (e Entry) Level() string {
+	switch {
+	case e.Status >= 500:
+		return LEVEL_ERROR
+	case e.Status >= 400:
+		return LEVEL_WARN
+	case probes[strings.SplitN(e.Path, "?", 2)[0]]:
+		return LEVEL_DEBUG
+	default:
+		return LEVEL_INFO
+	}
+}
+
+// Logger writes entries at or above the configured level
+type Logger struct {
+	mtx    sync.Mutex
+	out    io.Writer
+	format string
+	level  int
+}
+
+// New creates a logger writing to stderr, or to the configured file with rotation. Rotation failures
+// are reported to logger
+func New(cfg config.AccessLog, logger internal.Logger) (*Logger, error) {
+	l := &Logger{
+		out:    os.Stderr,
+		format: cfg.Format,
+		level:  levels[cfg.Level],
+	}
+	if l.format == "" {
+		l.format = config.ACCESS_LOG_LOGFMT
+	}
+	if cfg.Level == "" {
+		l.level = levels[LEVEL_INFO]
+	}
+
+	if cfg.File != "" {
+		rf, err := NewRotatingFile(cfg.File, int64(cfg.MaxSizeMB)*1024*1024, cfg.MaxBackups, logger)
+		if err != nil {
+			return nil, err
+		}
+		l.out = rf
+	}
+
+	return l, nil
+}
+
+// NewWriter creates a logger writing every entry to w in the format, used in tests
+func NewWriter(w io.Writer, format string) *Logger {
+	return &Logger{out: w, format: format}
+}
+
+// Log writes the entry if its level is enabled
+func (l *Logger) Log(e Entry) {
+	if levels[e.Level()] < l.level {
+		return
+	}
+
+	var line string
+	if l.format == config.ACCESS_LOG_JSON {
+		line = formatJSON(e)
+	} else {
+		line = formatLogfmt(e)
+	}
+
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	_, _ = io.WriteString(l.out, line)
+}
+
+// Close closes the log file, if there is one
+func (l *Logger) Close() error {
+	if c, ok := l.out.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+type jsonEntry struct {
+	Time       string  `json:"time"`
+	Level      string  `json:"level"`
+	Method     string  `json:"method"`
+	Path       string  `json:"path"`
+	Status     int     `json:"status"`
+	Bytes      int     `json:"bytes"`
+	DurationMS float64 `json:"duration_ms"`
+	RemoteAddr string  `json:"remote_addr"`
+	RequestID  string  `json:"request_id"`
+}
+
+func formatJSON(e Entry) string {
+	out, _ := json.Marshal(jsonEntry{
+		Time:       e.Time.UTC().Format(time.RFC3339Nano),
+		Level:      e.Level(),
+		Method:     e.Method,
+		Path:       e.Path,
+		Status:     e.Status,
+		Bytes:      e.Bytes,
+		DurationMS: durationMS(e.Duration),
+		RemoteAddr: e.RemoteAddr,
+		RequestID:  e.RequestID,
+	})
+	return string(out) + "\n"
+}
+
+func formatLogfmt(e Entry) string {
+	pairs := [][2]string{
+		{"time", e.Time.UTC().Format(time.RFC3339Nano)},
+		{"level", e.Level()},
+		{"method", e.Method},
+		{"path", e.Path},
+		{"status", strconv.Itoa(e.Status)},
+		{"bytes", strconv.Itoa(e.Bytes)},
+		{"duration_ms", strconv.FormatFloat(durationMS(e.Duration), 'f', -1, 64)},
+		{"remote_addr", e.RemoteAddr},
+		{"request_id", e.RequestID},
+	}
+
+	b := &strings.Builder{}
+	for i, v := range pairs {
+		if i > 0 {
+			b.WriteByte(' ')
+		}
+		b.WriteString(v[0])
+		b.WriteByte('=')
+		b.WriteString(logfmtValue(v[1]))
+	}
+	b.WriteByte('\n')
+	return b.String()
+}
+
+// logfmtValue quotes values which are empty or contain spaces, quotes, equals signs or control characters
+func logfmtValue(v string) string {
+	if v == "" {
+		return `""`
+	}
+	if strings.ContainsAny(v, " =\"\\") || strings.IndexFunc(v, func(r rune) bool { return r < ' ' }) >= 0 {
+		return strconv.Quote(v)
+	}
+	return v
+}
+
+func durationMS(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+// RotatingFile is a file writer which rotates the file when it would exceed maxSize bytes, keeping
+// maxBackups rotated files named file.1 (newest) to file.N
+type RotatingFile struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+	logger     internal.Logger
+
+	file *os.File
+	size int64
+}
+
+// NewRotatingFile opens path for appending, a maxSize of zero disables rotation. Rotation failures are
+// reported to logger and the current file continues to be written
+func NewRotatingFile(path string, maxSize int64, maxBackups int, logger internal.Logger) (*RotatingFile, error) {
+	rf := &RotatingFile{path: path, maxSize: maxSize, maxBackups: maxBackups, logger: logger}
+	if err := rf.open(); err != nil {
+		return nil, err
+	}
+	return rf, nil
+}
+
+func (rf *RotatingFile) open() error {
+	f, err := os.OpenFile(rf.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("Could not open access log '%s'; %v", rf.path, err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+	rf.file = f
+	rf.size = info.Size()
+	return nil
+}
+
+// Write writes b to the file, rotating first if the file would exceed the maximum size, it is
+// not safe for concurrent use and is serialised by the Logger
+func (rf *RotatingFile) Write(b []byte) (int, error) {
+	if rf.maxSize > 0 && rf.size > 0 && rf.size+int64(len(b)) > rf.maxSize {
+		if err := rf.rotate(); err != nil {
+			// keep writing to the current file, rotation is retried once it grows by another maxSize
+			rf.logger.Error("Problem rotating the access log", err)
+			rf.size = 0
+		}
+	}
+
+	n, err := rf.file.Write(b)
+	rf.size += int64(n)
+	return n, err
+}
+
+// rotate renames the file while it is still open and only then opens a new file at path, so when any
+// step fails the writer is left with an open file
+func (rf *RotatingFile) rotate() error {
+	if rf.maxBackups > 0 {
+		// shift the backups, the oldest is overwritten
+		for i := rf.maxBackups - 1; i > 0; i-- {
+			_ = os.Rename(fmt.Sprintf("%s.%d", rf.path, i), fmt.Sprintf("%s.%d", rf.path, i+1))
+		}
+		if err := os.Rename(rf.path, rf.path+".1"); err != nil {
+			return fmt.Errorf("Could not rename access log '%s'; %v", rf.path, err)
+		}
+	} else if err := os.Remove(rf.path); err != nil {
+		return fmt.Errorf("Could not remove access log '%s'; %v", rf.path, err)
+	}
+
+	rotated := rf.file
+	if err := rf.open(); err != nil {
+		return err
+	}
+	return rotated.Close()
+}
+
+// Close closes the file
+func (rf *RotatingFile) Close() error {
+	return rf.file.Close()
+}
@@ -0,0 +1,167 @@
+package accesslog_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/spoonboy-io/koan"
+
+	"github.com/spoonboy-io/dujour/internal/accesslog"
+	"github.com/spoonboy-io/dujour/internal/config"
+)
+
+func TestLog(t *testing.T) {
+	entry := accesslog.Entry{
+		Time:       time.Date(2022, 3, 1, 12, 0, 0, 0, time.UTC),
+		Method:     "GET",
+		Path:       "/people?name=Jo Bloggs",
+		Status:     200,
+		Bytes:      42,
+		Duration:   1500 * time.Microsecond,
+		RemoteAddr: "127.0.0.1:5000",
+		RequestID:  "abc123",
+	}
+
+	t.Run("logfmt", func(t *testing.T) {
+		buf := &bytes.Buffer{}
+		accesslog.NewWriter(buf, config.ACCESS_LOG_LOGFMT).Log(entry)
+
+		want := `time=2022-03-01T12:00:00Z level=info method=GET path="/people?name=Jo Bloggs" status=200 bytes=42 duration_ms=1.5 remote_addr=127.0.0.1:5000 request_id=abc123` + "\n"
+		if got := buf.String(); got != want {
+			t.Errorf("failed got %q wanted %q", got, want)
+		}
+	})
+
+	t.Run("json", func(t *testing.T) {
+		buf := &bytes.Buffer{}
+		accesslog.NewWriter(buf, config.ACCESS_LOG_JSON).Log(entry)
+
+		got := map[string]interface{}{}
+		if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+			t.Fatalf("failed to parse json line: %v", err)
+		}
+		if got["request_id"] != "abc123" || got["status"] != float64(200) || got["duration_ms"] != 1.5 {
+			t.Errorf("failed got %v", got)
+		}
+	})
+}
+
+func TestLevel(t *testing.T) {
+	testCases := []struct {
+		name    string
+		level   string
+		path    string
+		status  int
+		wantLog bool
+	}{
+		{"default level logs success", "", "/people", 200, true},
+		{"warn level skips success", "warn", "/people", 200, false},
+		{"warn level logs client error", "warn", "/people", 404, true},
+		{"error level skips client error", "error", "/people", 404, false},
+		{"error level logs server error", "error", "/people", 500, true},
+		{"default level skips health probe", "", "/healthz", 200, false},
+		{"default level skips metrics scrape", "", "/metrics?name=x", 200, false},
+		{"default level logs failed readiness probe", "", "/readyz", 503, true},
+		{"debug level logs readiness probe", "debug", "/readyz", 200, true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "access.log")
+			l, err := accesslog.New(config.AccessLog{Level: tc.level, File: path}, &koan.Logger{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			l.Log(accesslog.Entry{Path: tc.path, Status: tc.status})
+			_ = l.Close()
+
+			data, _ := os.ReadFile(path)
+			if got := len(data) > 0; got != tc.wantLog {
+				t.Errorf("failed got %v wanted %v", got, tc.wantLog)
+			}
+		})
+	}
+}
+
+func TestRotatingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	rf, err := accesslog.NewRotatingFile(path, 10, 2, &koan.Logger{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, v := range []string{"first\n", "second\n", "third\n", "fourth\n"} {
+		if _, err := rf.Write([]byte(v)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_ = rf.Close()
+
+	testCases := []struct {
+		file string
+		want string
+	}{
+		{path, "fourth\n"},
+		{path + ".1", "third\n"},
+		{path + ".2", "second\n"},
+	}
+	for _, tc := range testCases {
+		data, err := os.ReadFile(tc.file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != tc.want {
+			t.Errorf("failed got %q wanted %q in %s", string(data), tc.want, filepath.Base(tc.file))
+		}
+	}
+
+	if _, err := os.Stat(path + ".3"); err == nil {
+		t.Errorf("failed kept more than %d backups", 2)
+	}
+}
+
+// errorLogger records the errors reported to it
+type errorLogger struct {
+	koan.Logger
+	errors []error
+}
+
+func (l *errorLogger) Error(_ string, err error) {
+	l.errors = append(l.errors, err)
+}
+
+func TestRotatingFileRenameFails(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	logger := &errorLogger{}
+	rf, err := accesslog.NewRotatingFile(path, 10, 1, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a non-empty directory where the backup should go cannot be replaced by the rename
+	if err := os.MkdirAll(filepath.Join(path+".1", "blocked"), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, v := range []string{"first\n", "second\n", "third\n"} {
+		if _, err := rf.Write([]byte(v)); err != nil {
+			t.Fatalf("failed got write error %v wanted logging to continue", err)
+		}
+	}
+	_ = rf.Close()
+
+	if len(logger.errors) == 0 {
+		t.Errorf("failed rotation error was not reported")
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "first\nsecond\nthird\n" {
+		t.Errorf("failed got %q wanted every line in the current file", string(data))
+	}
+}
@@ -1,4 +1,5 @@
-// Package certificate provides code to create and write a self-signed TLS certificate
+// Package certificate provides code to create and write the TLS certificates used by the server, either
+// self-signed or issued by a local certificate authority
 package certificate
 
 import (
@@ -7,47 +8,171 @@ import (
 	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
+	"crypto/tls"
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"encoding/pem"
+	"errors"
 	"fmt"
 	"math/big"
 	"net"
 	"os"
+	"path/filepath"
 	"time"
 
 	"github.com/spoonboy-io/dujour/internal"
-
-	"github.com/spoonboy-io/koan"
+	"github.com/spoonboy-io/dujour/internal/config"
 )
 
-// Make generates a self-signed X.509 certificate for a TLS server, code based on example code
-// from the crypto/tls package found here https://go.dev/src/crypto/tls/generate_cert.go
-func Make(logger *koan.Logger) error {
-	// make private key
-	var priv interface{}
-	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+// Make generates the X.509 certificate and key for a TLS server. By default the certificate is self-signed,
+// in CA mode it is issued by the local certificate authority which is created first if needed. Code based on
+// example code from the crypto/tls package found here https://go.dev/src/crypto/tls/generate_cert.go
+func Make(cfg config.TLS, logger internal.Logger) error {
+	if cfg.Mode == config.TLS_MODE_CA {
+		return issue(cfg, logger)
+	}
+	return selfSign(cfg, logger)
+}
+
+// MakeCA creates the long-lived local certificate authority, unless it already exists
+func MakeCA(cfg config.TLS, logger internal.Logger) error {
+	caCertDest := filepath.Join(internal.TLS_FOLDER, internal.TLS_CA_CERT_FILE)
+	if _, err := os.Stat(caCertDest); err == nil {
+		return nil
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	logger.Info("Creating local certificate authority")
+
+	priv, err := generateKey(cfg.KeyType)
+	if err != nil {
+		return fmt.Errorf("Failed to generate CA private key : %v", err)
+	}
+
+	template, err := newTemplate(internal.TLS_CA_VALID_FOR)
+	if err != nil {
+		return err
+	}
+	template.Subject.CommonName = internal.TLS_CA_NAME
+	template.IsCA = true
+	template.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature
+	template.MaxPathLenZero = true
+
+	derBytes, err := x509.CreateCertificate(rand.Reader, template, template, publicKey(priv), priv)
+	if err != nil {
+		return fmt.Errorf("Failed to create CA certificate: %v", err)
+	}
+
+	if err := writeKey(filepath.Join(internal.TLS_FOLDER, internal.TLS_CA_KEY_FILE), priv, logger); err != nil {
+		return err
+	}
+
+	return writeCertificate(caCertDest, derBytes, logger)
+}
+
+// CACertificate returns the PEM encoded certificate of the local certificate authority
+func CACertificate() ([]byte, error) {
+	return os.ReadFile(filepath.Join(internal.TLS_FOLDER, internal.TLS_CA_CERT_FILE))
+}
+
+// Read parses the first certificate in a PEM encoded certificate file
+func Read(certFile string) (*x509.Certificate, error) {
+	data, err := os.ReadFile(certFile)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return nil, fmt.Errorf("Could not find a certificate in '%s'", certFile)
+		}
+		if block.Type == "CERTIFICATE" {
+			return x509.ParseCertificate(block.Bytes)
+		}
+	}
+}
+
+// selfSign writes a long-lived self-signed server certificate
+func selfSign(cfg config.TLS, logger internal.Logger) error {
+	priv, err := generateKey(cfg.KeyType)
 	if err != nil {
 		return fmt.Errorf("Failed to generate private key : %v", err)
 	}
 
-	// ECDSA, ED25519 and RSA subject keys should have the DigitalSignature
-	// KeyUsage bits set in the x509.Certificate template
-	keyUsage := x509.KeyUsageDigitalSignature
-	if _, isRSA := priv.(*rsa.PrivateKey); isRSA {
-		keyUsage |= x509.KeyUsageKeyEncipherment
+	template, err := newServerTemplate(cfg, priv, internal.TLS_VALID_FOR, logger)
+	if err != nil {
+		return err
+	}
+
+	derBytes, err := x509.CreateCertificate(rand.Reader, template, template, publicKey(priv), priv)
+	if err != nil {
+		return fmt.Errorf("Failed to create certificate: %v", err)
+	}
+
+	return writeServerFiles(derBytes, priv, logger)
+}
+
+// issue writes a short-lived server certificate signed by the local certificate authority
+func issue(cfg config.TLS, logger internal.Logger) error {
+	if err := MakeCA(cfg, logger); err != nil {
+		return err
+	}
+
+	caPair, caCert, err := loadCA()
+	if err != nil {
+		return err
+	}
+
+	priv, err := generateKey(cfg.KeyType)
+	if err != nil {
+		return fmt.Errorf("Failed to generate private key : %v", err)
+	}
+
+	template, err := newServerTemplate(cfg, priv, internal.TLS_CA_LEAF_VALID_FOR, logger)
+	if err != nil {
+		return err
+	}
+
+	derBytes, err := x509.CreateCertificate(rand.Reader, template, caCert, publicKey(priv), caPair.PrivateKey)
+	if err != nil {
+		return fmt.Errorf("Failed to issue certificate: %v", err)
 	}
 
+	logger.Info(fmt.Sprintf("Issued server certificate from '%s' valid until %s", internal.TLS_CA_NAME, template.NotAfter.Format(time.RFC3339)))
+
+	return writeServerFiles(derBytes, priv, logger)
+}
+
+// loadCA reads the key pair and certificate of the local certificate authority
+func loadCA() (tls.Certificate, *x509.Certificate, error) {
+	caPair, err := tls.LoadX509KeyPair(
+		filepath.Join(internal.TLS_FOLDER, internal.TLS_CA_CERT_FILE),
+		filepath.Join(internal.TLS_FOLDER, internal.TLS_CA_KEY_FILE),
+	)
+	if err != nil {
+		return caPair, nil, fmt.Errorf("Failed to load CA certificate/key: %v", err)
+	}
+	caCert, err := x509.ParseCertificate(caPair.Certificate[0])
+	if err != nil {
+		return caPair, nil, fmt.Errorf("Failed to parse CA certificate: %v", err)
+	}
+	return caPair, caCert, nil
+}
+
+// newTemplate creates a certificate template with a random serial number, valid from now for validFor
+func newTemplate(validFor time.Duration) (*x509.Certificate, error) {
 	validFrom := time.Now()
-	validTo := validFrom.Add(internal.TLS_VALID_FOR)
+	validTo := validFrom.Add(validFor)
 
 	serialNumberLimit := new(big.Int).Lsh(big.NewInt(1), 128)
 	serialNumber, err := rand.Int(rand.Reader, serialNumberLimit)
 	if err != nil {
-		return fmt.Errorf("Failed to generate serial number: %v", err)
+		return nil, fmt.Errorf("Failed to generate serial number: %v", err)
 	}
 
-	template := x509.Certificate{
+	return &x509.Certificate{
 		SerialNumber: serialNumber,
 		Subject: pkix.Name{
 			Organization: []string{internal.TLS_ORG},
@@ -55,50 +180,65 @@ func Make(logger *koan.Logger) error {
 		NotBefore: validFrom,
 		NotAfter:  validTo,
 
-		KeyUsage:              keyUsage,
-		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
 		BasicConstraintsValid: true,
-	}
+	}, nil
+}
 
-	// get the preferred outbound IP to register on the certificate
-	conn, err := net.Dial("udp", "8.8.8.8:80")
+// newServerTemplate creates a template for a server certificate including the subject alternative names
+func newServerTemplate(cfg config.TLS, priv interface{}, validFor time.Duration, logger internal.Logger) (*x509.Certificate, error) {
+	template, err := newTemplate(validFor)
 	if err != nil {
-		return fmt.Errorf("Failed making UDP connection: %v", err)
+		return nil, err
+	}
+
+	// ECDSA, ED25519 and RSA subject keys should have the DigitalSignature
+	// KeyUsage bits set in the x509.Certificate template
+	template.KeyUsage = x509.KeyUsageDigitalSignature
+	if _, isRSA := priv.(*rsa.PrivateKey); isRSA {
+		template.KeyUsage |= x509.KeyUsageKeyEncipherment
 	}
-	defer conn.Close()
-	localAddr := conn.LocalAddr().(*net.UDPAddr)
+	template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
 
-	logger.Info(fmt.Sprintf("Using IP address '%v' for certifcate", localAddr.IP))
+	// register the host names and addresses the server can be reached on
+	template.DNSNames, template.IPAddresses = SubjectAltNames(cfg, logger)
 
-	template.IPAddresses = append(template.IPAddresses, localAddr.IP)
+	logger.Info(fmt.Sprintf("Using DNS names %v and IP addresses %v for certificate", template.DNSNames, template.IPAddresses))
 
-	derBytes, err := x509.CreateCertificate(rand.Reader, &template, &template, publicKey(priv), priv)
-	if err != nil {
-		return fmt.Errorf("Failed to create certificate: %v", err)
+	return template, nil
+}
 
+// writeServerFiles writes the server certificate and key to the certificates folder
+func writeServerFiles(derBytes []byte, priv interface{}, logger internal.Logger) error {
+	if err := writeCertificate(filepath.Join(internal.TLS_FOLDER, internal.TLS_CERT_FILE), derBytes, logger); err != nil {
+		return err
 	}
+	return writeKey(filepath.Join(internal.TLS_FOLDER, internal.TLS_KEY_FILE), priv, logger)
+}
 
-	// file targets
-	certDest := fmt.Sprintf("%s/cert.pem", internal.TLS_FOLDER)
-	keyDest := fmt.Sprintf("%s/key.pem", internal.TLS_FOLDER)
+func writeCertificate(dest string, derBytes []byte, logger internal.Logger) error {
+	name := filepath.Base(dest)
 
-	// write the certificate
-	certOut, err := os.Create(certDest)
+	certOut, err := os.Create(dest)
 	if err != nil {
-		return fmt.Errorf("Failed to open cert.pem for writing: %v", err)
+		return fmt.Errorf("Failed to open %s for writing: %v", name, err)
 	}
 	if err := pem.Encode(certOut, &pem.Block{Type: "CERTIFICATE", Bytes: derBytes}); err != nil {
-		return fmt.Errorf("Failed to write data to cert.pem: %v", err)
+		return fmt.Errorf("Failed to write data to %s: %v", name, err)
 	}
 	if err := certOut.Close(); err != nil {
-		return fmt.Errorf("Error closing cert.pem: %v", err)
+		return fmt.Errorf("Error closing %s: %v", name, err)
 	}
 
-	logger.Info("Created 'cert.pem' file")
+	logger.Info(fmt.Sprintf("Created '%s' file", name))
+	return nil
+}
+
+func writeKey(dest string, priv interface{}, logger internal.Logger) error {
+	name := filepath.Base(dest)
 
-	keyOut, err := os.OpenFile(keyDest, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	keyOut, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
 	if err != nil {
-		return fmt.Errorf("Failed to open key.pem for writing: %v", err)
+		return fmt.Errorf("Failed to open %s for writing: %v", name, err)
 	}
 	privBytes, err := x509.MarshalPKCS8PrivateKey(priv)
 	if err != nil {
@@ -106,18 +246,77 @@ func Make(logger *koan.Logger) error {
 	}
 
 	if err := pem.Encode(keyOut, &pem.Block{Type: "PRIVATE KEY", Bytes: privBytes}); err != nil {
-		return fmt.Errorf("Failed to write data to key.pem: %v", err)
+		return fmt.Errorf("Failed to write data to %s: %v", name, err)
 	}
 
 	if err := keyOut.Close(); err != nil {
-		return fmt.Errorf("Error closing key.pem: %v", err)
+		return fmt.Errorf("Error closing %s: %v", name, err)
 	}
 
-	logger.Info("Created 'key.pem' file")
-
+	logger.Info(fmt.Sprintf("Created '%s' file", name))
 	return nil
 }
 
+// SubjectAltNames builds the DNS names and IP addresses to register on a server certificate. Loopback
+// names and addresses and the hostname are always included, along with the addresses of all local
+// interfaces which are up and any extra names and addresses from the configuration. No network
+// connection is made so this works on air-gapped hosts
+func SubjectAltNames(cfg config.TLS, logger internal.Logger) ([]string, []net.IP) {
+	dnsNames := []string{"localhost"}
+	ips := []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback}
+
+	if hostname, err := os.Hostname(); err != nil {
+		logger.Warn(fmt.Sprintf("Could not determine hostname for certificate: %v", err))
+	} else if hostname != "" {
+		dnsNames = append(dnsNames, hostname)
+	}
+
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		logger.Warn(fmt.Sprintf("Could not enumerate network interfaces for certificate: %v", err))
+	}
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 {
+			continue
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			logger.Warn(fmt.Sprintf("Could not read addresses of interface '%s': %v", iface.Name, err))
+			continue
+		}
+		for _, addr := range addrs {
+			if ipNet, ok := addr.(*net.IPNet); ok && !ipNet.IP.IsLinkLocalUnicast() {
+				ips = append(ips, ipNet.IP)
+			}
+		}
+	}
+
+	dnsNames = append(dnsNames, cfg.DNSNames...)
+	for _, v := range cfg.IPAddresses {
+		// addresses are checked when the configuration is validated
+		if ip := net.ParseIP(v); ip != nil {
+			ips = append(ips, ip)
+		}
+	}
+
+	return uniqueNames(dnsNames), uniqueIPs(ips)
+}
+
+// generateKey creates a private key of the requested type, defaulting to ECDSA P-256
+func generateKey(keyType string) (interface{}, error) {
+	switch keyType {
+	case "", config.KEY_TYPE_ECDSA:
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case config.KEY_TYPE_RSA:
+		return rsa.GenerateKey(rand.Reader, internal.TLS_RSA_BITS)
+	case config.KEY_TYPE_ED25519:
+		_, priv, err := ed25519.GenerateKey(rand.Reader)
+		return priv, err
+	default:
+		return nil, fmt.Errorf("unsupported key type '%s'", keyType)
+	}
+}
+
 func publicKey(priv interface{}) interface{} {
 	switch k := priv.(type) {
 	case *rsa.PrivateKey:
@@ -130,3 +329,27 @@ func publicKey(priv interface{}) interface{} {
 		return nil
 	}
 }
+
+func uniqueNames(names []string) []string {
+	seen := map[string]bool{}
+	out := []string{}
+	for _, v := range names {
+		if !seen[v] {
+			seen[v] = true
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+func uniqueIPs(ips []net.IP) []net.IP {
+	seen := map[string]bool{}
+	out := []net.IP{}
+	for _, v := range ips {
+		if !seen[v.String()] {
+			seen[v.String()] = true
+			out = append(out, v)
+		}
+	}
+	return out
+}
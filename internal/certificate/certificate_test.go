@@ -0,0 +1,398 @@
+package certificate
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/spoonboy-io/dujour/internal"
+	"github.com/spoonboy-io/dujour/internal/config"
+	"github.com/spoonboy-io/koan"
+)
+
+func TestSubjectAltNames(t *testing.T) {
+	testLogger := &koan.Logger{}
+	cfg := config.TLS{
+		DNSNames:    []string{"dujour.example", "localhost"},
+		IPAddresses: []string{"10.0.0.10"},
+	}
+
+	gotNames, gotIPs := SubjectAltNames(cfg, testLogger)
+
+	for _, want := range []string{"localhost", "dujour.example"} {
+		found := 0
+		for _, v := range gotNames {
+			if v == want {
+				found++
+			}
+		}
+		if found != 1 {
+			t.Errorf("failed wanted DNS name %s once got %v", want, gotNames)
+		}
+	}
+
+	for _, want := range []string{"127.0.0.1", "::1", "10.0.0.10"} {
+		found := false
+		for _, v := range gotIPs {
+			if v.Equal(net.ParseIP(want)) {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("failed wanted IP address %s got %v", want, gotIPs)
+		}
+	}
+}
+
+func TestGenerateKey(t *testing.T) {
+	testCases := []struct {
+		name    string
+		keyType string
+		wantErr bool
+	}{
+		{"default is ecdsa", "", false},
+		{"ecdsa", config.KEY_TYPE_ECDSA, false},
+		{"rsa", config.KEY_TYPE_RSA, false},
+		{"ed25519", config.KEY_TYPE_ED25519, false},
+		{"unsupported", "dsa", true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			priv, err := generateKey(tc.keyType)
+			if err != nil {
+				if !tc.wantErr {
+					t.Errorf("failed got err %v did not want", err)
+				}
+				return
+			} else if tc.wantErr {
+				t.Fatalf("failed got nil wanted error")
+			}
+
+			switch priv.(type) {
+			case *ecdsa.PrivateKey, *rsa.PrivateKey, ed25519.PrivateKey:
+			default:
+				t.Errorf("failed unexpected key type %T", priv)
+			}
+
+			if publicKey(priv) == nil {
+				t.Errorf("failed no public key for %T", priv)
+			}
+		})
+	}
+}
+
+func TestMakeCAMode(t *testing.T) {
+	testLogger := &koan.Logger{}
+
+	// certificates are written relative to the working directory
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.Chdir(wd) }()
+
+	if err := os.MkdirAll(internal.TLS_FOLDER, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := config.TLS{Mode: config.TLS_MODE_CA}
+	if err := Make(cfg, testLogger); err != nil {
+		t.Fatalf("Make unexpected error: %v", err)
+	}
+
+	caPEM, err := CACertificate()
+	if err != nil {
+		t.Fatalf("CACertificate unexpected error: %v", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caPEM) {
+		t.Fatalf("failed to parse CA certificate")
+	}
+
+	pair, err := tls.LoadX509KeyPair(filepath.Join(internal.TLS_FOLDER, internal.TLS_CERT_FILE), filepath.Join(internal.TLS_FOLDER, internal.TLS_KEY_FILE))
+	if err != nil {
+		t.Fatalf("failed to load server certificate: %v", err)
+	}
+	leaf, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := leaf.Verify(x509.VerifyOptions{DNSName: "localhost", Roots: pool}); err != nil {
+		t.Errorf("failed server certificate does not verify against CA: %v", err)
+	}
+
+	// a second server certificate must be issued by the same CA
+	if err := Make(cfg, testLogger); err != nil {
+		t.Fatalf("Make unexpected error: %v", err)
+	}
+	caPEM2, _ := CACertificate()
+	if string(caPEM) != string(caPEM2) {
+		t.Errorf("failed CA was regenerated")
+	}
+
+	cert, err := Read(filepath.Join(internal.TLS_FOLDER, internal.TLS_CERT_FILE))
+	if err != nil {
+		t.Fatalf("Read unexpected error: %v", err)
+	}
+	if !Owned(cert) || cert.Issuer.CommonName != internal.TLS_CA_NAME {
+		t.Errorf("failed got issuer %v", cert.Issuer)
+	}
+	if _, err := Read(filepath.Join(internal.TLS_FOLDER, internal.TLS_KEY_FILE)); err == nil {
+		t.Errorf("failed key file read as a certificate")
+	}
+}
+
+func TestRenewDue(t *testing.T) {
+	now := time.Now()
+	testCases := []struct {
+		name      string
+		notBefore time.Time
+		notAfter  time.Time
+		wantDue   bool
+	}{
+		{"new certificate", now, now.Add(90 * 24 * time.Hour), false},
+		{"half lifetime remaining", now.Add(-45 * 24 * time.Hour), now.Add(45 * 24 * time.Hour), false},
+		{"quarter lifetime remaining", now.Add(-60 * 24 * time.Hour), now.Add(20 * 24 * time.Hour), true},
+		{"expired", now.Add(-90 * 24 * time.Hour), now.Add(-time.Hour), true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cert := &x509.Certificate{NotBefore: tc.notBefore, NotAfter: tc.notAfter}
+			if got := renewDue(cert, now); got != tc.wantDue {
+				t.Errorf("failed got %v wanted %v", got, tc.wantDue)
+			}
+		})
+	}
+}
+
+func TestManagerRenewsOwnedCertificate(t *testing.T) {
+	testLogger := &koan.Logger{}
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.Chdir(wd) }()
+
+	if err := os.MkdirAll(internal.TLS_FOLDER, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := config.TLS{}
+	if err := Make(cfg, testLogger); err != nil {
+		t.Fatalf("Make unexpected error: %v", err)
+	}
+
+	m, err := NewManager(cfg, testLogger)
+	if err != nil {
+		t.Fatalf("NewManager unexpected error: %v", err)
+	}
+	before, _ := m.GetCertificate(nil)
+
+	// a check well into the certificate lifetime should not renew
+	m.check(time.Now().Add(24 * time.Hour))
+	if got, _ := m.GetCertificate(nil); got != before {
+		t.Errorf("failed certificate renewed too early")
+	}
+
+	// a check close to expiry should renew and load the new certificate
+	m.check(m.NotAfter().Add(-24 * time.Hour))
+	after, _ := m.GetCertificate(nil)
+	if after == before {
+		t.Errorf("failed certificate was not renewed")
+	}
+}
+
+func TestManagerSwitchesToCAMode(t *testing.T) {
+	testLogger := &koan.Logger{}
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.Chdir(wd) }()
+
+	if err := os.MkdirAll(internal.TLS_FOLDER, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+
+	// an existing install with a self-signed certificate
+	if err := Make(config.TLS{}, testLogger); err != nil {
+		t.Fatalf("Make unexpected error: %v", err)
+	}
+
+	cfg := config.TLS{Mode: config.TLS_MODE_CA}
+	m, err := NewManager(cfg, testLogger)
+	if err != nil {
+		t.Fatalf("NewManager unexpected error: %v", err)
+	}
+
+	if _, err := CACertificate(); err != nil {
+		t.Fatalf("failed CA was not created: %v", err)
+	}
+	_, ca, err := loadCA()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := m.GetCertificate(nil)
+	if err := ca.CheckSignature(cert.Leaf.SignatureAlgorithm, cert.Leaf.RawTBSCertificate, cert.Leaf.Signature); err != nil {
+		t.Errorf("failed certificate was not issued by the CA: %v", err)
+	}
+	if cert.Leaf.NotAfter.Sub(cert.Leaf.NotBefore) > internal.TLS_CA_LEAF_VALID_FOR+time.Hour {
+		t.Errorf("failed got certificate valid until %s wanted short-lived CA certificate", cert.Leaf.NotAfter)
+	}
+
+	// switching back re-issues a self-signed certificate
+	m, err = NewManager(config.TLS{}, testLogger)
+	if err != nil {
+		t.Fatalf("NewManager unexpected error: %v", err)
+	}
+	if cert, _ := m.GetCertificate(nil); ca.CheckSignature(cert.Leaf.SignatureAlgorithm, cert.Leaf.RawTBSCertificate, cert.Leaf.Signature) == nil {
+		t.Errorf("failed certificate is still issued by the CA")
+	}
+}
+
+// warnLogger records the warnings logged
+type warnLogger struct {
+	koan.Logger
+	warnings []string
+}
+
+func (l *warnLogger) Warn(msg string) {
+	l.warnings = append(l.warnings, msg)
+}
+
+func TestManagerWarnsOnlyWhenRenewalFails(t *testing.T) {
+	testLogger := &warnLogger{}
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.Chdir(wd) }()
+
+	if err := os.MkdirAll(internal.TLS_FOLDER, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := config.TLS{Mode: config.TLS_MODE_CA}
+	if err := Make(cfg, testLogger); err != nil {
+		t.Fatalf("Make unexpected error: %v", err)
+	}
+	m, err := NewManager(cfg, testLogger)
+	if err != nil {
+		t.Fatalf("NewManager unexpected error: %v", err)
+	}
+
+	// inside the warning period but before renewal is due, the manager will renew the certificate itself
+	m.check(m.NotAfter().Add(-12 * 24 * time.Hour))
+	if len(testLogger.warnings) != 0 {
+		t.Errorf("failed got warnings %v wanted none", testLogger.warnings)
+	}
+
+	// once renewal is due and fails the expiry is reported
+	if err := os.RemoveAll(internal.TLS_FOLDER); err != nil {
+		t.Fatal(err)
+	}
+	m.check(m.NotAfter().Add(-5 * 24 * time.Hour))
+	if len(testLogger.warnings) != 1 {
+		t.Errorf("failed got warnings %v wanted 1", testLogger.warnings)
+	}
+}
+
+func TestManagerSelectsCertificateBySNI(t *testing.T) {
+	testLogger := &koan.Logger{}
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.Chdir(wd) }()
+
+	if err := os.MkdirAll(internal.TLS_FOLDER, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+
+	// create the additional certificate and move it aside before creating the default
+	if err := Make(config.TLS{DNSNames: []string{"inventory.example"}}, testLogger); err != nil {
+		t.Fatalf("Make unexpected error: %v", err)
+	}
+	extra := config.CertificatePair{
+		Cert: filepath.Join(internal.TLS_FOLDER, "inventory.pem"),
+		Key:  filepath.Join(internal.TLS_FOLDER, "inventory-key.pem"),
+	}
+	if err := os.Rename(filepath.Join(internal.TLS_FOLDER, internal.TLS_CERT_FILE), extra.Cert); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(filepath.Join(internal.TLS_FOLDER, internal.TLS_KEY_FILE), extra.Key); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := config.TLS{Certificates: []config.CertificatePair{extra}}
+	if err := Make(cfg, testLogger); err != nil {
+		t.Fatalf("Make unexpected error: %v", err)
+	}
+
+	m, err := NewManager(cfg, testLogger)
+	if err != nil {
+		t.Fatalf("NewManager unexpected error: %v", err)
+	}
+
+	testCases := []struct {
+		name       string
+		serverName string
+		wantName   string
+		wantMatch  bool
+	}{
+		{"virtual host gets its own certificate", "inventory.example", "inventory.example", true},
+		{"unknown host gets the default certificate", "other.example", "inventory.example", false},
+		{"no server name gets the default certificate", "", "inventory.example", false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			hello := &tls.ClientHelloInfo{
+				ServerName:        tc.serverName,
+				SupportedVersions: []uint16{tls.VersionTLS13},
+				SignatureSchemes:  []tls.SignatureScheme{tls.ECDSAWithP256AndSHA256},
+				SupportedCurves:   []tls.CurveID{tls.CurveP256},
+			}
+			got, err := m.GetCertificate(hello)
+			if err != nil {
+				t.Fatalf("GetCertificate unexpected error: %v", err)
+			}
+			leaf, err := x509.ParseCertificate(got.Certificate[0])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if gotMatch := leaf.VerifyHostname(tc.wantName) == nil; gotMatch != tc.wantMatch {
+				t.Errorf("failed certificate names %v, wanted match for %s %v", leaf.DNSNames, tc.wantName, tc.wantMatch)
+			}
+		})
+	}
+}
@@ -0,0 +1,281 @@
+package certificate
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+
+	"github.com/spoonboy-io/dujour/internal"
+	"github.com/spoonboy-io/dujour/internal/config"
+)
+
+// Manager holds the server certificates in memory and serves them through tls.Config.GetCertificate so that
+// new certificate/key pairs take effect without restarting the server or dropping connections. Additional
+// configured certificates are selected by the server name the client requests (SNI). Certificates
+// generated by Dujour are renewed automatically before they expire
+type Manager struct {
+	cfg    config.TLS
+	logger internal.Logger
+
+	mtx   sync.RWMutex
+	cert  *tls.Certificate
+	leaf  *x509.Certificate
+	extra []*tls.Certificate
+}
+
+// NewManager loads the server certificate/key from the certificates folder, renewing it first if
+// it is owned by Dujour and due for renewal or was not issued as the TLS mode requires. In CA mode
+// the local certificate authority is created if it does not exist
+func NewManager(cfg config.TLS, logger internal.Logger) (*Manager, error) {
+	m := &Manager{
+		cfg:    cfg,
+		logger: logger,
+	}
+
+	// an existing install switched to CA mode already has a server certificate
+	if cfg.Mode == config.TLS_MODE_CA {
+		if err := MakeCA(cfg, logger); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := m.Reload(); err != nil {
+		return nil, err
+	}
+	m.check(time.Now())
+
+	return m, nil
+}
+
+// GetCertificate returns the first additional certificate which is valid for the server name requested
+// by the client, or the default server certificate, for use as tls.Config.GetCertificate
+func (m *Manager) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	m.mtx.RLock()
+	defer m.mtx.RUnlock()
+
+	if hello != nil && hello.ServerName != "" {
+		for _, v := range m.extra {
+			if err := hello.SupportsCertificate(v); err == nil {
+				return v, nil
+			}
+		}
+	}
+
+	return m.cert, nil
+}
+
+// NotAfter returns the expiry time of the current server certificate
+func (m *Manager) NotAfter() time.Time {
+	m.mtx.RLock()
+	defer m.mtx.RUnlock()
+	return m.leaf.NotAfter
+}
+
+// Reload reads the certificate/key pairs from disk, the current pairs are kept if any of the files
+// cannot be loaded, for example when only one of them has been replaced so far
+func (m *Manager) Reload() error {
+	pair, err := loadPair(
+		filepath.Join(internal.TLS_FOLDER, internal.TLS_CERT_FILE),
+		filepath.Join(internal.TLS_FOLDER, internal.TLS_KEY_FILE),
+	)
+	if err != nil {
+		return err
+	}
+
+	extra := []*tls.Certificate{}
+	for _, v := range m.cfg.Certificates {
+		extraPair, err := loadPair(v.Cert, v.Key)
+		if err != nil {
+			return err
+		}
+		extra = append(extra, extraPair)
+		m.logger.Info(fmt.Sprintf("Loaded TLS certificate '%s' for %v valid until %s", v.Cert, extraPair.Leaf.DNSNames, extraPair.Leaf.NotAfter.Format(time.RFC3339)))
+	}
+
+	m.mtx.Lock()
+	m.cert = pair
+	m.leaf = pair.Leaf
+	m.extra = extra
+	m.mtx.Unlock()
+
+	m.logger.Info(fmt.Sprintf("Loaded TLS certificate valid until %s", pair.Leaf.NotAfter.Format(time.RFC3339)))
+	return nil
+}
+
+// Monitor watches the certificates folder and reloads the certificate when it changes, and periodically
+// renews certificates owned by Dujour or warns about certificates which will soon expire. It blocks
+// until ctx is cancelled
+func (m *Manager) Monitor(ctx context.Context) error {
+	watchPath := filepath.Join(".", internal.TLS_FOLDER)
+
+	m.logger.Info(fmt.Sprintf("Creating Watcher for '%s' folder", watchPath))
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("Could not create watcher; %v", err)
+	}
+	defer watcher.Close()
+
+	// additional certificates may be stored outside of the certificates folder
+	watched := map[string]bool{
+		filepath.Join(internal.TLS_FOLDER, internal.TLS_CERT_FILE): true,
+		filepath.Join(internal.TLS_FOLDER, internal.TLS_KEY_FILE):  true,
+	}
+	folders := map[string]bool{watchPath: true}
+	for _, v := range m.cfg.Certificates {
+		watched[filepath.Clean(v.Cert)] = true
+		watched[filepath.Clean(v.Key)] = true
+		folders[filepath.Dir(v.Cert)] = true
+		folders[filepath.Dir(v.Key)] = true
+	}
+
+	for folder := range folders {
+		if err := watcher.Add(folder); err != nil {
+			return fmt.Errorf("Adding folder failed; %v", err)
+		}
+	}
+
+	ticker := time.NewTicker(internal.TLS_CHECK_INTERVAL)
+	defer ticker.Stop()
+
+	// the certificate and key are written separately, so wait for writes to settle before reloading
+	var reload *time.Timer
+	for {
+		select {
+		case <-ctx.Done():
+			if reload != nil {
+				reload.Stop()
+			}
+			return nil
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			if !watched[filepath.Clean(event.Name)] {
+				continue
+			}
+			if event.Op&(fsnotify.Create|fsnotify.Write|fsnotify.Rename) == 0 {
+				continue
+			}
+			if reload != nil {
+				reload.Stop()
+			}
+			reload = time.AfterFunc(internal.TLS_RELOAD_DELAY, func() {
+				if err := m.Reload(); err != nil {
+					m.logger.Error("Could not reload TLS certificate, keeping current certificate", err)
+				}
+			})
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			m.logger.Error("Certificate watcher unexpected error:", err)
+		case now := <-ticker.C:
+			m.check(now)
+		}
+	}
+}
+
+// check renews the certificate if Dujour owns it and less than a third of its lifetime remains, and logs
+// a warning as the expiry of a certificate which is not renewed approaches. In CA mode the expiry of the CA itself is also checked
+func (m *Manager) check(now time.Time) {
+	m.mtx.RLock()
+	leaf := m.leaf
+	m.mtx.RUnlock()
+
+	if Owned(leaf) && (renewDue(leaf, now) || !m.issuedForMode(leaf)) {
+		if renewDue(leaf, now) {
+			m.logger.Info(fmt.Sprintf("Renewing TLS certificate which expires %s", leaf.NotAfter.Format(time.RFC3339)))
+		} else {
+			m.logger.Info("Re-issuing TLS certificate which was not issued for the configured TLS mode")
+		}
+		if err := Make(m.cfg, m.logger); err != nil {
+			m.logger.Error("Could not renew TLS certificate", err)
+		} else if err := m.Reload(); err != nil {
+			m.logger.Error("Could not load renewed TLS certificate", err)
+		}
+
+		m.mtx.RLock()
+		leaf = m.leaf
+		m.mtx.RUnlock()
+	}
+
+	// a certificate Dujour renews only warns once renewal is due, which means the renewal failed
+	if !Owned(leaf) || renewDue(leaf, now) {
+		warnExpiry(m.logger, "TLS certificate", leaf, now)
+	}
+
+	m.mtx.RLock()
+	for i, v := range m.extra {
+		warnExpiry(m.logger, fmt.Sprintf("TLS certificate '%s'", m.cfg.Certificates[i].Cert), v.Leaf, now)
+	}
+	m.mtx.RUnlock()
+
+	if m.cfg.Mode == config.TLS_MODE_CA {
+		if _, ca, err := loadCA(); err == nil {
+			warnExpiry(m.logger, "CA certificate", ca, now)
+		}
+	}
+}
+
+// Owned reports whether the certificate was generated by Dujour, certificates supplied by
+// the operator are never replaced
+func Owned(cert *x509.Certificate) bool {
+	for _, v := range cert.Subject.Organization {
+		if v == internal.TLS_ORG {
+			return true
+		}
+	}
+	return false
+}
+
+// issuedForMode reports whether the certificate was issued as the TLS mode requires, by the local CA in CA
+// mode and self-signed otherwise, so the certificate is re-issued when the mode changes
+func (m *Manager) issuedForMode(cert *x509.Certificate) bool {
+	if m.cfg.Mode != config.TLS_MODE_CA {
+		return bytes.Equal(cert.RawIssuer, cert.RawSubject)
+	}
+	_, ca, err := loadCA()
+	if err != nil {
+		return false
+	}
+	return ca.CheckSignature(cert.SignatureAlgorithm, cert.RawTBSCertificate, cert.Signature) == nil
+}
+
+// renewDue reports whether less than a third of the certificate lifetime remains
+func renewDue(cert *x509.Certificate, now time.Time) bool {
+	lifetime := cert.NotAfter.Sub(cert.NotBefore)
+	return cert.NotAfter.Sub(now) < lifetime/3
+}
+
+func warnExpiry(logger internal.Logger, name string, cert *x509.Certificate, now time.Time) {
+	remaining := cert.NotAfter.Sub(now)
+	switch {
+	case remaining <= 0:
+		logger.Warn(fmt.Sprintf("%s expired %s", name, cert.NotAfter.Format(time.RFC3339)))
+	case remaining < internal.TLS_EXPIRY_WARN:
+		logger.Warn(fmt.Sprintf("%s expires %s", name, cert.NotAfter.Format(time.RFC3339)))
+	}
+}
+
+// loadPair reads a certificate/key pair and parses the leaf certificate
+func loadPair(certFile, keyFile string) (*tls.Certificate, error) {
+	pair, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("Could not load certificate/key '%s'; %v", certFile, err)
+	}
+
+	pair.Leaf, err = x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return nil, fmt.Errorf("Could not parse certificate '%s'; %v", certFile, err)
+	}
+
+	return &pair, nil
+}
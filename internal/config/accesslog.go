@@ -0,0 +1,38 @@
+package config
+
+import "fmt"
+
+const (
+	ACCESS_LOG_JSON   = "json"
+	ACCESS_LOG_LOGFMT = "logfmt"
+)
+
+// AccessLog configures the structured access log. By default logfmt lines at info level and above
+// are written to stderr. When File is set the file is rotated at MaxSizeMB keeping MaxBackups files
+type AccessLog struct {
+	Format     string `yaml:"format"`
+	Level      string `yaml:"level"`
+	File       string `yaml:"file"`
+	MaxSizeMB  int    `yaml:"max_size_mb"`
+	MaxBackups int    `yaml:"max_backups"`
+}
+
+func (a AccessLog) validate() error {
+	switch a.Format {
+	case "", ACCESS_LOG_JSON, ACCESS_LOG_LOGFMT:
+	default:
+		return fmt.Errorf("unsupported format '%s'", a.Format)
+	}
+
+	switch a.Level {
+	case "", "debug", "info", "warn", "error":
+	default:
+		return fmt.Errorf("unsupported level '%s'", a.Level)
+	}
+
+	if a.MaxSizeMB < 0 || a.MaxBackups < 0 {
+		return fmt.Errorf("max_size_mb and max_backups cannot be negative")
+	}
+
+	return nil
+}
@@ -0,0 +1,182 @@
+// Package config loads and validates the optional application configuration file
+package config
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/spoonboy-io/dujour/internal/mask"
+)
+
+// Config holds the application configuration, every setting is optional so the zero value
+// is a valid configuration which serves all datasources unmodified
+type Config struct {
+	PrivilegedKeys []string              `yaml:"privileged_keys"`
+	MaskSecret     string                `yaml:"mask_secret"`
+	Datasources    map[string]Datasource `yaml:"datasources"`
+	AccessLog      AccessLog             `yaml:"access_log"`
+	OpenAPI        OpenAPI               `yaml:"openapi"`
+	Server         Server                `yaml:"server"`
+	Store          Store                 `yaml:"store"`
+	TLS            TLS                   `yaml:"tls"`
+	VHosts         []VHost               `yaml:"vhosts"`
+}
+
+// OpenAPI controls the HTML viewer for the OpenAPI document, the document itself is always served
+type OpenAPI struct {
+	Viewer bool `yaml:"viewer"`
+}
+
+// VHost maps a virtual host name to its own data folder, requests for other hosts are
+// served from the default data folder
+type VHost struct {
+	Host       string `yaml:"host"`
+	DataFolder string `yaml:"data_folder"`
+}
+
+// Datasource holds configuration for a single datasource, keyed by endpoint name in Config. When
+// CORS is set it replaces the server policy for the datasource, RateLimit applies in addition to
+// the server limit. SchemaPolicy decides what happens to records which do not match the schema
+type Datasource struct {
+	Mask         []mask.Rule `yaml:"mask"`
+	CORS         *CORS       `yaml:"cors"`
+	RateLimit    *RateLimit  `yaml:"rate_limit"`
+	SchemaPolicy string      `yaml:"schema_policy"`
+}
+
+const (
+	// SCHEMA_POLICY_REJECT rejects the whole file when any record is invalid, the previous version
+	// continues to be served
+	SCHEMA_POLICY_REJECT = "reject"
+	// SCHEMA_POLICY_QUARANTINE serves the valid records and drops the invalid ones
+	SCHEMA_POLICY_QUARANTINE = "quarantine"
+)
+
+// Load reads the configuration file at path, a missing file is not an error and results
+// in the default configuration
+func Load(path string) (*Config, error) {
+	cfg := &Config{}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return cfg, nil
+		}
+		return nil, err
+	}
+
+	if err := yaml.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("Could not parse '%s'; %v", path, err)
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+// Validate checks the configuration for settings which cannot be applied
+func (c *Config) Validate() error {
+	for name, ds := range c.Datasources {
+		for _, rule := range ds.Mask {
+			if err := rule.Validate(); err != nil {
+				return fmt.Errorf("datasource '%s': %v", name, err)
+			}
+			if rule.Action == mask.ACTION_HASH && c.MaskSecret == "" {
+				return fmt.Errorf("datasource '%s': mask rule for field '%s' uses hash, which requires mask_secret", name, rule.Field)
+			}
+		}
+		if ds.CORS != nil {
+			if err := ds.CORS.validate(); err != nil {
+				return fmt.Errorf("datasource '%s': cors: %v", name, err)
+			}
+		}
+		switch ds.SchemaPolicy {
+		case "", SCHEMA_POLICY_REJECT, SCHEMA_POLICY_QUARANTINE:
+		default:
+			return fmt.Errorf("datasource '%s': unsupported schema_policy '%s'", name, ds.SchemaPolicy)
+		}
+		if ds.RateLimit != nil {
+			if err := ds.RateLimit.validate(); err != nil {
+				return fmt.Errorf("datasource '%s': rate_limit: %v", name, err)
+			}
+		}
+	}
+
+	if err := c.AccessLog.validate(); err != nil {
+		return fmt.Errorf("access_log: %v", err)
+	}
+
+	if err := c.Server.validate(); err != nil {
+		return fmt.Errorf("server: %v", err)
+	}
+
+	if err := c.Store.validate(); err != nil {
+		return fmt.Errorf("store: %v", err)
+	}
+
+	if err := c.TLS.validate(); err != nil {
+		return fmt.Errorf("tls: %v", err)
+	}
+
+	hosts := map[string]bool{}
+	for i, v := range c.VHosts {
+		if v.Host == "" || v.DataFolder == "" {
+			return fmt.Errorf("vhosts: entry %d must have both host and data_folder", i+1)
+		}
+		if hosts[strings.ToLower(v.Host)] {
+			return fmt.Errorf("vhosts: host '%s' is configured more than once", v.Host)
+		}
+		hosts[strings.ToLower(v.Host)] = true
+	}
+
+	return nil
+}
+
+// MaskRules returns the masking rules for the datasource served at endpoint, hash rules are keyed with
+// the mask secret
+func (c *Config) MaskRules(endpoint string) []mask.Rule {
+	if c == nil || len(c.Datasources[endpoint].Mask) == 0 {
+		return nil
+	}
+	rules := make([]mask.Rule, len(c.Datasources[endpoint].Mask))
+	for i, v := range c.Datasources[endpoint].Mask {
+		v.Secret = c.MaskSecret
+		rules[i] = v
+	}
+	return rules
+}
+
+// SchemaPolicy returns the policy for records of the datasource served at endpoint which do not
+// match its schema, the default is to reject the file
+func (c *Config) SchemaPolicy(endpoint string) string {
+	if c == nil || c.Datasources[endpoint].SchemaPolicy == "" {
+		return SCHEMA_POLICY_REJECT
+	}
+	return c.Datasources[endpoint].SchemaPolicy
+}
+
+// OpenAPIViewer reports whether the HTML viewer for the OpenAPI document is enabled
+func (c *Config) OpenAPIViewer() bool {
+	return c != nil && c.OpenAPI.Viewer
+}
+
+// IsPrivileged reports whether key is one of the configured privileged keys, privileged keys
+// receive datasource data without masking rules applied
+func (c *Config) IsPrivileged(key string) bool {
+	if c == nil || key == "" {
+		return false
+	}
+	for _, pk := range c.PrivilegedKeys {
+		if subtle.ConstantTimeCompare([]byte(pk), []byte(key)) == 1 {
+			return true
+		}
+	}
+	return false
+}
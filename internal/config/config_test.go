@@ -0,0 +1,180 @@
+package config_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spoonboy-io/dujour/internal/config"
+)
+
+func TestLoad(t *testing.T) {
+	testCases := []struct {
+		name        string
+		content     string
+		writeFile   bool
+		wantErr     bool
+		wantMaskLen int
+	}{
+		{
+			name:      "missing file gives default configuration",
+			writeFile: false,
+			wantErr:   false,
+		},
+		{
+			name:        "valid masking configuration",
+			content:     "datasources:\n  users:\n    mask:\n      - field: serial\n        action: partial\n      - field: password_hint\n        action: drop\n",
+			writeFile:   true,
+			wantErr:     false,
+			wantMaskLen: 2,
+		},
+		{
+			name:      "unsupported masking action",
+			content:   "datasources:\n  users:\n    mask:\n      - field: serial\n        action: encrypt\n",
+			writeFile: true,
+			wantErr:   true,
+		},
+		{
+			name:        "hash rule with mask secret",
+			content:     "mask_secret: a-long-random-secret\ndatasources:\n  users:\n    mask:\n      - field: owner_email\n        action: hash\n",
+			writeFile:   true,
+			wantErr:     false,
+			wantMaskLen: 1,
+		},
+		{
+			name:      "hash rule without mask secret",
+			content:   "datasources:\n  users:\n    mask:\n      - field: owner_email\n        action: hash\n",
+			writeFile: true,
+			wantErr:   true,
+		},
+		{
+			name:      "invalid yaml",
+			content:   "datasources: [",
+			writeFile: true,
+			wantErr:   true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "dujour.yaml")
+			if tc.writeFile {
+				if err := os.WriteFile(path, []byte(tc.content), 0644); err != nil {
+					t.Fatalf("TestLoad could not create the test file: %v", err)
+				}
+			}
+
+			cfg, err := config.Load(path)
+			if err != nil {
+				if !tc.wantErr {
+					t.Errorf("failed got err %v did not want", err)
+				}
+				return
+			} else if tc.wantErr {
+				t.Fatalf("failed got nil wanted error")
+			}
+
+			if got := len(cfg.MaskRules("users")); got != tc.wantMaskLen {
+				t.Errorf("failed got %d mask rules wanted %d", got, tc.wantMaskLen)
+			}
+		})
+	}
+}
+
+func TestIsPrivileged(t *testing.T) {
+	cfg := &config.Config{PrivilegedKeys: []string{"secret-key"}}
+
+	if !cfg.IsPrivileged("secret-key") {
+		t.Errorf("failed configured key was not privileged")
+	}
+	if cfg.IsPrivileged("other-key") {
+		t.Errorf("failed unknown key was privileged")
+	}
+	if cfg.IsPrivileged("") {
+		t.Errorf("failed empty key was privileged")
+	}
+
+	var nilCfg *config.Config
+	if nilCfg.IsPrivileged("secret-key") {
+		t.Errorf("failed nil configuration reported privileged key")
+	}
+}
+
+func TestValidateServerAndTLS(t *testing.T) {
+	testCases := []struct {
+		name    string
+		cfg     config.Config
+		wantErr bool
+	}{
+		{"default configuration", config.Config{}, false},
+		{
+			"redirect to default https listener",
+			config.Config{Server: config.Server{RedirectAddress: ":8080"}},
+			false,
+		},
+		{
+			"redirect without https listener",
+			config.Config{Server: config.Server{
+				Listeners:       []config.Listener{{Type: config.LISTENER_HTTP, Address: ":8080"}},
+				RedirectAddress: ":8081",
+			}},
+			true,
+		},
+		{
+			"unsupported listener type",
+			config.Config{Server: config.Server{Listeners: []config.Listener{{Type: "quic", Address: ":8080"}}}},
+			true,
+		},
+		{
+			"unix listener without path",
+			config.Config{Server: config.Server{Listeners: []config.Listener{{Type: config.LISTENER_UNIX}}}},
+			true,
+		},
+		{"json access log", config.Config{AccessLog: config.AccessLog{Format: config.ACCESS_LOG_JSON, Level: "warn"}}, false},
+		{"unsupported access log format", config.Config{AccessLog: config.AccessLog{Format: "xml"}}, true},
+		{
+			"cors credentials with any origin",
+			config.Config{Server: config.Server{CORS: config.CORS{AllowCredentials: true, AllowedOrigins: []string{"*"}}}},
+			true,
+		},
+		{
+			"datasource cors head method",
+			config.Config{Datasources: map[string]config.Datasource{"users": {CORS: &config.CORS{AllowedMethods: []string{"GET", "head"}}}}},
+			false,
+		},
+		{
+			"datasource cors unsupported method",
+			config.Config{Datasources: map[string]config.Datasource{"users": {CORS: &config.CORS{AllowedMethods: []string{"POST"}}}}},
+			true,
+		},
+		{"negative rate limit", config.Config{Server: config.Server{RateLimit: config.RateLimit{RequestsPerSecond: -1}}}, true},
+		{
+			"trusted proxies",
+			config.Config{Server: config.Server{TrustedProxies: []string{"10.0.0.1", "192.168.0.0/16", "fd00::/8", config.TRUSTED_PROXY_UNIX}}},
+			false,
+		},
+		{"invalid trusted proxy", config.Config{Server: config.Server{TrustedProxies: []string{"10.0.0.0/33"}}}, true},
+		{"unsupported tls version", config.Config{TLS: config.TLS{MinVersion: "1.4"}}, true},
+		{"unknown cipher suite", config.Config{TLS: config.TLS{CipherSuites: []string{"TLS_NOPE"}}}, true},
+		{"invalid certificate ip", config.Config{TLS: config.TLS{IPAddresses: []string{"10.0.0"}}}, true},
+		{"redis store", config.Config{Store: config.Store{Type: config.STORE_REDIS, Address: "localhost:6379"}}, false},
+		{"redis store without address", config.Config{Store: config.Store{Type: config.STORE_REDIS}}, true},
+		{"unsupported store", config.Config{Store: config.Store{Type: "etcd"}}, true},
+		{
+			"duplicate virtual host",
+			config.Config{VHosts: []config.VHost{{Host: "a.example", DataFolder: "a"}, {Host: "A.example", DataFolder: "b"}}},
+			true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.cfg.Validate()
+			if err != nil && !tc.wantErr {
+				t.Errorf("failed got err %v did not want", err)
+			} else if err == nil && tc.wantErr {
+				t.Errorf("failed got nil wanted error")
+			}
+		})
+	}
+}
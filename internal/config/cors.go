@@ -0,0 +1,121 @@
+package config
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// CORS_ANY allows any origin, or any request header when used in AllowedHeaders
+const CORS_ANY = "*"
+
+var (
+	defaultCORSMethods        = []string{http.MethodGet}
+	defaultCORSHeaders        = []string{"X-API-Key", "X-Request-ID", "If-None-Match", "If-Modified-Since"}
+	defaultCORSExposedHeaders = []string{"ETag", "X-Request-ID", "X-RateLimit-Limit", "X-RateLimit-Remaining", "X-RateLimit-Reset", "Retry-After"}
+)
+
+// CORS is the cross-origin resource sharing policy. Unset fields take the defaults, which allow GET
+// requests from any origin sending the headers Dujour understands, without credentials
+type CORS struct {
+	AllowedOrigins   []string      `yaml:"allowed_origins"`
+	AllowedMethods   []string      `yaml:"allowed_methods"`
+	AllowedHeaders   []string      `yaml:"allowed_headers"`
+	ExposedHeaders   []string      `yaml:"exposed_headers"`
+	AllowCredentials bool          `yaml:"allow_credentials"`
+	MaxAge           time.Duration `yaml:"max_age"`
+}
+
+// CORSPolicy returns the policy for requests to the datasource served at endpoint, a datasource policy
+// replaces the server policy. An empty endpoint returns the server policy
+func (c *Config) CORSPolicy(endpoint string) CORS {
+	if c == nil {
+		return CORS{}.withDefaults()
+	}
+	if ds, ok := c.Datasources[endpoint]; ok && ds.CORS != nil {
+		return ds.CORS.withDefaults()
+	}
+	return c.Server.CORS.withDefaults()
+}
+
+// AllowsOrigin reports whether requests from origin are allowed
+func (c CORS) AllowsOrigin(origin string) bool {
+	for _, v := range c.AllowedOrigins {
+		if v == CORS_ANY || strings.EqualFold(v, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowsMethod reports whether the method is allowed, OPTIONS is always allowed
+func (c CORS) AllowsMethod(method string) bool {
+	if method == http.MethodOptions {
+		return true
+	}
+	for _, v := range c.AllowedMethods {
+		if strings.EqualFold(v, method) {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowsHeader reports whether the request header is allowed
+func (c CORS) AllowsHeader(header string) bool {
+	for _, v := range c.AllowedHeaders {
+		if v == CORS_ANY || strings.EqualFold(v, header) {
+			return true
+		}
+	}
+	return false
+}
+
+// AnyOrigin reports whether the wildcard origin can be sent, it cannot be combined with credentials
+func (c CORS) AnyOrigin() bool {
+	if c.AllowCredentials {
+		return false
+	}
+	for _, v := range c.AllowedOrigins {
+		if v == CORS_ANY {
+			return true
+		}
+	}
+	return false
+}
+
+func (c CORS) withDefaults() CORS {
+	if len(c.AllowedOrigins) == 0 {
+		c.AllowedOrigins = []string{CORS_ANY}
+	}
+	if len(c.AllowedMethods) == 0 {
+		c.AllowedMethods = defaultCORSMethods
+	}
+	if len(c.AllowedHeaders) == 0 {
+		c.AllowedHeaders = defaultCORSHeaders
+	}
+	if len(c.ExposedHeaders) == 0 {
+		c.ExposedHeaders = defaultCORSExposedHeaders
+	}
+	return c
+}
+
+func (c CORS) validate() error {
+	for _, v := range c.AllowedOrigins {
+		if v == CORS_ANY && c.AllowCredentials {
+			return fmt.Errorf("allow_credentials cannot be used with allowed origin '*'")
+		}
+	}
+	for _, v := range c.AllowedMethods {
+		switch strings.ToUpper(v) {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+		default:
+			return fmt.Errorf("unsupported method '%s'", v)
+		}
+	}
+	if c.MaxAge < 0 {
+		return fmt.Errorf("max_age cannot be negative")
+	}
+	return nil
+}
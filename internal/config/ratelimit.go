@@ -0,0 +1,45 @@
+package config
+
+import (
+	"fmt"
+	"math"
+)
+
+// RateLimit allows each client RequestsPerSecond on average with bursts of up to Burst requests, which
+// defaults to the rate rounded up. A zero rate disables the limit
+type RateLimit struct {
+	RequestsPerSecond float64 `yaml:"requests_per_second"`
+	Burst             int     `yaml:"burst"`
+}
+
+// Enabled reports whether the limit applies
+func (r RateLimit) Enabled() bool {
+	return r.RequestsPerSecond > 0
+}
+
+// BurstSize returns the configured burst, or the rate rounded up when it is not set
+func (r RateLimit) BurstSize() int {
+	if r.Burst > 0 {
+		return r.Burst
+	}
+	return int(math.Ceil(r.RequestsPerSecond))
+}
+
+// RateLimits returns the server wide limit and the limit for the datasource served at endpoint
+func (c *Config) RateLimits(endpoint string) (RateLimit, RateLimit) {
+	if c == nil {
+		return RateLimit{}, RateLimit{}
+	}
+	var ds RateLimit
+	if v, ok := c.Datasources[endpoint]; ok && v.RateLimit != nil {
+		ds = *v.RateLimit
+	}
+	return c.Server.RateLimit, ds
+}
+
+func (r RateLimit) validate() error {
+	if r.RequestsPerSecond < 0 || r.Burst < 0 {
+		return fmt.Errorf("requests_per_second and burst cannot be negative")
+	}
+	return nil
+}
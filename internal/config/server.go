@@ -0,0 +1,137 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/spoonboy-io/dujour/internal"
+)
+
+const (
+	LISTENER_HTTPS = "https"
+	LISTENER_HTTP  = "http"
+	LISTENER_UNIX  = "unix"
+
+	// TRUSTED_PROXY_UNIX trusts the peer of Unix domain socket connections, which have no IP address
+	TRUSTED_PROXY_UNIX = "unix"
+)
+
+// Server holds the listeners the application serves on, when none are configured a single
+// HTTPS listener is used on the default port. DrainTimeout limits how long shutdown waits
+// for in-flight requests. CORS is the default cross-origin policy and RateLimit the per client
+// limit across all routes. TrustedProxies are the IP addresses and CIDR ranges of proxies whose
+// X-Forwarded-For header identifies the client
+type Server struct {
+	Listeners       []Listener    `yaml:"listeners"`
+	RedirectAddress string        `yaml:"redirect_address"`
+	DrainTimeout    time.Duration `yaml:"drain_timeout"`
+	CORS            CORS          `yaml:"cors"`
+	RateLimit       RateLimit     `yaml:"rate_limit"`
+	TrustedProxies  []string      `yaml:"trusted_proxies"`
+}
+
+// Listener is a single address to serve on, HTTP and HTTPS listeners take a host:port
+// address and Unix domain socket listeners (plain HTTP) take a socket path
+type Listener struct {
+	Type    string `yaml:"type"`
+	Address string `yaml:"address"`
+}
+
+func (s Server) validate() error {
+	for i, v := range s.Listeners {
+		switch v.Type {
+		case LISTENER_HTTPS, LISTENER_HTTP:
+			if _, _, err := net.SplitHostPort(v.Address); err != nil {
+				return fmt.Errorf("listener %d has invalid address '%s'; %v", i+1, v.Address, err)
+			}
+		case LISTENER_UNIX:
+			if v.Address == "" {
+				return fmt.Errorf("listener %d has no socket path", i+1)
+			}
+		default:
+			return fmt.Errorf("listener %d has unsupported type '%s'", i+1, v.Type)
+		}
+	}
+
+	if s.DrainTimeout < 0 {
+		return fmt.Errorf("drain_timeout cannot be negative")
+	}
+
+	if err := s.CORS.validate(); err != nil {
+		return fmt.Errorf("cors: %v", err)
+	}
+
+	if err := s.RateLimit.validate(); err != nil {
+		return fmt.Errorf("rate_limit: %v", err)
+	}
+
+	for _, v := range s.TrustedProxies {
+		if v == TRUSTED_PROXY_UNIX || net.ParseIP(v) != nil {
+			continue
+		}
+		if _, _, err := net.ParseCIDR(v); err != nil {
+			return fmt.Errorf("trusted_proxies: invalid address or range '%s'", v)
+		}
+	}
+
+	if s.RedirectAddress != "" {
+		if _, _, err := net.SplitHostPort(s.RedirectAddress); err != nil {
+			return fmt.Errorf("invalid redirect_address '%s'; %v", s.RedirectAddress, err)
+		}
+		if s.HTTPSPort() == "" {
+			return fmt.Errorf("redirect_address requires an https listener")
+		}
+	}
+
+	return nil
+}
+
+// ActiveListeners returns the configured listeners, or the default HTTPS listener when none are configured
+func (s Server) ActiveListeners() []Listener {
+	if len(s.Listeners) == 0 {
+		return []Listener{{Type: LISTENER_HTTPS, Address: net.JoinHostPort(internal.SRV_HOST, internal.SRV_PORT)}}
+	}
+	return s.Listeners
+}
+
+// HTTPSPort returns the port of the first HTTPS listener, or empty if there is none
+func (s Server) HTTPSPort() string {
+	for _, v := range s.ActiveListeners() {
+		if v.Type == LISTENER_HTTPS {
+			if _, port, err := net.SplitHostPort(v.Address); err == nil {
+				return port
+			}
+		}
+	}
+	return ""
+}
+
+// TrustsProxy reports whether the host a request was received from is a trusted proxy. A host which is
+// not an IP address is the peer of a Unix domain socket, which is trusted only when TRUSTED_PROXY_UNIX
+// is listed
+func (c *Config) TrustsProxy(host string) bool {
+	if c == nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	for _, v := range c.Server.TrustedProxies {
+		switch {
+		case v == TRUSTED_PROXY_UNIX:
+			if ip == nil {
+				return true
+			}
+		case ip == nil:
+		case strings.Contains(v, "/"):
+			if _, network, err := net.ParseCIDR(v); err == nil && network.Contains(ip) {
+				return true
+			}
+		default:
+			if ip.Equal(net.ParseIP(v)) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,41 @@
+package config
+
+import "fmt"
+
+const (
+	STORE_MEMORY = "memory"
+	STORE_REDIS  = "redis"
+)
+
+// Store selects where the loaded datasources are held. By default each instance keeps its own in
+// memory, with Redis instances behind a load balancer share the datasources and are notified of
+// changes. Keys are prefixed with Prefix, 'dujour' by default
+type Store struct {
+	Type     string `yaml:"type"`
+	Address  string `yaml:"address"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	DB       int    `yaml:"db"`
+	Prefix   string `yaml:"prefix"`
+	TLS      bool   `yaml:"tls"`
+}
+
+func (s Store) validate() error {
+	switch s.Type {
+	case "", STORE_MEMORY:
+		if s.Address != "" {
+			return fmt.Errorf("address is only used by the redis store")
+		}
+	case STORE_REDIS:
+		if s.Address == "" {
+			return fmt.Errorf("redis store requires an address")
+		}
+		if s.DB < 0 {
+			return fmt.Errorf("db cannot be negative")
+		}
+	default:
+		return fmt.Errorf("unsupported type '%s'", s.Type)
+	}
+
+	return nil
+}
@@ -0,0 +1,120 @@
+package config
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+)
+
+const (
+	KEY_TYPE_ECDSA   = "ecdsa"
+	KEY_TYPE_RSA     = "rsa"
+	KEY_TYPE_ED25519 = "ed25519"
+
+	TLS_MODE_SELF_SIGNED = "self-signed"
+	TLS_MODE_CA          = "ca"
+)
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// TLS holds settings used when Dujour generates its own certificate, any additional certificates
+// which are selected by the server name (SNI) requested by the client, and the TLS policy
+type TLS struct {
+	Mode         string            `yaml:"mode"`
+	KeyType      string            `yaml:"key_type"`
+	DNSNames     []string          `yaml:"dns_names"`
+	IPAddresses  []string          `yaml:"ip_addresses"`
+	Certificates []CertificatePair `yaml:"certificates"`
+	MinVersion   string            `yaml:"min_version"`
+	CipherSuites []string          `yaml:"cipher_suites"`
+	HSTS         HSTS              `yaml:"hsts"`
+}
+
+// CertificatePair is the location of an additional certificate and its key
+type CertificatePair struct {
+	Cert string `yaml:"cert"`
+	Key  string `yaml:"key"`
+}
+
+// HSTS configures the Strict-Transport-Security header sent on HTTPS responses, the
+// header is only sent when MaxAge is set
+type HSTS struct {
+	MaxAge            int  `yaml:"max_age"`
+	IncludeSubdomains bool `yaml:"include_subdomains"`
+}
+
+func (t TLS) validate() error {
+	switch t.Mode {
+	case "", TLS_MODE_SELF_SIGNED, TLS_MODE_CA:
+	default:
+		return fmt.Errorf("unsupported mode '%s'", t.Mode)
+	}
+
+	switch t.KeyType {
+	case "", KEY_TYPE_ECDSA, KEY_TYPE_RSA, KEY_TYPE_ED25519:
+	default:
+		return fmt.Errorf("unsupported key_type '%s'", t.KeyType)
+	}
+
+	for _, v := range t.IPAddresses {
+		if net.ParseIP(v) == nil {
+			return fmt.Errorf("invalid ip address '%s'", v)
+		}
+	}
+
+	for i, v := range t.Certificates {
+		if v.Cert == "" || v.Key == "" {
+			return fmt.Errorf("certificate %d must have both cert and key", i+1)
+		}
+	}
+
+	if _, ok := tlsVersions[t.MinVersion]; t.MinVersion != "" && !ok {
+		return fmt.Errorf("unsupported min_version '%s'", t.MinVersion)
+	}
+
+	if _, err := t.CipherSuiteIDs(); err != nil {
+		return err
+	}
+
+	if t.HSTS.MaxAge < 0 {
+		return fmt.Errorf("hsts max_age cannot be negative")
+	}
+
+	return nil
+}
+
+// Version returns the minimum TLS version, TLS 1.2 unless configured
+func (t TLS) Version() uint16 {
+	if v, ok := tlsVersions[t.MinVersion]; ok {
+		return v
+	}
+	return tls.VersionTLS12
+}
+
+// CipherSuiteIDs returns the IDs of the configured cipher suites, nil means the Go defaults
+// are used. Only suites without known security issues can be configured
+func (t TLS) CipherSuiteIDs() ([]uint16, error) {
+	if len(t.CipherSuites) == 0 {
+		return nil, nil
+	}
+
+	known := map[string]uint16{}
+	for _, v := range tls.CipherSuites() {
+		known[v.Name] = v.ID
+	}
+
+	ids := []uint16{}
+	for _, v := range t.CipherSuites {
+		id, ok := known[v]
+		if !ok {
+			return nil, fmt.Errorf("unsupported cipher suite '%s'", v)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
@@ -0,0 +1,149 @@
+package file
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+)
+
+const (
+	FORMAT_JSON   = "json"
+	FORMAT_CSV    = "csv"
+	FORMAT_NDJSON = "ndjson"
+)
+
+// Export writes the data of a datasource in the format. JSON keeps the data as it is served, CSV and
+// newline delimited JSON write one line per record. CSV columns are the fields of every record with id
+// first, and nested values are written as JSON
+func Export(w io.Writer, data interface{}, format string) error {
+	switch format {
+	case FORMAT_JSON:
+		out, err := json.MarshalIndent(data, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(append(out, '\n'))
+		return err
+	case FORMAT_NDJSON:
+		records, err := exportRecords(data)
+		if err != nil {
+			return err
+		}
+		enc := json.NewEncoder(w)
+		for _, v := range records {
+			if err := enc.Encode(v); err != nil {
+				return err
+			}
+		}
+		return nil
+	case FORMAT_CSV:
+		records, err := exportRecords(data)
+		if err != nil {
+			return err
+		}
+		return writeCSV(w, records)
+	default:
+		return fmt.Errorf("unsupported format '%s'", format)
+	}
+}
+
+// exportRecords returns the records of an array datasource, or of an object datasource with a single top
+// level array of objects
+func exportRecords(data interface{}) ([]map[string]interface{}, error) {
+	records := []map[string]interface{}{}
+	switch t := data.(type) {
+	case []map[string]string:
+		for _, v := range t {
+			record := map[string]interface{}{}
+			for k, f := range v {
+				record[k] = f
+			}
+			records = append(records, record)
+		}
+	case []map[string]interface{}:
+		records = t
+	case map[string]interface{}:
+		var arr []interface{}
+		for _, v := range t {
+			if a, ok := v.([]interface{}); ok {
+				if arr != nil {
+					return nil, fmt.Errorf("object has more than one array of records")
+				}
+				arr = a
+			}
+		}
+		if arr == nil {
+			return nil, fmt.Errorf("object has no array of records")
+		}
+		for _, v := range arr {
+			record, ok := v.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("array contains values which are not records")
+			}
+			records = append(records, record)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported data type %T", data)
+	}
+	return records, nil
+}
+
+func writeCSV(w io.Writer, records []map[string]interface{}) error {
+	seen := map[string]bool{}
+	columns := []string{}
+	for _, r := range records {
+		for k := range r {
+			if !seen[k] {
+				seen[k] = true
+				columns = append(columns, k)
+			}
+		}
+	}
+	sort.Slice(columns, func(i, j int) bool {
+		if columns[i] == "id" || columns[j] == "id" {
+			return columns[i] == "id"
+		}
+		return columns[i] < columns[j]
+	})
+
+	cw := csv.NewWriter(w)
+	if err := cw.Write(columns); err != nil {
+		return err
+	}
+	for _, r := range records {
+		row := make([]string, len(columns))
+		for i, c := range columns {
+			value, err := csvValue(r[c])
+			if err != nil {
+				return err
+			}
+			row[i] = value
+		}
+		if err := cw.Write(row); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func csvValue(v interface{}) (string, error) {
+	switch t := v.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return t, nil
+	case bool:
+		return strconv.FormatBool(t), nil
+	case float64:
+		return strconv.FormatFloat(t, 'f', -1, 64), nil
+	case int:
+		return strconv.Itoa(t), nil
+	default:
+		out, err := json.Marshal(t)
+		return string(out), err
+	}
+}
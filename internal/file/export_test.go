@@ -0,0 +1,80 @@
+package file_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/spoonboy-io/dujour/internal/file"
+)
+
+func TestExport(t *testing.T) {
+	testCases := []struct {
+		name    string
+		data    interface{}
+		format  string
+		want    string
+		wantErr bool
+	}{
+		{
+			"csv records to csv",
+			[]map[string]string{{"name": "Test", "id": "1"}},
+			file.FORMAT_CSV,
+			"id,name\n1,Test\n",
+			false,
+		},
+		{
+			"json records to csv",
+			[]map[string]interface{}{
+				{"id": float64(1), "name": "Test, Jr", "tags": []interface{}{"a"}},
+				{"id": float64(2), "active": true},
+			},
+			file.FORMAT_CSV,
+			"id,active,name,tags\n1,,\"Test, Jr\",\"[\"\"a\"\"]\"\n2,true,,\n",
+			false,
+		},
+		{
+			"json object to ndjson",
+			map[string]interface{}{"count": float64(2), "result": []interface{}{
+				map[string]interface{}{"id": "a"},
+				map[string]interface{}{"id": "b"},
+			}},
+			file.FORMAT_NDJSON,
+			"{\"id\":\"a\"}\n{\"id\":\"b\"}\n",
+			false,
+		},
+		{
+			"csv records to json",
+			[]map[string]string{{"id": "1"}},
+			file.FORMAT_JSON,
+			"[\n  {\n    \"id\": \"1\"\n  }\n]\n",
+			false,
+		},
+		{
+			"object without records",
+			map[string]interface{}{"name": "settings"},
+			file.FORMAT_CSV,
+			"",
+			true,
+		},
+		{
+			"unsupported format",
+			[]map[string]string{{"id": "1"}},
+			"xml",
+			"",
+			true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			buf := &bytes.Buffer{}
+			err := file.Export(buf, tc.data, tc.format)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("failed got err %v wanted error %v", err, tc.wantErr)
+			}
+			if !tc.wantErr && buf.String() != tc.want {
+				t.Errorf("failed got %q wanted %q", buf.String(), tc.want)
+			}
+		})
+	}
+}
@@ -3,24 +3,27 @@ package file
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io/fs"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/gocarina/gocsv"
 
 	"github.com/spoonboy-io/dujour/internal"
-	"github.com/spoonboy-io/koan"
+	"github.com/spoonboy-io/dujour/internal/config"
 )
 
 // FindFiles identifies all JSON and CSV files in the target dataFolder, files which
 // are not JSON or CSV (as determined by the extension) will be skipped but logged
-func FindFiles(dataFolder string, logger *koan.Logger) ([]string, error) {
+func FindFiles(dataFolder string, logger internal.Logger) ([]string, error) {
 	var files []string
-	dataPath := filepath.Join(".", dataFolder)
+	dataPath := filepath.Clean(dataFolder)
 	_ = filepath.WalkDir(dataPath, func(s string, f fs.DirEntry, err error) error {
 		if err != nil {
 			return err
@@ -28,6 +31,11 @@ func FindFiles(dataFolder string, logger *koan.Logger) ([]string, error) {
 
 		extension := strings.ToLower(filepath.Ext(f.Name()))
 
+		if IsSchemaFile(f.Name()) {
+			// schemas are applied to the data file of the same name
+			return nil
+		}
+
 		if (extension == ".csv") || (extension == ".json") {
 			files = append(files, s)
 		} else if extension != "" {
@@ -62,7 +70,7 @@ func InitDatasource(file string) internal.Datasource {
 }
 
 // LoadAndValidateDatasources finds, loads and validates all data at application startup
-func LoadAndValidateDatasources(dataFolder string, logger *koan.Logger) (map[string]internal.Datasource, error) {
+func LoadAndValidateDatasources(dataFolder string, cfg *config.Config, logger internal.Logger) (map[string]internal.Datasource, error) {
 	datasources := map[string]internal.Datasource{}
 
 	logger.Info("Loading datasources")
@@ -74,10 +82,11 @@ func LoadAndValidateDatasources(dataFolder string, logger *koan.Logger) (map[str
 
 	for _, fv := range files {
 		ds := InitDatasource(fv)
-		ds, err := LoadAndValidate(ds, logger)
+		ds, err := LoadAndValidate(ds, cfg, logger)
 		if err != nil {
+			// keep the failed datasource so its error can be reported
 			logger.Error(fmt.Sprintf("Could not load datasource '%s'", fv), err)
-			continue
+			ds.LastError = err.Error()
 		}
 		datasources[fv] = ds
 	}
@@ -86,19 +95,54 @@ func LoadAndValidateDatasources(dataFolder string, logger *koan.Logger) (map[str
 }
 
 // LoadAndValidate performs the load and validation at the individual datasource level for both JSON and CSV
-// file formats, it also logs non fatal warnings and errors which may prevent proper parsing of a datasource
-func LoadAndValidate(ds internal.Datasource, logger *koan.Logger) (internal.Datasource, error) {
-	var err error
-	var data []byte
+// file formats, it also logs non fatal warnings and errors which may prevent proper parsing of a datasource.
+// When the datasource has a schema its records are validated according to the configured schema policy.
+// Datasources whose endpoint is reserved by the server are refused
+func LoadAndValidate(ds internal.Datasource, cfg *config.Config, logger internal.Logger) (internal.Datasource, error) {
+	if internal.IsReserved(ds.EndpointName) {
+		return ds, fmt.Errorf("endpoint '/%s' is reserved by the server, the file must be renamed to be served", ds.EndpointName)
+	}
+
+	ds, err := Load(ds)
+	if err != nil {
+		return ds, err
+	}
+
+	ds, err = applySchema(ds, cfg.SchemaPolicy(ds.EndpointName), logger)
+	if err != nil {
+		// invalid data is never served
+		ds.Data = nil
+		return ds, err
+	}
+
+	logger.Info(fmt.Sprintf("Successfully loaded file '%s'", ds.FileName))
+	return ds, nil
+}
+
+// Load reads and parses the data file of the datasource, without validating it against a schema
+func Load(ds internal.Datasource) (internal.Datasource, error) {
+	data, err := os.ReadFile(ds.FileName)
+	if err != nil {
+		return ds, err
+	}
+
+	ds, err = Parse(ds, data)
+	if err != nil {
+		return ds, err
+	}
 
+	if info, err := os.Stat(ds.FileName); err == nil {
+		ds.ModTime = info.ModTime()
+	}
+
+	return ds, nil
+}
+
+// Parse sets the data of the datasource from the content of a JSON or CSV file, along with its hash and size
+func Parse(ds internal.Datasource, data []byte) (internal.Datasource, error) {
 	switch ds.FileType {
 	case internal.TYPE_CSV:
 		// load the CSV data
-		data, err = os.ReadFile(ds.FileName)
-		if err != nil {
-			return ds, err
-		}
-
 		rdr := bytes.NewReader(data)
 		mp, err := gocsv.CSVToMaps(rdr)
 		if err != nil {
@@ -107,17 +151,11 @@ func LoadAndValidate(ds internal.Datasource, logger *koan.Logger) (internal.Data
 
 		ds.Data = mp
 	case internal.TYPE_JSON:
-		// load the JSON data
-		data, err = os.ReadFile(ds.FileName)
-		if err != nil {
-			return ds, err
-		}
-
 		// we potentially need to handle array and object when dealing with unknown JSON
 		arr := []map[string]interface{}{}
 		obj := map[string]interface{}{}
 
-		if err = json.Unmarshal(data, &arr); err != nil {
+		if err := json.Unmarshal(data, &arr); err != nil {
 			// we 'may' have an object or it could just be bad data
 			if err = json.Unmarshal(data, &obj); err != nil {
 				return ds, err
@@ -130,6 +168,10 @@ func LoadAndValidate(ds internal.Datasource, logger *koan.Logger) (internal.Data
 		}
 	}
 
-	logger.Info(fmt.Sprintf("Successfully loaded file '%s'", ds.FileName))
+	sum := sha256.Sum256(data)
+	ds.Hash = hex.EncodeToString(sum[:])
+	ds.Size = int64(len(data))
+	ds.LoadedAt = time.Now()
+
 	return ds, nil
 }
@@ -9,6 +9,7 @@ import (
 
 	"github.com/spoonboy-io/dujour/internal"
 
+	"github.com/spoonboy-io/dujour/internal/config"
 	"github.com/spoonboy-io/dujour/internal/file"
 	"github.com/spoonboy-io/koan"
 )
@@ -35,6 +36,12 @@ func TestFindFiles(t *testing.T) {
 			[]string{"file1.csv", "text.txt", "file3.json", "excel,xls"},
 			[]string{"data/file1.csv", "data/file3.json"},
 		},
+		{
+			"schema files are not datasources",
+			"data",
+			[]string{"users.csv", "users.schema.json"},
+			[]string{"data/users.csv"},
+		},
 	}
 
 	for _, tc := range testCases {
@@ -223,6 +230,23 @@ func TestLoadAndValidate(t *testing.T) {
 			},
 			wantErr: false,
 		},
+		{
+			name:            "a file at a reserved endpoint is refused",
+			dataFolder:      "data",
+			testFile:        "metrics.json",
+			testFileContent: "[{\"id\": 1}]",
+			testDatasource: internal.Datasource{
+				FileName:     "data/metrics.json",
+				FileType:     internal.TYPE_JSON,
+				EndpointName: "metrics",
+			},
+			wantDatasource: internal.Datasource{
+				FileName:     "data/metrics.json",
+				FileType:     internal.TYPE_JSON,
+				EndpointName: "metrics",
+			},
+			wantErr: true,
+		},
 	}
 
 	for _, tc := range testCases {
@@ -237,7 +261,7 @@ func TestLoadAndValidate(t *testing.T) {
 				t.Fatalf("TestLoadAndValidate could not create the test file: %v", err)
 			}
 
-			gotDatasource, err := file.LoadAndValidate(tc.testDatasource, testLogger)
+			gotDatasource, err := file.LoadAndValidate(tc.testDatasource, nil, testLogger)
 
 			if err != nil {
 				if !tc.wantErr {
@@ -266,6 +290,170 @@ func TestLoadAndValidate(t *testing.T) {
 	}
 }
 
+func TestLoadAndValidateDatasources(t *testing.T) {
+	testLogger := &koan.Logger{}
+	dataFolder := "data"
+
+	if err := makeTestFolder(dataFolder); err != nil {
+		t.Fatalf("TestLoadAndValidateDatasources could not create the test folder: %v", err)
+	}
+	defer func() {
+		if err := removeTestFolder(dataFolder); err != nil {
+			t.Fatalf("TestLoadAndValidateDatasources remove test folder %v", err)
+		}
+	}()
+
+	if err := createTestFileWithContent("good.json", "[{\"id\": 1}]", dataFolder); err != nil {
+		t.Fatalf("TestLoadAndValidateDatasources could not create the test file: %v", err)
+	}
+	if err := createTestFileWithContent("broken.json", "[{\"id\": 1", dataFolder); err != nil {
+		t.Fatalf("TestLoadAndValidateDatasources could not create the test file: %v", err)
+	}
+
+	datasources, err := file.LoadAndValidateDatasources(dataFolder, nil, testLogger)
+	if err != nil {
+		t.Fatalf("LoadAndValidateDatasources unexpected error: %v", err)
+	}
+
+	good := datasources["data/good.json"]
+	if good.LastError != "" || !good.Available() || good.Hash == "" || good.LoadedAt.IsZero() {
+		t.Errorf("failed good datasource not loaded correctly got %+v", good)
+	}
+
+	broken, ok := datasources["data/broken.json"]
+	if !ok {
+		t.Fatalf("failed broken datasource should be kept to report its error")
+	}
+	if broken.LastError == "" || broken.Available() {
+		t.Errorf("failed broken datasource should have an error and not be available got %+v", broken)
+	}
+}
+
+func TestLoadAndValidateSchema(t *testing.T) {
+	testLogger := &koan.Logger{}
+	dataFolder := "data"
+	schemaContent := `{
+		"type": "object",
+		"required": ["id", "age"],
+		"properties": {
+			"id": {"type": "integer"},
+			"age": {"type": "integer", "minimum": 0}
+		}
+	}`
+
+	testCases := []struct {
+		name            string
+		testFile        string
+		testFileContent string
+		policy          string
+		wantErr         bool
+		wantRecords     int
+		wantQuarantined []string
+	}{
+		{
+			name:            "a csv file with valid records",
+			testFile:        "people.csv",
+			testFileContent: "id,age\n1,100\n2,25",
+			wantRecords:     2,
+		},
+		{
+			name:            "a csv file with an invalid record is rejected",
+			testFile:        "people.csv",
+			testFileContent: "id,age\n1,100\n2,old",
+			wantErr:         true,
+		},
+		{
+			name:            "a csv file with an invalid record is quarantined",
+			testFile:        "people.csv",
+			testFileContent: "id,age\n1,100\n2,old\n3,-1",
+			policy:          config.SCHEMA_POLICY_QUARANTINE,
+			wantRecords:     1,
+			wantQuarantined: []string{
+				"row 3: age: expected integer got string",
+				"row 4: age: value -1 is less than 0",
+			},
+		},
+		{
+			name:            "a json object file with an invalid record is quarantined",
+			testFile:        "people.json",
+			testFileContent: `{"result":[{"id": 1, "age": 100},{"id": 2}]}`,
+			policy:          config.SCHEMA_POLICY_QUARANTINE,
+			wantRecords:     1,
+			wantQuarantined: []string{
+				"result record 2: age: is required",
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := makeTestFolder(dataFolder); err != nil {
+				t.Fatalf("TestLoadAndValidateSchema could not create the test folder: %v", err)
+			}
+			defer func() {
+				if err := removeTestFolder(dataFolder); err != nil {
+					t.Fatalf("TestLoadAndValidateSchema remove test folder %v", err)
+				}
+			}()
+
+			if err := createTestFileWithContent(tc.testFile, tc.testFileContent, dataFolder); err != nil {
+				t.Fatalf("TestLoadAndValidateSchema could not create the test file: %v", err)
+			}
+			if err := createTestFileWithContent("people.schema.json", schemaContent, dataFolder); err != nil {
+				t.Fatalf("TestLoadAndValidateSchema could not create the schema file: %v", err)
+			}
+
+			cfg := &config.Config{Datasources: map[string]config.Datasource{
+				"people": {SchemaPolicy: tc.policy},
+			}}
+			ds, err := file.LoadAndValidate(file.InitDatasource(filepath.Join(dataFolder, tc.testFile)), cfg, testLogger)
+
+			if err != nil {
+				if !tc.wantErr {
+					t.Errorf("failed got err %v did not want", err)
+				}
+				if ds.Data != nil {
+					t.Errorf("failed rejected datasource should have no data")
+				}
+				return
+			} else if tc.wantErr {
+				t.Errorf("failed got nil wanted error")
+			}
+
+			if got := ds.RecordCount(); got != tc.wantRecords {
+				t.Errorf("failed got %v records wanted %v", got, tc.wantRecords)
+			}
+			if !reflect.DeepEqual(ds.Quarantined, tc.wantQuarantined) {
+				t.Errorf("failed got %v wanted %v", ds.Quarantined, tc.wantQuarantined)
+			}
+			if ds.Schema != filepath.Join(dataFolder, "people.schema.json") {
+				t.Errorf("failed got schema %v", ds.Schema)
+			}
+		})
+	}
+}
+
+func TestSchemaFolder(t *testing.T) {
+	testCases := []struct {
+		name       string
+		dataFolder string
+		want       string
+	}{
+		{"default data folder", "data", "schemas"},
+		{"default data folder with trailing slash", "data/", "schemas"},
+		{"virtual host folder", "data-inventory", filepath.Join("schemas", "data-inventory")},
+		{"absolute folder", "/srv/fixtures", filepath.Join("schemas", "fixtures")},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := file.SchemaFolder(tc.dataFolder); got != tc.want {
+				t.Errorf("failed got %v wanted %v", got, tc.want)
+			}
+		})
+	}
+}
+
 func makeTestFolder(folder string) error {
 	dataPath := filepath.Join(".", folder)
 	if err := os.MkdirAll(dataPath, os.ModePerm); err != nil {
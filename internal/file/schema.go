@@ -0,0 +1,210 @@
+package file
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/spoonboy-io/dujour/internal"
+	"github.com/spoonboy-io/dujour/internal/config"
+	"github.com/spoonboy-io/dujour/internal/schema"
+)
+
+// MAX_REPORTED_VIOLATIONS limits the violations included in a load error and logged
+const MAX_REPORTED_VIOLATIONS = 10
+
+// IsSchemaFile reports whether the file is a schema rather than a datasource
+func IsSchemaFile(file string) bool {
+	return strings.HasSuffix(strings.ToLower(file), internal.SCHEMA_EXTENSION)
+}
+
+// SchemaFolder returns the folder of schemas for the data files in the data folder, 'schemas' for the
+// default data folder and a folder named after any other data folder within it, such as
+// 'schemas/data-inventory' for a virtual host, so files of the same name in different folders do not
+// share a schema
+func SchemaFolder(dataFolder string) string {
+	dataFolder = filepath.Clean(dataFolder)
+	if dataFolder == internal.DATA_FOLDER {
+		return internal.SCHEMA_FOLDER
+	}
+	return filepath.Join(internal.SCHEMA_FOLDER, filepath.Base(dataFolder))
+}
+
+// SchemaFile returns the schema for the data file, either users.schema.json next to users.csv or
+// in the schema folder of its data folder, or an empty string when there is none
+func SchemaFile(file string) string {
+	base := strings.TrimSuffix(filepath.Base(file), filepath.Ext(file)) + internal.SCHEMA_EXTENSION
+	for _, v := range []string{
+		filepath.Join(filepath.Dir(file), base),
+		filepath.Join(SchemaFolder(filepath.Dir(file)), base),
+	} {
+		if _, err := os.Stat(v); err == nil {
+			return v
+		}
+	}
+	return ""
+}
+
+// DataFiles returns the data files in the data folder which the schema file applies to, the schema can be
+// next to them or in the schema folder of the data folder
+func DataFiles(schemaFile, dataFolder string) []string {
+	name := filepath.Base(schemaFile)
+	base := filepath.Join(filepath.Clean(dataFolder), name[:len(name)-len(internal.SCHEMA_EXTENSION)])
+	files := []string{}
+	for _, ext := range []string{".csv", ".json"} {
+		if _, err := os.Stat(base + ext); err == nil {
+			files = append(files, base+ext)
+		}
+	}
+	return files
+}
+
+// Violation describes a record which does not match the schema of its datasource, Location is the row
+// of a CSV file or the record number within a JSON array
+type Violation struct {
+	Location string
+	Errors   []string
+}
+
+func (v Violation) String() string {
+	return fmt.Sprintf("%s: %s", v.Location, strings.Join(v.Errors, ", "))
+}
+
+// CheckSchema validates every record of the datasource against its schema, returning the schema file, or
+// an empty string when there is none, the data with only the valid records, and a violation for each
+// invalid record. An object without arrays is validated as a single record and its data is nil when it
+// does not match
+func CheckSchema(ds internal.Datasource) (string, interface{}, []Violation, error) {
+	schemaFile := SchemaFile(ds.FileName)
+	if schemaFile == "" {
+		return "", ds.Data, nil, nil
+	}
+
+	data, err := os.ReadFile(schemaFile)
+	if err != nil {
+		return schemaFile, nil, nil, err
+	}
+	validator, err := schema.Compile(data)
+	if err != nil {
+		return schemaFile, nil, nil, fmt.Errorf("Could not load schema '%s'; %v", schemaFile, err)
+	}
+
+	// CSV values are always strings, so numbers and booleans are accepted in their text form
+	coerce := ds.FileType == internal.TYPE_CSV
+	violations := []Violation{}
+	check := func(location string, record interface{}) bool {
+		errs := validator.Validate(record, coerce)
+		if len(errs) == 0 {
+			return true
+		}
+		violations = append(violations, Violation{Location: location, Errors: errs})
+		return false
+	}
+
+	var valid interface{}
+	switch records := ds.Data.(type) {
+	case []map[string]string:
+		out := []map[string]string{}
+		for i, v := range records {
+			if check(Row(ds, i), v) {
+				out = append(out, v)
+			}
+		}
+		valid = out
+	case []map[string]interface{}:
+		out := []map[string]interface{}{}
+		for i, v := range records {
+			if check(Row(ds, i), v) {
+				out = append(out, v)
+			}
+		}
+		valid = out
+	case map[string]interface{}:
+		valid = checkSchemaObject(records, check)
+	}
+
+	return schemaFile, valid, violations, nil
+}
+
+// Row describes the location of the record at index in the datasource, CSV files are described by their
+// row in the file, after the header, and JSON arrays by the record number
+func Row(ds internal.Datasource, index int) string {
+	if ds.FileType == internal.TYPE_CSV {
+		return fmt.Sprintf("row %d", index+2)
+	}
+	return fmt.Sprintf("record %d", index+1)
+}
+
+// applySchema validates every record of the datasource against its schema, if it has one. Under the
+// reject policy any invalid record fails the load, under the quarantine policy invalid records are
+// removed from the data and described in Quarantined. Violations are reported with their row number
+func applySchema(ds internal.Datasource, policy string, logger internal.Logger) (internal.Datasource, error) {
+	schemaFile, valid, violations, err := CheckSchema(ds)
+	if err != nil {
+		return ds, err
+	}
+	ds.Schema = schemaFile
+	if len(violations) == 0 {
+		return ds, nil
+	}
+
+	reported := []string{}
+	for i, v := range violations {
+		if i == MAX_REPORTED_VIOLATIONS {
+			break
+		}
+		reported = append(reported, v.String())
+		logger.Warn(fmt.Sprintf("Datasource '%s' %s", ds.FileName, v))
+	}
+
+	// an object without arrays is a single record which cannot be quarantined
+	if policy == config.SCHEMA_POLICY_QUARANTINE && valid != nil {
+		logger.Warn(fmt.Sprintf("Quarantined %d records of '%s' which do not match schema '%s'", len(violations), ds.FileName, schemaFile))
+		ds.Data = valid
+		ds.Quarantined = []string{}
+		for _, v := range violations {
+			ds.Quarantined = append(ds.Quarantined, v.String())
+		}
+		return ds, nil
+	}
+
+	return ds, fmt.Errorf("%d records do not match schema '%s'; %s", len(violations), schemaFile, strings.Join(reported, "; "))
+}
+
+// checkSchemaObject validates the records in each top level array of an object, returning a copy of
+// the object with only the valid records. An object without arrays is validated as a single record
+// and nil is returned if it is invalid
+func checkSchemaObject(obj map[string]interface{}, check func(string, interface{}) bool) interface{} {
+	out := map[string]interface{}{}
+	hasArrays := false
+	keys := make([]string, 0, len(obj))
+	for k := range obj {
+		keys = append(keys, k)
+	}
+	// violations are reported in a stable order
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		v := obj[k]
+		arr, ok := v.([]interface{})
+		if !ok {
+			out[k] = v
+			continue
+		}
+		hasArrays = true
+		kept := []interface{}{}
+		for i, record := range arr {
+			if check(fmt.Sprintf("%s record %d", k, i+1), record) {
+				kept = append(kept, record)
+			}
+		}
+		out[k] = kept
+	}
+
+	if !hasArrays && !check("record 1", obj) {
+		return nil
+	}
+	return out
+}
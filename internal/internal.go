@@ -7,23 +7,118 @@ const (
 	SRV_HOST = ""
 	SRV_PORT = "18651"
 
+	SRV_READ_TIMEOUT  = 3 * time.Second
+	SRV_WRITE_TIMEOUT = 5 * time.Second
+	SRV_DRAIN_TIMEOUT = 10 * time.Second
+
+	// rate limiting, buckets unused for the idle time are discarded
+	RATE_LIMIT_IDLE = 10 * time.Minute
+
 	// data
 	DATA_FOLDER = "data"
 
+	// schemas, a schema next to the data file takes precedence over one in the schema folder
+	SCHEMA_FOLDER    = "schemas"
+	SCHEMA_EXTENSION = ".schema.json"
+
+	// configuration
+	CONFIG_FILE    = "dujour.yaml"
+	API_KEY_HEADER = "X-API-Key"
+
 	// storage
 	TYPE_CSV  = 1
 	TYPE_JSON = 2
 
 	// tls configuration
 	TLS_FOLDER    = "certs"
+	TLS_CERT_FILE = "cert.pem"
+	TLS_KEY_FILE  = "key.pem"
 	TLS_ORG       = "Spoon Boy"
 	TLS_VALID_FOR = 365 * 24 * time.Hour
+	TLS_RSA_BITS  = 2048
+
+	// certificate renewal and reload
+	TLS_CHECK_INTERVAL = time.Hour
+	TLS_EXPIRY_WARN    = 14 * 24 * time.Hour
+	TLS_RELOAD_DELAY   = 500 * time.Millisecond
+
+	// local certificate authority, server certificates issued by the CA are short-lived
+	TLS_CA_CERT_FILE      = "ca-cert.pem"
+	TLS_CA_KEY_FILE       = "ca-key.pem"
+	TLS_CA_NAME           = "Dujour Local CA"
+	TLS_CA_VALID_FOR      = 10 * 365 * 24 * time.Hour
+	TLS_CA_LEAF_VALID_FOR = 30 * 24 * time.Hour
 )
 
-// Datasource contains both the data and metadata of a discovered and validated datasource
+// RESERVED_ENDPOINTS are the routes served by the server itself, a datasource with one of these endpoint
+// names would be shadowed so it is not served
+var RESERVED_ENDPOINTS = []string{"list", "ca.pem", "metrics", "healthz", "readyz", "status", "openapi.json", "docs"}
+
+// IsReserved reports whether the endpoint is one of the RESERVED_ENDPOINTS
+func IsReserved(endpoint string) bool {
+	for _, v := range RESERVED_ENDPOINTS {
+		if v == endpoint {
+			return true
+		}
+	}
+	return false
+}
+
+// Logger is the logging used throughout, it is satisfied by *koan.Logger so applications embedding
+// Dujour can supply their own
+type Logger interface {
+	Info(msg string)
+	Warn(msg string)
+	Error(msg string, err error)
+}
+
+// Datasource contains both the data and metadata of a discovered and validated datasource. When a load
+// fails LastError is set, and Data holds the last successfully loaded version if there is one. Schema is
+// the schema file the records were validated against, and Quarantined describes each record which was
+// removed because it did not match
 type Datasource struct {
 	FileName     string
 	FileType     int
 	EndpointName string
+	Size         int64
+	Hash         string
+	ModTime      time.Time
+	LoadedAt     time.Time
+	LastError    string
+	Schema       string
+	Quarantined  []string
 	Data         interface{}
 }
+
+// Available reports whether the datasource has data to serve, it is false only when the
+// datasource has never loaded successfully
+func (d Datasource) Available() bool {
+	return d.Data != nil || d.LastError == ""
+}
+
+// RecordCount returns the number of elements/rows in the datasource. For a JSON object the
+// elements of each top level array are counted, an object with no arrays is a single record
+func (d Datasource) RecordCount() int {
+	switch data := d.Data.(type) {
+	case []map[string]string:
+		return len(data)
+	case []map[string]interface{}:
+		return len(data)
+	case map[string]interface{}:
+		count := 0
+		for _, v := range data {
+			switch arr := v.(type) {
+			case []interface{}:
+				count += len(arr)
+			case []map[string]interface{}:
+				count += len(arr)
+			}
+		}
+		if count == 0 && len(data) > 0 {
+			return 1
+		}
+		return count
+	default:
+		return 0
+	}
+}
@@ -0,0 +1,274 @@
+// Package lint checks the data files in a data folder for problems which would prevent them being
+// served as expected, so they can be caught before the files reach the server
+package lint
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/spoonboy-io/dujour/internal"
+	"github.com/spoonboy-io/dujour/internal/file"
+)
+
+const (
+	LEVEL_ERROR   = "error"
+	LEVEL_WARNING = "warning"
+
+	CODE_PARSE_ERROR          = "parse_error"
+	CODE_MISSING_ID           = "missing_id"
+	CODE_DUPLICATE_ID         = "duplicate_id"
+	CODE_INCONSISTENT_COLUMNS = "inconsistent_columns"
+	CODE_ENDPOINT_COLLISION   = "endpoint_collision"
+	CODE_INVALID_SCHEMA       = "invalid_schema"
+	CODE_SCHEMA_VIOLATION     = "schema_violation"
+)
+
+// Issue is a problem found in a data file, Location is the row or record it was found at when it
+// applies to a single record
+type Issue struct {
+	File     string `json:"file"`
+	Location string `json:"location,omitempty"`
+	Level    string `json:"level"`
+	Code     string `json:"code"`
+	Message  string `json:"message"`
+}
+
+func (i Issue) String() string {
+	location := i.File
+	if i.Location != "" {
+		location += " " + i.Location
+	}
+	return fmt.Sprintf("%s: %s %s: %s", location, i.Level, i.Code, i.Message)
+}
+
+// record is a record which can be requested by id along with its location in the file
+type record struct {
+	location string
+	fields   map[string]interface{}
+}
+
+// Folder checks every data file in the data folder, returning the issues found ordered by file. Files
+// which fail to parse are reported and not checked further, files at an endpoint reserved by the server
+// or already used by another file are reported as collisions
+func Folder(dataFolder string, logger internal.Logger) ([]Issue, error) {
+	files, err := file.FindFiles(dataFolder, logger)
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+
+	issues := []Issue{}
+	endpoints := map[string]string{}
+	for _, v := range files {
+		ds := file.InitDatasource(v)
+		if internal.IsReserved(ds.EndpointName) {
+			issues = append(issues, Issue{
+				File:    v,
+				Level:   LEVEL_ERROR,
+				Code:    CODE_ENDPOINT_COLLISION,
+				Message: fmt.Sprintf("endpoint '/%s' is reserved by the server", ds.EndpointName),
+			})
+		} else if first, ok := endpoints[ds.EndpointName]; ok {
+			issues = append(issues, Issue{
+				File:    v,
+				Level:   LEVEL_ERROR,
+				Code:    CODE_ENDPOINT_COLLISION,
+				Message: fmt.Sprintf("endpoint '/%s' is also served from '%s'", ds.EndpointName, first),
+			})
+		} else {
+			endpoints[ds.EndpointName] = v
+		}
+
+		issues = append(issues, Datasource(ds)...)
+	}
+
+	return issues, nil
+}
+
+// Datasource checks a single data file for parse errors, missing and duplicate ids, inconsistent columns
+// and records which do not match its schema
+func Datasource(ds internal.Datasource) []Issue {
+	issues := []Issue{}
+	add := func(location, level, code, message string) {
+		issues = append(issues, Issue{File: ds.FileName, Location: location, Level: level, Code: code, Message: message})
+	}
+
+	if ds.FileType == internal.TYPE_CSV {
+		if msg := checkHeader(ds.FileName); msg != "" {
+			add("row 1", LEVEL_ERROR, CODE_INCONSISTENT_COLUMNS, msg)
+		}
+	}
+
+	ds, err := file.Load(ds)
+	if err != nil {
+		add("", LEVEL_ERROR, CODE_PARSE_ERROR, err.Error())
+		return issues
+	}
+
+	records := records(ds)
+	seen := map[string]string{}
+	for _, r := range records {
+		id, ok := r.fields["id"]
+		if !ok || id == nil || id == "" {
+			add(r.location, LEVEL_ERROR, CODE_MISSING_ID, "record has no id")
+			continue
+		}
+		key := fmt.Sprintf("%v", id)
+		if first, ok := seen[key]; ok {
+			add(r.location, LEVEL_ERROR, CODE_DUPLICATE_ID, fmt.Sprintf("id '%s' is also used at %s", key, first))
+			continue
+		}
+		seen[key] = r.location
+	}
+
+	// CSV rows always have the columns of the header
+	if ds.FileType == internal.TYPE_JSON {
+		for _, v := range checkColumns(records) {
+			add(v.location, LEVEL_WARNING, CODE_INCONSISTENT_COLUMNS, v.message)
+		}
+	}
+
+	schemaFile, _, violations, err := file.CheckSchema(ds)
+	if err != nil {
+		add("", LEVEL_ERROR, CODE_INVALID_SCHEMA, err.Error())
+		return issues
+	}
+	for _, v := range violations {
+		add(v.Location, LEVEL_ERROR, CODE_SCHEMA_VIOLATION, fmt.Sprintf("does not match schema '%s'; %s", schemaFile, strings.Join(v.Errors, ", ")))
+	}
+
+	return issues
+}
+
+// records returns the records of the datasource which can be requested by id, the elements of an array
+// datasource or of the top level arrays of an object datasource
+func records(ds internal.Datasource) []record {
+	out := []record{}
+	switch data := ds.Data.(type) {
+	case []map[string]string:
+		for i, v := range data {
+			fields := map[string]interface{}{}
+			for k, f := range v {
+				fields[k] = f
+			}
+			out = append(out, record{file.Row(ds, i), fields})
+		}
+	case []map[string]interface{}:
+		for i, v := range data {
+			out = append(out, record{file.Row(ds, i), v})
+		}
+	case map[string]interface{}:
+		keys := make([]string, 0, len(data))
+		for k := range data {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			arr, ok := data[k].([]interface{})
+			if !ok {
+				continue
+			}
+			for i, v := range arr {
+				if fields, ok := v.(map[string]interface{}); ok {
+					out = append(out, record{fmt.Sprintf("%s record %d", k, i+1), fields})
+				}
+			}
+		}
+	}
+	return out
+}
+
+// checkHeader reports empty and duplicate column names in the header of a CSV file, a duplicated
+// column would silently replace the values of the first
+func checkHeader(fileName string) string {
+	f, err := os.Open(fileName)
+	if err != nil {
+		// reported as a parse error
+		return ""
+	}
+	defer f.Close()
+
+	header, err := csv.NewReader(f).Read()
+	if err != nil {
+		return ""
+	}
+
+	seen := map[string]bool{}
+	for i, v := range header {
+		switch {
+		case strings.TrimSpace(v) == "":
+			return fmt.Sprintf("column %d has no name", i+1)
+		case seen[v]:
+			return fmt.Sprintf("column '%s' appears more than once", v)
+		}
+		seen[v] = true
+	}
+	return ""
+}
+
+type columnIssue struct {
+	location string
+	message  string
+}
+
+// checkColumns reports records whose fields differ from the fields most records have
+func checkColumns(records []record) []columnIssue {
+	signature := func(r record) string {
+		names := make([]string, 0, len(r.fields))
+		for k := range r.fields {
+			names = append(names, k)
+		}
+		sort.Strings(names)
+		return strings.Join(names, ",")
+	}
+
+	counts := map[string]int{}
+	common := ""
+	for _, r := range records {
+		sig := signature(r)
+		counts[sig]++
+		if counts[sig] > counts[common] || (counts[sig] == counts[common] && sig < common) {
+			common = sig
+		}
+	}
+
+	expected := map[string]bool{}
+	for _, v := range strings.Split(common, ",") {
+		if v != "" {
+			expected[v] = true
+		}
+	}
+
+	out := []columnIssue{}
+	for _, r := range records {
+		if signature(r) == common {
+			continue
+		}
+		missing, extra := []string{}, []string{}
+		for k := range expected {
+			if _, ok := r.fields[k]; !ok {
+				missing = append(missing, k)
+			}
+		}
+		for k := range r.fields {
+			if !expected[k] {
+				extra = append(extra, k)
+			}
+		}
+		sort.Strings(missing)
+		sort.Strings(extra)
+
+		parts := []string{}
+		if len(missing) > 0 {
+			parts = append(parts, "missing "+strings.Join(missing, ", "))
+		}
+		if len(extra) > 0 {
+			parts = append(parts, "unexpected "+strings.Join(extra, ", "))
+		}
+		out = append(out, columnIssue{r.location, "fields differ from other records, " + strings.Join(parts, "; ")})
+	}
+	return out
+}
@@ -0,0 +1,109 @@
+package lint_test
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/spoonboy-io/koan"
+
+	"github.com/spoonboy-io/dujour/internal/lint"
+)
+
+func TestFolder(t *testing.T) {
+	testLogger := &koan.Logger{}
+	dataFolder := "data"
+
+	testCases := []struct {
+		name      string
+		files     map[string]string
+		wantCodes []string
+	}{
+		{
+			"clean files",
+			map[string]string{
+				"people.csv":  "id,name\n1,Ann\n2,Bob",
+				"places.json": `[{"id": 1, "name": "Leeds"}, {"id": 2, "name": "York"}]`,
+			},
+			[]string{},
+		},
+		{
+			"parse error",
+			map[string]string{"broken.json": `[{"id": 1`},
+			[]string{lint.CODE_PARSE_ERROR},
+		},
+		{
+			"missing and duplicate ids",
+			map[string]string{"people.csv": "id,name\n1,Ann\n1,Bob\n,Cy"},
+			[]string{lint.CODE_DUPLICATE_ID, lint.CODE_MISSING_ID},
+		},
+		{
+			"duplicate csv column",
+			map[string]string{"people.csv": "id,name,name\n1,Ann,Bob"},
+			[]string{lint.CODE_INCONSISTENT_COLUMNS},
+		},
+		{
+			"inconsistent json fields",
+			map[string]string{"people.json": `{"result": [{"id": 1, "name": "Ann"}, {"id": 2, "name": "Bob"}, {"id": 3}]}`},
+			[]string{lint.CODE_INCONSISTENT_COLUMNS},
+		},
+		{
+			"endpoint collision",
+			map[string]string{"people.csv": "id\n1", "People.json": `[{"id": 1}]`},
+			[]string{lint.CODE_ENDPOINT_COLLISION},
+		},
+		{
+			"reserved endpoint collision",
+			map[string]string{"Status.csv": "id\n1", "docs.json": `[{"id": 1}]`},
+			[]string{lint.CODE_ENDPOINT_COLLISION, lint.CODE_ENDPOINT_COLLISION},
+		},
+		{
+			"schema violation",
+			map[string]string{
+				"people.csv":         "id,age\n1,30\n2,old",
+				"people.schema.json": `{"properties": {"age": {"type": "integer"}}}`,
+			},
+			[]string{lint.CODE_SCHEMA_VIOLATION},
+		},
+		{
+			"invalid schema",
+			map[string]string{
+				"people.csv":         "id\n1",
+				"people.schema.json": `{"oneOf": []}`,
+			},
+			[]string{lint.CODE_INVALID_SCHEMA},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := os.MkdirAll(dataFolder, os.ModePerm); err != nil {
+				t.Fatalf("TestFolder could not create the test folder: %v", err)
+			}
+			defer func() {
+				if err := os.RemoveAll(dataFolder); err != nil {
+					t.Fatalf("TestFolder remove test folder %v", err)
+				}
+			}()
+			for name, content := range tc.files {
+				if err := os.WriteFile(filepath.Join(dataFolder, name), []byte(content), 0644); err != nil {
+					t.Fatalf("TestFolder could not create the test file: %v", err)
+				}
+			}
+
+			issues, err := lint.Folder(dataFolder, testLogger)
+			if err != nil {
+				t.Fatalf("Folder unexpected error: %v", err)
+			}
+
+			gotCodes := []string{}
+			for _, v := range issues {
+				gotCodes = append(gotCodes, v.Code)
+			}
+			if !reflect.DeepEqual(gotCodes, tc.wantCodes) {
+				t.Errorf("failed got %v wanted %v", issues, tc.wantCodes)
+			}
+		})
+	}
+}
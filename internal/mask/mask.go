@@ -0,0 +1,148 @@
+// Package mask applies field redaction and masking rules to datasource data before it is served
+package mask
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+const (
+	ACTION_DROP    = "drop"
+	ACTION_HASH    = "hash"
+	ACTION_PARTIAL = "partial"
+
+	// the number of trailing characters left visible by a partial mask when not configured
+	DEFAULT_KEEP = 4
+	MASK_CHAR    = "*"
+)
+
+// Rule describes how a single field should be treated when served. Secret is the key of the HMAC-SHA256
+// used by hash rules, it is set from the configuration rather than per rule
+type Rule struct {
+	Field  string `yaml:"field"`
+	Action string `yaml:"action"`
+	Keep   int    `yaml:"keep"`
+	Secret string `yaml:"-"`
+}
+
+// Validate checks the rule names a field and a supported action
+func (r Rule) Validate() error {
+	if r.Field == "" {
+		return fmt.Errorf("mask rule has no field")
+	}
+	switch r.Action {
+	case ACTION_DROP, ACTION_HASH, ACTION_PARTIAL:
+	default:
+		return fmt.Errorf("mask rule for field '%s' has unsupported action '%s'", r.Field, r.Action)
+	}
+	if r.Keep < 0 {
+		return fmt.Errorf("mask rule for field '%s' has negative keep value", r.Field)
+	}
+	return nil
+}
+
+// Apply returns a copy of data with the rules applied to every matching field, at any depth. The
+// source data is never modified since it is shared by all requests for the datasource
+func Apply(data interface{}, rules []Rule) interface{} {
+	if len(rules) == 0 {
+		return data
+	}
+
+	byField := make(map[string]Rule, len(rules))
+	for _, r := range rules {
+		byField[r.Field] = r
+	}
+
+	return apply(data, byField)
+}
+
+func apply(data interface{}, rules map[string]Rule) interface{} {
+	switch d := data.(type) {
+	case []map[string]string:
+		out := make([]map[string]string, len(d))
+		for i, v := range d {
+			out[i] = applyStringMap(v, rules)
+		}
+		return out
+	case map[string]string:
+		return applyStringMap(d, rules)
+	case []map[string]interface{}:
+		out := make([]map[string]interface{}, len(d))
+		for i, v := range d {
+			out[i] = applyMap(v, rules)
+		}
+		return out
+	case map[string]interface{}:
+		return applyMap(d, rules)
+	case []interface{}:
+		out := make([]interface{}, len(d))
+		for i, v := range d {
+			out[i] = apply(v, rules)
+		}
+		return out
+	default:
+		return data
+	}
+}
+
+func applyStringMap(rec map[string]string, rules map[string]Rule) map[string]string {
+	out := make(map[string]string, len(rec))
+	for k, v := range rec {
+		rule, ok := rules[k]
+		if !ok {
+			out[k] = v
+			continue
+		}
+		if rule.Action == ACTION_DROP {
+			continue
+		}
+		out[k] = maskValue(v, rule)
+	}
+	return out
+}
+
+func applyMap(rec map[string]interface{}, rules map[string]Rule) map[string]interface{} {
+	out := make(map[string]interface{}, len(rec))
+	for k, v := range rec {
+		rule, ok := rules[k]
+		if !ok {
+			out[k] = apply(v, rules)
+			continue
+		}
+		if rule.Action == ACTION_DROP {
+			continue
+		}
+		if v == nil {
+			out[k] = nil
+			continue
+		}
+		out[k] = maskValue(fmt.Sprint(v), rule)
+	}
+	return out
+}
+
+// maskValue hashes or partially masks a single value, drop is handled by the callers
+func maskValue(value string, rule Rule) string {
+	switch rule.Action {
+	case ACTION_HASH:
+		// keyed so values such as email addresses cannot be recovered by hashing a dictionary
+		mac := hmac.New(sha256.New, []byte(rule.Secret))
+		_, _ = mac.Write([]byte(value))
+		return hex.EncodeToString(mac.Sum(nil))
+	case ACTION_PARTIAL:
+		keep := rule.Keep
+		if keep == 0 {
+			keep = DEFAULT_KEEP
+		}
+		runes := []rune(value)
+		if len(runes) <= keep {
+			return strings.Repeat(MASK_CHAR, len(runes))
+		}
+		return strings.Repeat(MASK_CHAR, len(runes)-keep) + string(runes[len(runes)-keep:])
+	default:
+		return value
+	}
+}
@@ -0,0 +1,110 @@
+package mask_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/spoonboy-io/dujour/internal/mask"
+)
+
+func TestApply(t *testing.T) {
+	testCases := []struct {
+		name     string
+		data     interface{}
+		rules    []mask.Rule
+		wantData interface{}
+	}{
+		{
+			"no rules returns data unmodified",
+			[]map[string]string{{"id": "1", "serial": "ABC1234"}},
+			nil,
+			[]map[string]string{{"id": "1", "serial": "ABC1234"}},
+		},
+		{
+			"csv data with drop and partial rules",
+			[]map[string]string{
+				{"id": "1", "serial": "ABCD1234", "password_hint": "pet name"},
+				{"id": "2", "serial": "12", "password_hint": "street"},
+			},
+			[]mask.Rule{
+				{Field: "password_hint", Action: mask.ACTION_DROP},
+				{Field: "serial", Action: mask.ACTION_PARTIAL},
+			},
+			[]map[string]string{
+				{"id": "1", "serial": "****1234"},
+				{"id": "2", "serial": "**"},
+			},
+		},
+		{
+			"json array data with hash and custom keep",
+			[]map[string]interface{}{
+				{"id": 1, "owner_email": "test@example.com", "serial": "XYZ987"},
+			},
+			[]mask.Rule{
+				{Field: "owner_email", Action: mask.ACTION_HASH, Secret: "mask-secret"},
+				{Field: "serial", Action: mask.ACTION_PARTIAL, Keep: 2},
+			},
+			[]map[string]interface{}{
+				{"id": 1, "owner_email": "65fc85f1128461c97bf789f6d4fa8b75ae405f9d82befcb58a011fb3812a9c89", "serial": "****87"},
+			},
+		},
+		{
+			"json object data with nested records",
+			map[string]interface{}{
+				"result": []interface{}{
+					map[string]interface{}{"id": "abc", "serial": "SN-0001"},
+				},
+			},
+			[]mask.Rule{
+				{Field: "serial", Action: mask.ACTION_DROP},
+			},
+			map[string]interface{}{
+				"result": []interface{}{
+					map[string]interface{}{"id": "abc"},
+				},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			gotData := mask.Apply(tc.data, tc.rules)
+			if !reflect.DeepEqual(gotData, tc.wantData) {
+				t.Errorf("failed got %v wanted %v", gotData, tc.wantData)
+			}
+		})
+	}
+}
+
+func TestApplyDoesNotModifySource(t *testing.T) {
+	data := []map[string]string{{"id": "1", "serial": "ABCD1234"}}
+	_ = mask.Apply(data, []mask.Rule{{Field: "serial", Action: mask.ACTION_DROP}})
+
+	if data[0]["serial"] != "ABCD1234" {
+		t.Errorf("source data was modified got %v", data)
+	}
+}
+
+func TestRuleValidate(t *testing.T) {
+	testCases := []struct {
+		name    string
+		rule    mask.Rule
+		wantErr bool
+	}{
+		{"valid drop rule", mask.Rule{Field: "serial", Action: mask.ACTION_DROP}, false},
+		{"missing field", mask.Rule{Action: mask.ACTION_HASH}, true},
+		{"unsupported action", mask.Rule{Field: "serial", Action: "encrypt"}, true},
+		{"negative keep", mask.Rule{Field: "serial", Action: mask.ACTION_PARTIAL, Keep: -1}, true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.rule.Validate()
+			if err != nil && !tc.wantErr {
+				t.Errorf("failed got err %v did not want", err)
+			} else if err == nil && tc.wantErr {
+				t.Errorf("failed got nil wanted error")
+			}
+		})
+	}
+}
@@ -0,0 +1,272 @@
+// Package metrics provides counters, histograms and gauges exposed in the Prometheus text format
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const CONTENT_TYPE = "text/plain; version=0.0.4; charset=utf-8"
+
+// DefaultBuckets are the latency histogram upper bounds in seconds
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// application metrics, registered with the Default registry
+var (
+	Requests = NewCounterVec("dujour_http_requests_total",
+		"Number of HTTP requests by route, datasource and status code.",
+		"route", "datasource", "code")
+	RequestDuration = NewHistogramVec("dujour_http_request_duration_seconds",
+		"HTTP request latency by route, datasource and status code.",
+		DefaultBuckets, "route", "datasource", "code")
+	Reloads = NewCounterVec("dujour_datasource_reloads_total",
+		"Number of datasource loads by the watcher or a forced reload, by result.",
+		"result")
+)
+
+// Default is the registry served by Handler
+var Default = NewRegistry(Requests, RequestDuration, Reloads)
+
+// Collector writes one or more metric families in the text exposition format
+type Collector interface {
+	Collect(w io.Writer)
+}
+
+// Registry holds the collectors which are exposed together
+type Registry struct {
+	mtx        sync.Mutex
+	collectors []Collector
+}
+
+// NewRegistry creates a registry with the collectors registered
+func NewRegistry(collectors ...Collector) *Registry {
+	return &Registry{collectors: collectors}
+}
+
+// Register adds collectors to the registry
+func (r *Registry) Register(collectors ...Collector) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	r.collectors = append(r.collectors, collectors...)
+}
+
+// Write writes all the registered collectors to w
+func (r *Registry) Write(w io.Writer) {
+	r.mtx.Lock()
+	collectors := append([]Collector{}, r.collectors...)
+	r.mtx.Unlock()
+
+	for _, c := range collectors {
+		c.Collect(w)
+	}
+}
+
+// Handler serves the Default registry
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", CONTENT_TYPE)
+		Default.Write(w)
+	})
+}
+
+// CounterVec is a counter partitioned by label values
+type CounterVec struct {
+	name   string
+	help   string
+	labels []string
+
+	mtx    sync.Mutex
+	values map[string]float64
+}
+
+// NewCounterVec creates a counter with the label names
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	return &CounterVec{name: name, help: help, labels: labels, values: map[string]float64{}}
+}
+
+// Inc increments the counter for the label values, given in the order of the label names
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add adds v to the counter for the label values
+func (c *CounterVec) Add(v float64, labelValues ...string) {
+	key := labelString(c.labels, labelValues)
+	c.mtx.Lock()
+	c.values[key] += v
+	c.mtx.Unlock()
+}
+
+// Value returns the current value of the counter for the label values
+func (c *CounterVec) Value(labelValues ...string) float64 {
+	key := labelString(c.labels, labelValues)
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return c.values[key]
+}
+
+// Collect implements Collector
+func (c *CounterVec) Collect(w io.Writer) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	keys := make([]string, 0, len(c.values))
+	for k := range c.values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	writeHeader(w, c.name, c.help, "counter")
+	for _, key := range keys {
+		fmt.Fprintf(w, "%s%s %s\n", c.name, key, formatFloat(c.values[key]))
+	}
+}
+
+// HistogramVec is a histogram partitioned by label values
+type HistogramVec struct {
+	name    string
+	help    string
+	labels  []string
+	buckets []float64
+
+	mtx    sync.Mutex
+	values map[string]*histogram
+}
+
+type histogram struct {
+	labelValues []string
+	counts      []uint64
+	sum         float64
+	count       uint64
+}
+
+// NewHistogramVec creates a histogram with the bucket upper bounds and label names
+func NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	return &HistogramVec{name: name, help: help, labels: labels, buckets: buckets, values: map[string]*histogram{}}
+}
+
+// Observe records v for the label values, given in the order of the label names
+func (h *HistogramVec) Observe(v float64, labelValues ...string) {
+	key := labelString(h.labels, labelValues)
+
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+
+	hist, ok := h.values[key]
+	if !ok {
+		hist = &histogram{labelValues: labelValues, counts: make([]uint64, len(h.buckets))}
+		h.values[key] = hist
+	}
+	for i, upper := range h.buckets {
+		if v <= upper {
+			hist.counts[i]++
+		}
+	}
+	hist.sum += v
+	hist.count++
+}
+
+// Collect implements Collector
+func (h *HistogramVec) Collect(w io.Writer) {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+
+	keys := make([]string, 0, len(h.values))
+	for k := range h.values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	writeHeader(w, h.name, h.help, "histogram")
+	bucketLabels := append(append([]string{}, h.labels...), "le")
+	for _, key := range keys {
+		hist := h.values[key]
+		for i, upper := range h.buckets {
+			le := labelString(bucketLabels, append(append([]string{}, hist.labelValues...), formatFloat(upper)))
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, le, hist.counts[i])
+		}
+		le := labelString(bucketLabels, append(append([]string{}, hist.labelValues...), "+Inf"))
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, le, hist.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, key, formatFloat(hist.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, key, hist.count)
+	}
+}
+
+// GaugeFunc is a gauge whose values are computed by a function each time the metrics are collected
+type GaugeFunc struct {
+	name   string
+	help   string
+	labels []string
+	fn     func() []Sample
+}
+
+// Sample is a single gauge value and its label values
+type Sample struct {
+	LabelValues []string
+	Value       float64
+}
+
+// NewGaugeFunc creates a gauge with the label names whose samples are provided by fn
+func NewGaugeFunc(name, help string, fn func() []Sample, labels ...string) *GaugeFunc {
+	return &GaugeFunc{name: name, help: help, labels: labels, fn: fn}
+}
+
+// Collect implements Collector
+func (g *GaugeFunc) Collect(w io.Writer) {
+	samples := g.fn()
+
+	lines := make([]string, 0, len(samples))
+	for _, v := range samples {
+		lines = append(lines, fmt.Sprintf("%s%s %s\n", g.name, labelString(g.labels, v.LabelValues), formatFloat(v.Value)))
+	}
+	sort.Strings(lines)
+
+	writeHeader(w, g.name, g.help, "gauge")
+	for _, v := range lines {
+		_, _ = io.WriteString(w, v)
+	}
+}
+
+func writeHeader(w io.Writer, name, help, metricType string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, metricType)
+}
+
+// labelString formats label names and values as {name="value",...}, it is also used as the map key
+func labelString(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		value := ""
+		if i < len(values) {
+			value = values[i]
+		}
+		pairs[i] = fmt.Sprintf("%s=\"%s\"", name, escapeLabel(value))
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(v string) string {
+	return labelEscaper.Replace(v)
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	default:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+}
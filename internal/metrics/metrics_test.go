@@ -0,0 +1,64 @@
+package metrics_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/spoonboy-io/dujour/internal/metrics"
+)
+
+func TestRegistryWrite(t *testing.T) {
+	counter := metrics.NewCounterVec("test_requests_total", "Test counter.", "code")
+	counter.Inc("200")
+	counter.Inc("200")
+	counter.Inc("404")
+
+	histogram := metrics.NewHistogramVec("test_duration_seconds", "Test histogram.", []float64{0.1, 1}, "route")
+	histogram.Observe(0.05, "/users")
+	histogram.Observe(0.5, "/users")
+
+	gauge := metrics.NewGaugeFunc("test_records", "Test gauge.", func() []metrics.Sample {
+		return []metrics.Sample{
+			{LabelValues: []string{"users", "data/\"users\".csv"}, Value: 2},
+			{LabelValues: []string{"people", "data/people.csv"}, Value: 10},
+		}
+	}, "datasource", "source")
+
+	reg := metrics.NewRegistry(counter, histogram, gauge)
+	buf := &bytes.Buffer{}
+	reg.Write(buf)
+
+	want := `# HELP test_requests_total Test counter.
+# TYPE test_requests_total counter
+test_requests_total{code="200"} 2
+test_requests_total{code="404"} 1
+# HELP test_duration_seconds Test histogram.
+# TYPE test_duration_seconds histogram
+test_duration_seconds_bucket{route="/users",le="0.1"} 1
+test_duration_seconds_bucket{route="/users",le="1"} 2
+test_duration_seconds_bucket{route="/users",le="+Inf"} 2
+test_duration_seconds_sum{route="/users"} 0.55
+test_duration_seconds_count{route="/users"} 2
+# HELP test_records Test gauge.
+# TYPE test_records gauge
+test_records{datasource="people",source="data/people.csv"} 10
+test_records{datasource="users",source="data/\"users\".csv"} 2
+`
+
+	if buf.String() != want {
+		t.Errorf("failed got\n%s\nwanted\n%s", buf.String(), want)
+	}
+}
+
+func TestCounterValue(t *testing.T) {
+	counter := metrics.NewCounterVec("test_reloads_total", "Test counter.", "result")
+	counter.Inc("success")
+	counter.Add(2, "failure")
+
+	if got := counter.Value("success"); got != 1 {
+		t.Errorf("failed got %v wanted 1", got)
+	}
+	if got := counter.Value("failure"); got != 2 {
+		t.Errorf("failed got %v wanted 2", got)
+	}
+}
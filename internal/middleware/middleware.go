@@ -0,0 +1,337 @@
+// Package middleware contains the HTTP middleware wrapped around the application handlers
+package middleware
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/spoonboy-io/dujour/internal"
+	"github.com/spoonboy-io/dujour/internal/accesslog"
+	"github.com/spoonboy-io/dujour/internal/config"
+	"github.com/spoonboy-io/dujour/internal/metrics"
+	"github.com/spoonboy-io/dujour/internal/problem"
+	"github.com/spoonboy-io/dujour/internal/ratelimit"
+)
+
+// REQUEST_ID_HEADER carries the request ID, an ID supplied by the client or a proxy is propagated
+const REQUEST_ID_HEADER = "X-Request-ID"
+
+type contextKey int
+
+const requestIDKey contextKey = iota
+
+// requestIDPattern limits propagated request IDs so they cannot inject into the access log
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._:\-]{1,128}$`)
+
+// routePattern matches the variable patterns in a route template so /{id:[0-9]+} is labelled /{id}
+var routePattern = regexp.MustCompile(`\{(\w+):[^}]*\}`)
+
+// Recorder wraps a http.ResponseWriter capturing the status code and number of bytes written
+type Recorder struct {
+	http.ResponseWriter
+	Status int
+	Bytes  int
+}
+
+// NewRecorder wraps w, the status defaults to 200 OK for handlers which never call WriteHeader
+func NewRecorder(w http.ResponseWriter) *Recorder {
+	return &Recorder{ResponseWriter: w, Status: http.StatusOK}
+}
+
+// WriteHeader captures the status code
+func (r *Recorder) WriteHeader(status int) {
+	r.Status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// Write captures the number of bytes written
+func (r *Recorder) Write(b []byte) (int, error) {
+	n, err := r.ResponseWriter.Write(b)
+	r.Bytes += n
+	return n, err
+}
+
+// Metrics records the count and latency of requests by route template, datasource and status code. The datasource
+// is only used as a label when known reports a datasource is served at the endpoint, other requests, including
+// preflight, rate limited and malformed requests, are counted under 'unknown' so arbitrary paths cannot create
+// new label values
+func Metrics(known func(endpoint string) bool) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			rec := NewRecorder(w)
+
+			next.ServeHTTP(rec, r)
+
+			route := ""
+			if current := mux.CurrentRoute(r); current != nil {
+				route, _ = current.GetPathTemplate()
+				route = routePattern.ReplaceAllString(route, "{$1}")
+			}
+			datasource := mux.Vars(r)["datasource"]
+			if datasource != "" && !known(datasource) {
+				datasource = "unknown"
+			}
+			code := strconv.Itoa(rec.Status)
+
+			metrics.Requests.Inc(route, datasource, code)
+			metrics.RequestDuration.Observe(time.Since(start).Seconds(), route, datasource, code)
+		})
+	}
+}
+
+// RequestID propagates a valid X-Request-ID request header or generates a new ID, the ID is returned
+// in the response header and is available to handlers through GetRequestID
+func RequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(REQUEST_ID_HEADER)
+		if !requestIDPattern.MatchString(id) {
+			id = newRequestID()
+		}
+
+		w.Header().Set(REQUEST_ID_HEADER, id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey, id)))
+	})
+}
+
+// GetRequestID returns the ID assigned to the request by RequestID
+func GetRequestID(r *http.Request) string {
+	id, _ := r.Context().Value(requestIDKey).(string)
+	return id
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 36)
+	}
+	return hex.EncodeToString(b)
+}
+
+// AccessLog writes an access log entry for every request once it has been served. It should be wrapped
+// by RequestID so the entry carries the request ID
+func AccessLog(logger *accesslog.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			rec := NewRecorder(w)
+
+			next.ServeHTTP(rec, r)
+
+			logger.Log(accesslog.Entry{
+				Time:       start,
+				Method:     r.Method,
+				Path:       r.URL.RequestURI(),
+				Status:     rec.Status,
+				Bytes:      rec.Bytes,
+				Duration:   time.Since(start),
+				RemoteAddr: r.RemoteAddr,
+				RequestID:  GetRequestID(r),
+			})
+		})
+	}
+}
+
+// CORS applies the cross-origin policy for the requested datasource, or the server policy for other
+// routes. Preflight and other OPTIONS requests are answered here and never reach the handlers, so
+// routes must accept OPTIONS for the middleware to see them
+func CORS(policy func(endpoint string) config.CORS) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			p := policy(strings.ToLower(mux.Vars(r)["datasource"]))
+			origin := r.Header.Get("Origin")
+			preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+
+			w.Header().Add("Vary", "Origin")
+			if preflight {
+				w.Header().Add("Vary", "Access-Control-Request-Method")
+				w.Header().Add("Vary", "Access-Control-Request-Headers")
+			}
+
+			if origin != "" && p.AllowsOrigin(origin) {
+				if preflight {
+					setPreflightHeaders(w, r, p)
+				}
+				if p.AnyOrigin() {
+					w.Header().Set("Access-Control-Allow-Origin", config.CORS_ANY)
+				} else {
+					w.Header().Set("Access-Control-Allow-Origin", origin)
+				}
+				if p.AllowCredentials {
+					w.Header().Set("Access-Control-Allow-Credentials", "true")
+				}
+				if !preflight && len(p.ExposedHeaders) > 0 {
+					w.Header().Set("Access-Control-Expose-Headers", strings.Join(p.ExposedHeaders, ", "))
+				}
+			}
+
+			if r.Method == http.MethodOptions {
+				w.Header().Set("Allow", strings.Join(append(methods(p.AllowedMethods), http.MethodOptions), ", "))
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// setPreflightHeaders allows the requested method and headers when the policy permits all of them,
+// otherwise no allow headers are sent and the browser rejects the request
+func setPreflightHeaders(w http.ResponseWriter, r *http.Request, p config.CORS) {
+	if !p.AllowsMethod(r.Header.Get("Access-Control-Request-Method")) {
+		return
+	}
+
+	headers := []string{}
+	for _, v := range strings.Split(r.Header.Get("Access-Control-Request-Headers"), ",") {
+		if v = strings.TrimSpace(v); v == "" {
+			continue
+		}
+		if !p.AllowsHeader(v) {
+			return
+		}
+		headers = append(headers, v)
+	}
+
+	w.Header().Set("Access-Control-Allow-Methods", strings.Join(methods(p.AllowedMethods), ", "))
+	if len(headers) > 0 {
+		w.Header().Set("Access-Control-Allow-Headers", strings.Join(headers, ", "))
+	}
+	if p.MaxAge > 0 {
+		w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(p.MaxAge.Seconds())))
+	}
+}
+
+// methods returns the configured methods in upper case without OPTIONS, which is always allowed
+func methods(values []string) []string {
+	out := make([]string, 0, len(values))
+	for _, v := range values {
+		if v = strings.ToUpper(v); v != http.MethodOptions {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// rateLimitExempt are the routes used by monitoring, which are never limited
+var rateLimitExempt = []string{"/healthz", "/readyz", "/metrics"}
+
+// isRateLimitExempt matches the template of the route rather than the request path, so the routes are
+// exempt when the handlers are mounted under a prefix
+func isRateLimitExempt(r *http.Request) bool {
+	current := mux.CurrentRoute(r)
+	if current == nil {
+		return false
+	}
+	route, err := current.GetPathTemplate()
+	if err != nil {
+		return false
+	}
+	for _, v := range rateLimitExempt {
+		if strings.HasSuffix(route, v) {
+			return true
+		}
+	}
+	return false
+}
+
+// RateLimit limits each client to the server wide rate across all routes and to the datasource rate for
+// each datasource. Clients presenting a privileged API key are limited by key, others by IP address.
+// Limits are read from the current configuration on every request so they can be reloaded
+func RateLimit(limiter *ratelimit.Limiter, current func() *config.Config) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if isRateLimitExempt(r) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			cfg := current()
+			endpoint := strings.ToLower(mux.Vars(r)["datasource"])
+			client := clientKey(r, cfg)
+			global, ds := cfg.RateLimits(endpoint)
+
+			scopes := []struct {
+				bucket string
+				limit  config.RateLimit
+			}{
+				{"server|" + client, global},
+				{"datasource:" + endpoint + "|" + client, ds},
+			}
+
+			var res *ratelimit.Result
+			for _, scope := range scopes {
+				if !scope.limit.Enabled() {
+					continue
+				}
+				v := limiter.Take(scope.bucket, scope.limit.RequestsPerSecond, scope.limit.BurstSize())
+				// report the most restrictive limit
+				if res == nil || (res.Allowed && !v.Allowed) || (res.Allowed == v.Allowed && v.Remaining < res.Remaining) {
+					res = &v
+				}
+			}
+			if res == nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			w.Header().Set("X-RateLimit-Limit", strconv.Itoa(res.Limit))
+			w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(res.Remaining))
+			w.Header().Set("X-RateLimit-Reset", strconv.Itoa(int(math.Ceil(res.Reset.Seconds()))))
+
+			if !res.Allowed {
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(res.RetryAfter.Seconds()))))
+				problem.Write(w, r, http.StatusTooManyRequests, problem.CODE_RATE_LIMITED, fmt.Sprintf("Rate limit of %d requests exceeded, retry after %s", res.Limit, w.Header().Get("Retry-After")+"s"))
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// clientKey identifies the client, privileged API keys are trusted to identify a client but any
+// other key could be varied to avoid the limit so the IP address is used. When the request is received
+// from a trusted proxy the X-Forwarded-For header is read from the right, and the first address which
+// is not itself a trusted proxy is the client
+func clientKey(r *http.Request, cfg *config.Config) string {
+	if key := r.Header.Get(internal.API_KEY_HEADER); cfg.IsPrivileged(key) {
+		return "key:" + key
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if !cfg.TrustsProxy(host) {
+		return "ip:" + host
+	}
+
+	hops := []string{}
+	for _, v := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(v, ",")...)
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip := net.ParseIP(strings.TrimSpace(hops[i]))
+		if ip == nil {
+			// addresses to the left of an invalid entry cannot be trusted
+			break
+		}
+		host = ip.String()
+		if !cfg.TrustsProxy(host) {
+			break
+		}
+	}
+	return "ip:" + host
+}
@@ -0,0 +1,393 @@
+package middleware_test
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/spoonboy-io/dujour/internal"
+	"github.com/spoonboy-io/dujour/internal/accesslog"
+	"github.com/spoonboy-io/dujour/internal/config"
+	"github.com/spoonboy-io/dujour/internal/metrics"
+	"github.com/spoonboy-io/dujour/internal/middleware"
+	"github.com/spoonboy-io/dujour/internal/ratelimit"
+)
+
+func TestMetrics(t *testing.T) {
+	cfg := &config.Config{
+		Server: config.Server{RateLimit: config.RateLimit{RequestsPerSecond: 1, Burst: 1}},
+	}
+	known := func(endpoint string) bool { return endpoint == "people" }
+
+	testMux := mux.NewRouter()
+	testMux.HandleFunc("/{datasource:[a-z0-9=\\-\\/]+}", func(w http.ResponseWriter, r *http.Request) {
+		if _, err := url.ParseQuery(r.URL.RawQuery); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if !known(mux.Vars(r)["datasource"]) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write([]byte("[]"))
+	}).Methods("GET", "OPTIONS")
+	testMux.Use(middleware.Metrics(known), middleware.CORS(cfg.CORSPolicy), middleware.RateLimit(ratelimit.New(), func() *config.Config { return cfg }))
+
+	requests := []struct {
+		method     string
+		requestURI string
+		remoteAddr string
+	}{
+		{"GET", "/people", "10.0.1.1:1000"},
+		{"GET", "/people", "10.0.1.2:1000"},
+		{"GET", "/people", "10.0.1.2:1001"},
+		{"GET", "/servers", "10.0.1.3:1000"},
+		{"OPTIONS", "/preflight-spam", "10.0.1.4:1000"},
+		{"GET", "/malformed-spam?%zz", "10.0.1.5:1000"},
+		{"GET", "/limited-spam", "10.0.1.6:1000"},
+		{"GET", "/limited-spam", "10.0.1.6:1001"},
+	}
+	for _, req := range requests {
+		r := httptest.NewRequest(req.method, req.requestURI, nil)
+		r.RemoteAddr = req.remoteAddr
+		r.Header.Set("Origin", "https://portal.example")
+		if req.method == "OPTIONS" {
+			r.Header.Set("Access-Control-Request-Method", "GET")
+		}
+		testMux.ServeHTTP(httptest.NewRecorder(), r)
+	}
+
+	testCases := []struct {
+		name       string
+		datasource string
+		code       string
+		want       float64
+	}{
+		{"known datasource", "people", "200", 2},
+		{"known datasource rate limited", "people", "429", 1},
+		{"not found", "unknown", "404", 2},
+		{"not found is not labelled by name", "servers", "404", 0},
+		{"preflight", "unknown", "204", 1},
+		{"preflight is not labelled by name", "preflight-spam", "204", 0},
+		{"malformed query", "unknown", "400", 1},
+		{"malformed query is not labelled by name", "malformed-spam", "400", 0},
+		{"rate limited", "unknown", "429", 1},
+		{"rate limited is not labelled by name", "limited-spam", "429", 0},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := metrics.Requests.Value("/{datasource}", tc.datasource, tc.code); got != tc.want {
+				t.Errorf("failed got %v requests labelled %s %s wanted %v", got, tc.datasource, tc.code, tc.want)
+			}
+		})
+	}
+}
+
+func TestRecorder(t *testing.T) {
+	rr := httptest.NewRecorder()
+	rec := middleware.NewRecorder(rr)
+
+	if rec.Status != http.StatusOK {
+		t.Errorf("failed default status got %v wanted %v", rec.Status, http.StatusOK)
+	}
+
+	rec.WriteHeader(http.StatusTeapot)
+	_, _ = rec.Write([]byte("hello"))
+
+	if rec.Status != http.StatusTeapot || rec.Bytes != 5 {
+		t.Errorf("failed got status %v bytes %v", rec.Status, rec.Bytes)
+	}
+}
+
+func TestRequestIDAndAccessLog(t *testing.T) {
+	buf := &bytes.Buffer{}
+	var handlerID string
+	h := middleware.RequestID(middleware.AccessLog(accesslog.NewWriter(buf, config.ACCESS_LOG_LOGFMT))(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			handlerID = middleware.GetRequestID(r)
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte("404 page not found"))
+		})))
+
+	testCases := []struct {
+		name      string
+		requestID string
+		propagate bool
+	}{
+		{"generated when missing", "", false},
+		{"propagated when valid", "edge-1234", true},
+		{"replaced when invalid", "bad id\nlevel=error", false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			buf.Reset()
+			req := httptest.NewRequest("GET", "/missing", nil)
+			if tc.requestID != "" {
+				req.Header.Set(middleware.REQUEST_ID_HEADER, tc.requestID)
+			}
+			rr := httptest.NewRecorder()
+			h.ServeHTTP(rr, req)
+
+			got := rr.Header().Get(middleware.REQUEST_ID_HEADER)
+			if got == "" || got != handlerID {
+				t.Errorf("failed got header %q handler %q", got, handlerID)
+			}
+			if (got == tc.requestID) != tc.propagate {
+				t.Errorf("failed got %q for supplied %q", got, tc.requestID)
+			}
+
+			line := buf.String()
+			for _, want := range []string{"level=warn", "path=/missing", "status=404", "bytes=18", "request_id=" + got} {
+				if !strings.Contains(line, want) {
+					t.Errorf("failed wanted %s in %q", want, line)
+				}
+			}
+		})
+	}
+}
+
+func TestCORS(t *testing.T) {
+	cfg := &config.Config{
+		Server: config.Server{CORS: config.CORS{MaxAge: 10 * time.Minute}},
+		Datasources: map[string]config.Datasource{
+			"users": {CORS: &config.CORS{
+				AllowedOrigins:   []string{"https://portal.example"},
+				AllowedHeaders:   []string{"X-API-Key"},
+				AllowCredentials: true,
+			}},
+		},
+	}
+
+	testMux := mux.NewRouter()
+	testMux.HandleFunc("/{datasource:[a-z0-9=\\-\\/]+}", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("[]"))
+	}).Methods("GET", "OPTIONS")
+	testMux.Use(middleware.CORS(cfg.CORSPolicy))
+
+	testCases := []struct {
+		name        string
+		method      string
+		requestURI  string
+		headers     map[string]string
+		wantStatus  int
+		wantHeaders map[string]string
+	}{
+		{
+			"no origin has no cors headers",
+			"GET", "/people", nil,
+			http.StatusOK,
+			map[string]string{"Access-Control-Allow-Origin": ""},
+		},
+		{
+			"any origin by default",
+			"GET", "/people", map[string]string{"Origin": "https://app.example"},
+			http.StatusOK,
+			map[string]string{"Access-Control-Allow-Origin": "*", "Access-Control-Expose-Headers": "ETag, X-Request-ID, X-RateLimit-Limit, X-RateLimit-Remaining, X-RateLimit-Reset, Retry-After"},
+		},
+		{
+			"preflight with custom header",
+			"OPTIONS", "/people", map[string]string{
+				"Origin":                         "https://app.example",
+				"Access-Control-Request-Method":  "GET",
+				"Access-Control-Request-Headers": "x-api-key, if-none-match",
+			},
+			http.StatusNoContent,
+			map[string]string{
+				"Access-Control-Allow-Origin":  "*",
+				"Access-Control-Allow-Methods": "GET",
+				"Access-Control-Allow-Headers": "x-api-key, if-none-match",
+				"Access-Control-Max-Age":       "600",
+			},
+		},
+		{
+			"preflight with header not allowed",
+			"OPTIONS", "/people", map[string]string{
+				"Origin":                         "https://app.example",
+				"Access-Control-Request-Method":  "GET",
+				"Access-Control-Request-Headers": "X-Other",
+			},
+			http.StatusNoContent,
+			map[string]string{"Access-Control-Allow-Methods": "", "Access-Control-Allow-Headers": ""},
+		},
+		{
+			"preflight with method not allowed",
+			"OPTIONS", "/people", map[string]string{
+				"Origin":                        "https://app.example",
+				"Access-Control-Request-Method": "DELETE",
+			},
+			http.StatusNoContent,
+			map[string]string{"Access-Control-Allow-Methods": ""},
+		},
+		{
+			"datasource policy allows its origin with credentials",
+			"GET", "/users", map[string]string{"Origin": "https://portal.example"},
+			http.StatusOK,
+			map[string]string{"Access-Control-Allow-Origin": "https://portal.example", "Access-Control-Allow-Credentials": "true"},
+		},
+		{
+			"datasource policy rejects other origins",
+			"GET", "/users", map[string]string{"Origin": "https://app.example"},
+			http.StatusOK,
+			map[string]string{"Access-Control-Allow-Origin": ""},
+		},
+		{
+			"plain options request",
+			"OPTIONS", "/people", nil,
+			http.StatusNoContent,
+			map[string]string{"Allow": "GET, OPTIONS"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(tc.method, tc.requestURI, nil)
+			for k, v := range tc.headers {
+				req.Header.Set(k, v)
+			}
+			rr := httptest.NewRecorder()
+			testMux.ServeHTTP(rr, req)
+
+			if rr.Code != tc.wantStatus {
+				t.Errorf("failed got %v wanted %v", rr.Code, tc.wantStatus)
+			}
+			for k, want := range tc.wantHeaders {
+				if got := rr.Header().Get(k); got != want {
+					t.Errorf("failed got %s %q wanted %q", k, got, want)
+				}
+			}
+		})
+	}
+
+	t.Run("no exposed headers", func(t *testing.T) {
+		handler := middleware.CORS(func(string) config.CORS {
+			return config.CORS{AllowedOrigins: []string{config.CORS_ANY}}
+		})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+		req := httptest.NewRequest("GET", "/people", nil)
+		req.Header.Set("Origin", "https://app.example")
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		if rr.Header().Get("Access-Control-Allow-Origin") != "*" {
+			t.Errorf("failed got Access-Control-Allow-Origin %q wanted %q", rr.Header().Get("Access-Control-Allow-Origin"), "*")
+		}
+		if got, ok := rr.Header()["Access-Control-Expose-Headers"]; ok {
+			t.Errorf("failed got Access-Control-Expose-Headers %q wanted none", got)
+		}
+	})
+}
+
+func TestRateLimit(t *testing.T) {
+	cfg := &config.Config{
+		PrivilegedKeys: []string{"secret-key"},
+		Server:         config.Server{RateLimit: config.RateLimit{RequestsPerSecond: 1, Burst: 3}},
+		Datasources: map[string]config.Datasource{
+			"users": {RateLimit: &config.RateLimit{RequestsPerSecond: 1, Burst: 1}},
+		},
+	}
+
+	testMux := mux.NewRouter()
+	testMux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {}).Methods("GET")
+	testMux.HandleFunc("/{datasource:[a-z0-9=\\-\\/]+}", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("[]"))
+	}).Methods("GET")
+	testMux.Use(middleware.RateLimit(ratelimit.New(), func() *config.Config { return cfg }))
+
+	testCases := []struct {
+		name          string
+		requestURI    string
+		remoteAddr    string
+		apiKey        string
+		wantStatus    int
+		wantRemaining string
+	}{
+		{"datasource limit is the most restrictive", "/users", "10.0.0.1:1000", "", http.StatusOK, "0"},
+		{"datasource limit reached", "/users", "10.0.0.1:1001", "", http.StatusTooManyRequests, "0"},
+		{"server limit still has tokens", "/people", "10.0.0.1:1002", "", http.StatusOK, "0"},
+		{"server limit reached", "/people", "10.0.0.1:1003", "", http.StatusTooManyRequests, "0"},
+		{"other address is not limited", "/people", "10.0.0.2:1000", "", http.StatusOK, "2"},
+		{"unknown key is limited by address", "/people", "10.0.0.1:1004", "made-up", http.StatusTooManyRequests, "0"},
+		{"privileged key is limited by key", "/people", "10.0.0.1:1005", "secret-key", http.StatusOK, "2"},
+		{"health check is never limited", "/healthz", "10.0.0.1:1006", "", http.StatusOK, ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", tc.requestURI, nil)
+			req.RemoteAddr = tc.remoteAddr
+			if tc.apiKey != "" {
+				req.Header.Set(internal.API_KEY_HEADER, tc.apiKey)
+			}
+			rr := httptest.NewRecorder()
+			testMux.ServeHTTP(rr, req)
+
+			if rr.Code != tc.wantStatus {
+				t.Errorf("failed got %v wanted %v", rr.Code, tc.wantStatus)
+			}
+			if got := rr.Header().Get("X-RateLimit-Remaining"); got != tc.wantRemaining {
+				t.Errorf("failed got remaining %q wanted %q", got, tc.wantRemaining)
+			}
+			if tc.wantStatus == http.StatusTooManyRequests && rr.Header().Get("Retry-After") != "1" {
+				t.Errorf("failed got Retry-After %q wanted %q", rr.Header().Get("Retry-After"), "1")
+			}
+		})
+	}
+}
+
+func TestRateLimitTrustedProxies(t *testing.T) {
+	cfg := &config.Config{
+		Server: config.Server{
+			RateLimit:      config.RateLimit{RequestsPerSecond: 1, Burst: 1},
+			TrustedProxies: []string{"10.1.0.1", "10.2.0.0/16", config.TRUSTED_PROXY_UNIX},
+		},
+	}
+
+	testMux := mux.NewRouter()
+	testMux.HandleFunc("/{datasource:[a-z0-9=\\-\\/]+}", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("[]"))
+	}).Methods("GET")
+	testMux.Use(middleware.RateLimit(ratelimit.New(), func() *config.Config { return cfg }))
+
+	testCases := []struct {
+		name         string
+		remoteAddr   string
+		forwardedFor []string
+		wantStatus   int
+	}{
+		{"trusted proxy forwards first client", "10.1.0.1:1000", []string{"203.0.113.1"}, http.StatusOK},
+		{"trusted proxy forwards second client", "10.1.0.1:1001", []string{"203.0.113.2"}, http.StatusOK},
+		{"client is limited behind the proxy", "10.1.0.1:1002", []string{"203.0.113.1"}, http.StatusTooManyRequests},
+		{"client behind a chain of trusted proxies", "10.1.0.1:1003", []string{"203.0.113.3, 10.2.5.5"}, http.StatusOK},
+		{"chain uses the same bucket", "10.2.0.9:1000", []string{"203.0.113.3"}, http.StatusTooManyRequests},
+		{"spoofed entries left of the client are ignored", "10.1.0.1:1004", []string{"198.51.100.1", "203.0.113.2"}, http.StatusTooManyRequests},
+		{"untrusted address cannot forward", "192.0.2.1:1000", []string{"203.0.113.4"}, http.StatusOK},
+		{"untrusted address is limited by its own address", "192.0.2.1:1001", []string{"203.0.113.5"}, http.StatusTooManyRequests},
+		{"invalid entry stops at the proxy", "10.1.0.1:1005", []string{"203.0.113.6, not-an-ip"}, http.StatusOK},
+		{"unix socket proxy forwards the client", "@", []string{"203.0.113.7"}, http.StatusOK},
+		{"unix socket proxy forwards another client", "@", []string{"203.0.113.8"}, http.StatusOK},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/people", nil)
+			req.RemoteAddr = tc.remoteAddr
+			for _, v := range tc.forwardedFor {
+				req.Header.Add("X-Forwarded-For", v)
+			}
+			rr := httptest.NewRecorder()
+			testMux.ServeHTTP(rr, req)
+
+			if rr.Code != tc.wantStatus {
+				t.Errorf("failed got %v wanted %v", rr.Code, tc.wantStatus)
+			}
+		})
+	}
+}
@@ -0,0 +1,171 @@
+// Package openapi generates an OpenAPI 3.1 document describing the datasources being served
+package openapi
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/spoonboy-io/dujour/internal"
+	"github.com/spoonboy-io/dujour/internal/problem"
+	"github.com/spoonboy-io/dujour/internal/schema"
+)
+
+const VERSION = "3.1.0"
+
+// Viewer is a self-contained HTML page which renders the document served at /openapi.json
+//
+//go:embed viewer.html
+var Viewer []byte
+
+// Generate creates the OpenAPI document for the datasources, each datasource has a path for all of its
+// data and, when it has records, a path for a single record by id. Schemas are inferred from the data
+func Generate(datasources []internal.Datasource, version string) ([]byte, error) {
+	sort.Slice(datasources, func(i, j int) bool {
+		return datasources[i].EndpointName < datasources[j].EndpointName
+	})
+
+	paths := map[string]interface{}{}
+	schemas := map[string]interface{}{
+		"Problem": problemSchema(),
+	}
+
+	for _, v := range datasources {
+		if !v.Available() {
+			continue
+		}
+
+		name := componentName(v.EndpointName)
+		if _, ok := schemas[name]; ok {
+			name += "Data"
+		}
+		collection := schema.Infer(v.Data)
+		schemas[name] = collection
+		paths["/"+v.EndpointName] = map[string]interface{}{
+			"get": operation(
+				"get"+name,
+				fmt.Sprintf("All data from '%s'", filepath.Base(v.FileName)),
+				nil,
+				"#/components/schemas/"+name,
+			),
+		}
+
+		record := schema.Record(collection)
+		if record == nil {
+			continue
+		}
+		schemas[name+"Record"] = record
+		paths["/"+v.EndpointName+"/{id}"] = map[string]interface{}{
+			"get": operation(
+				"get"+name+"ByID",
+				fmt.Sprintf("The record from '%s' with the id", filepath.Base(v.FileName)),
+				[]interface{}{map[string]interface{}{
+					"name":     "id",
+					"in":       "path",
+					"required": true,
+					"schema":   map[string]interface{}{"type": "string"},
+				}},
+				"#/components/schemas/"+name+"Record",
+			),
+		}
+	}
+
+	doc := map[string]interface{}{
+		"openapi": VERSION,
+		"info": map[string]interface{}{
+			"title":       "Dujour",
+			"description": "JSON/CSV data file server, each data file is served at its own endpoint",
+			"version":     version,
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas": schemas,
+			"securitySchemes": map[string]interface{}{
+				"apiKey": map[string]interface{}{
+					"type":        "apiKey",
+					"in":          "header",
+					"name":        internal.API_KEY_HEADER,
+					"description": "Privileged keys receive data without masking rules applied",
+				},
+			},
+		},
+		// the API key is optional
+		"security": []interface{}{
+			map[string]interface{}{},
+			map[string]interface{}{"apiKey": []string{}},
+		},
+	}
+
+	return json.MarshalIndent(doc, "", "  ")
+}
+
+func operation(id, summary string, parameters []interface{}, ref string) map[string]interface{} {
+	op := map[string]interface{}{
+		"operationId": id,
+		"summary":     summary,
+		"responses": map[string]interface{}{
+			"200": map[string]interface{}{
+				"description": "OK",
+				"content": map[string]interface{}{
+					"application/json": map[string]interface{}{
+						"schema": map[string]interface{}{"$ref": ref},
+					},
+				},
+			},
+			"304": map[string]interface{}{
+				"description": "Not Modified",
+			},
+			"404": problemResponse("Not Found"),
+			"429": problemResponse("Too Many Requests"),
+		},
+	}
+	if parameters != nil {
+		op["parameters"] = parameters
+	}
+	return op
+}
+
+func problemResponse(description string) map[string]interface{} {
+	return map[string]interface{}{
+		"description": description,
+		"content": map[string]interface{}{
+			problem.CONTENT_TYPE: map[string]interface{}{
+				"schema": map[string]interface{}{"$ref": "#/components/schemas/Problem"},
+			},
+		},
+	}
+}
+
+func problemSchema() map[string]interface{} {
+	str := map[string]interface{}{"type": "string"}
+	return map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"type":      str,
+			"title":     str,
+			"status":    map[string]interface{}{"type": "integer"},
+			"detail":    str,
+			"instance":  str,
+			"code":      str,
+			"requestId": str,
+		},
+		"required": []string{"type", "title", "status", "code"},
+	}
+}
+
+// componentName turns an endpoint such as 'option-list' into a schema name such as 'OptionList'
+func componentName(endpoint string) string {
+	name := ""
+	for _, v := range strings.FieldsFunc(endpoint, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9')
+	}) {
+		name += strings.ToUpper(v[:1]) + v[1:]
+	}
+	if name == "" {
+		name = "Datasource"
+	}
+	return name
+}
@@ -0,0 +1,153 @@
+// Package payload pre-serialises response bodies and their compressed variants so they can be
+// served directly without marshalling or compressing on every request
+package payload
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/andybalholm/brotli"
+)
+
+const (
+	ENCODING_GZIP   = "gzip"
+	ENCODING_BROTLI = "br"
+)
+
+// Payload holds a JSON body, its gzip and brotli variants and the entity tag of the body. A variant is nil
+// when compression would not make the body smaller
+type Payload struct {
+	Body    []byte
+	Gzip    []byte
+	Brotli  []byte
+	ETag    string
+	ModTime time.Time
+}
+
+// New marshals data as indented JSON and compresses it
+func New(data interface{}, modTime time.Time) (*Payload, error) {
+	body, err := json.MarshalIndent(data, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return FromBytes(body, modTime)
+}
+
+// FromBytes creates a payload from an already serialised body
+func FromBytes(body []byte, modTime time.Time) (*Payload, error) {
+	p := &Payload{
+		Body:    body,
+		ETag:    ETag(body),
+		ModTime: modTime,
+	}
+
+	gz := &bytes.Buffer{}
+	gw, _ := gzip.NewWriterLevel(gz, gzip.BestCompression)
+	if _, err := gw.Write(body); err != nil {
+		return nil, err
+	}
+	if err := gw.Close(); err != nil {
+		return nil, err
+	}
+	if gz.Len() < len(body) {
+		p.Gzip = gz.Bytes()
+	}
+
+	br := &bytes.Buffer{}
+	bw := brotli.NewWriterLevel(br, brotli.DefaultCompression)
+	if _, err := bw.Write(body); err != nil {
+		return nil, err
+	}
+	if err := bw.Close(); err != nil {
+		return nil, err
+	}
+	if br.Len() < len(body) {
+		p.Brotli = br.Bytes()
+	}
+
+	return p, nil
+}
+
+// Serve writes the variant best matching the request Accept-Encoding header with ETag, Last-Modified and
+// Vary headers, conditional requests are answered 304 Not Modified when the content is unchanged
+func (p *Payload) Serve(w http.ResponseWriter, r *http.Request) {
+	body, tag := p.Body, p.ETag
+
+	switch Negotiate(r.Header.Get("Accept-Encoding"), p.Brotli != nil, p.Gzip != nil) {
+	case ENCODING_BROTLI:
+		body, tag = p.Brotli, variantTag(p.ETag, ENCODING_BROTLI)
+		w.Header().Set("Content-Encoding", ENCODING_BROTLI)
+	case ENCODING_GZIP:
+		body, tag = p.Gzip, variantTag(p.ETag, ENCODING_GZIP)
+		w.Header().Set("Content-Encoding", ENCODING_GZIP)
+	}
+
+	w.Header().Add("Vary", "Accept-Encoding")
+	w.Header().Set("ETag", tag)
+	http.ServeContent(w, r, "", p.ModTime, bytes.NewReader(body))
+}
+
+// ETag returns the strong entity tag for a body
+func ETag(body []byte) string {
+	sum := sha256.Sum256(body)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// variantTag gives each content coding its own entity tag as the bytes sent differ
+func variantTag(etag, encoding string) string {
+	return strings.TrimSuffix(etag, `"`) + "-" + encoding + `"`
+}
+
+// Negotiate picks brotli or gzip from an Accept-Encoding header according to the quality values, preferring
+// brotli when both are equally acceptable. It returns an empty string when the body should not be compressed
+func Negotiate(acceptEncoding string, brotliOK, gzipOK bool) string {
+	quality := map[string]float64{}
+	for _, v := range strings.Split(acceptEncoding, ",") {
+		parts := strings.Split(v, ";")
+		coding := strings.ToLower(strings.TrimSpace(parts[0]))
+		if coding == "" {
+			continue
+		}
+		q := 1.0
+		for _, param := range parts[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if f, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					q = f
+				}
+			}
+		}
+		quality[coding] = q
+	}
+
+	qualityOf := func(coding string) float64 {
+		if q, ok := quality[coding]; ok {
+			return q
+		}
+		return quality["*"]
+	}
+
+	brQ, gzQ := 0.0, 0.0
+	if brotliOK {
+		brQ = qualityOf(ENCODING_BROTLI)
+	}
+	if gzipOK {
+		gzQ = qualityOf(ENCODING_GZIP)
+	}
+
+	switch {
+	case brQ > 0 && brQ >= gzQ:
+		return ENCODING_BROTLI
+	case gzQ > 0:
+		return ENCODING_GZIP
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,122 @@
+package payload_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andybalholm/brotli"
+
+	"github.com/spoonboy-io/dujour/internal/payload"
+)
+
+func TestNegotiate(t *testing.T) {
+	testCases := []struct {
+		name           string
+		acceptEncoding string
+		brotliOK       bool
+		gzipOK         bool
+		want           string
+	}{
+		{"no header", "", true, true, ""},
+		{"prefers brotli", "gzip, deflate, br", true, true, payload.ENCODING_BROTLI},
+		{"gzip only", "gzip", true, true, payload.ENCODING_GZIP},
+		{"brotli not available", "gzip, br", false, true, payload.ENCODING_GZIP},
+		{"higher quality gzip", "br;q=0.5, gzip", true, true, payload.ENCODING_GZIP},
+		{"brotli refused", "br;q=0, *", true, true, payload.ENCODING_GZIP},
+		{"wildcard", "*", true, true, payload.ENCODING_BROTLI},
+		{"identity only", "identity", true, true, ""},
+		{"nothing available", "gzip, br", false, false, ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := payload.Negotiate(tc.acceptEncoding, tc.brotliOK, tc.gzipOK); got != tc.want {
+				t.Errorf("failed got %q wanted %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestServe(t *testing.T) {
+	records := []map[string]string{}
+	for i := 0; i < 100; i++ {
+		records = append(records, map[string]string{"id": "1", "name": "A repeated name to compress"})
+	}
+	modTime := time.Date(2022, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	p, err := payload.New(records, modTime)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Gzip == nil || p.Brotli == nil {
+		t.Fatalf("failed compressed variants missing")
+	}
+
+	decoders := map[string]func(io.Reader) (io.Reader, error){
+		"": func(r io.Reader) (io.Reader, error) { return r, nil },
+		payload.ENCODING_GZIP: func(r io.Reader) (io.Reader, error) {
+			return gzip.NewReader(r)
+		},
+		payload.ENCODING_BROTLI: func(r io.Reader) (io.Reader, error) {
+			return brotli.NewReader(r), nil
+		},
+	}
+
+	tags := map[string]bool{}
+	for _, encoding := range []string{"", payload.ENCODING_GZIP, payload.ENCODING_BROTLI} {
+		t.Run("encoding "+encoding, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/people", nil)
+			req.Header.Set("Accept-Encoding", encoding)
+			rr := httptest.NewRecorder()
+			p.Serve(rr, req)
+
+			if got := rr.Header().Get("Content-Encoding"); got != encoding {
+				t.Errorf("failed got encoding %q wanted %q", got, encoding)
+			}
+			if got := rr.Header().Get("Vary"); !strings.Contains(got, "Accept-Encoding") {
+				t.Errorf("failed got Vary %q", got)
+			}
+			tags[rr.Header().Get("ETag")] = true
+
+			dec, err := decoders[encoding](rr.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, err := io.ReadAll(dec)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(body, p.Body) {
+				t.Errorf("failed decoded body does not match")
+			}
+
+			// the tag of the variant is required for a conditional request
+			req.Header.Set("If-None-Match", rr.Header().Get("ETag"))
+			rr = httptest.NewRecorder()
+			p.Serve(rr, req)
+			if rr.Code != http.StatusNotModified {
+				t.Errorf("failed got %v wanted %v", rr.Code, http.StatusNotModified)
+			}
+		})
+	}
+
+	if len(tags) != 3 {
+		t.Errorf("failed each encoding should have its own ETag got %v", tags)
+	}
+}
+
+func TestSmallBodyNotCompressed(t *testing.T) {
+	p, err := payload.FromBytes([]byte("{}"), time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Gzip != nil || p.Brotli != nil {
+		t.Errorf("failed compressed variants kept for a body they do not shrink")
+	}
+}
@@ -0,0 +1,84 @@
+// Package problem writes RFC 7807 problem details error responses
+package problem
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+const CONTENT_TYPE = "application/problem+json"
+
+// stable error codes, clients may rely on these not changing
+const (
+	CODE_NOT_FOUND             = "not_found"
+	CODE_METHOD_NOT_ALLOWED    = "method_not_allowed"
+	CODE_DATASOURCE_NOT_FOUND  = "datasource_not_found"
+	CODE_RECORD_NOT_FOUND      = "record_not_found"
+	CODE_MALFORMED_QUERY       = "malformed_query"
+	CODE_UNSUPPORTED_DATA_TYPE = "unsupported_data_type"
+	CODE_SERIALISATION_FAILED  = "serialisation_failed"
+	CODE_CA_NOT_ENABLED        = "ca_not_enabled"
+	CODE_CA_UNAVAILABLE        = "ca_unavailable"
+	CODE_VIEWER_NOT_ENABLED    = "viewer_not_enabled"
+	CODE_RATE_LIMITED          = "rate_limited"
+	CODE_STORE_UNAVAILABLE     = "store_unavailable"
+)
+
+// Problem is the problem details object. Type is derived from the stable Code, and RequestID is
+// the X-Request-ID assigned to the request so the error can be found in the access log
+type Problem struct {
+	Type      string `json:"type"`
+	Title     string `json:"title"`
+	Status    int    `json:"status"`
+	Detail    string `json:"detail,omitempty"`
+	Instance  string `json:"instance,omitempty"`
+	Code      string `json:"code"`
+	RequestID string `json:"requestId,omitempty"`
+}
+
+// New creates a problem for the status and code
+func New(status int, code, detail string) Problem {
+	return Problem{
+		Type:   "urn:dujour:problem:" + code,
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+		Code:   code,
+	}
+}
+
+// Write writes a problem response for the request, any Content-Encoding, ETag or Last-Modified
+// headers set for a successful response are removed
+func Write(w http.ResponseWriter, r *http.Request, status int, code, detail string) {
+	p := New(status, code, detail)
+	p.Instance = r.URL.Path
+	p.RequestID = w.Header().Get("X-Request-ID")
+
+	body, err := json.Marshal(p)
+	if err != nil {
+		body = []byte(fmt.Sprintf(`{"status":%d,"code":"%s"}`, status, code))
+	}
+
+	for _, v := range []string{"Content-Encoding", "ETag", "Last-Modified"} {
+		w.Header().Del(v)
+	}
+	w.Header().Set("Content-Type", CONTENT_TYPE)
+	w.WriteHeader(status)
+	_, _ = w.Write(body)
+}
+
+// NotFoundHandler answers requests which match no route, for use as mux.Router.NotFoundHandler
+func NotFoundHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		Write(w, r, http.StatusNotFound, CODE_NOT_FOUND, fmt.Sprintf("No route matches '%s'", r.URL.Path))
+	})
+}
+
+// MethodNotAllowedHandler answers requests for a route with an unsupported method, for use as
+// mux.Router.MethodNotAllowedHandler
+func MethodNotAllowedHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		Write(w, r, http.StatusMethodNotAllowed, CODE_METHOD_NOT_ALLOWED, fmt.Sprintf("Method %s is not supported, only GET", r.Method))
+	})
+}
@@ -0,0 +1,93 @@
+// Package ratelimit provides token bucket rate limiting keyed by client
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/spoonboy-io/dujour/internal"
+)
+
+// Limiter holds a token bucket per key. The rate and burst are passed on every call rather than stored
+// so configuration changes apply to existing buckets immediately
+type Limiter struct {
+	mtx       sync.Mutex
+	buckets   map[string]*bucket
+	lastPrune time.Time
+
+	// Now returns the current time, it can be replaced in tests
+	Now func() time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// Result describes the state of a bucket after a request
+type Result struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int
+	RetryAfter time.Duration
+	Reset      time.Duration
+}
+
+// New creates an empty limiter
+func New() *Limiter {
+	return &Limiter{
+		buckets: map[string]*bucket{},
+		Now:     time.Now,
+	}
+}
+
+// Take removes a token from the bucket for key, which refills at rate tokens per second up to burst.
+// A new bucket starts full. When the bucket is empty the request is not allowed and RetryAfter is the
+// time until a token is available
+func (l *Limiter) Take(key string, rate float64, burst int) Result {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	now := l.Now()
+	l.prune(now)
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(burst), last: now}
+		l.buckets[key] = b
+	}
+
+	b.tokens = math.Min(float64(burst), b.tokens+now.Sub(b.last).Seconds()*rate)
+	b.last = now
+
+	res := Result{Limit: burst}
+	if b.tokens >= 1 {
+		b.tokens--
+		res.Allowed = true
+	} else {
+		res.RetryAfter = seconds((1 - b.tokens) / rate)
+	}
+	res.Remaining = int(math.Floor(b.tokens))
+	res.Reset = seconds((float64(burst) - b.tokens) / rate)
+
+	return res
+}
+
+// prune discards buckets which have not been used recently, they would have refilled anyway
+func (l *Limiter) prune(now time.Time) {
+	if now.Sub(l.lastPrune) < internal.RATE_LIMIT_IDLE {
+		return
+	}
+	l.lastPrune = now
+
+	for k, v := range l.buckets {
+		if now.Sub(v.last) > internal.RATE_LIMIT_IDLE {
+			delete(l.buckets, k)
+		}
+	}
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
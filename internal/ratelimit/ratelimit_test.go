@@ -0,0 +1,42 @@
+package ratelimit_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/spoonboy-io/dujour/internal/ratelimit"
+)
+
+func TestTake(t *testing.T) {
+	now := time.Date(2022, 3, 1, 12, 0, 0, 0, time.UTC)
+	l := ratelimit.New()
+	l.Now = func() time.Time { return now }
+
+	testCases := []struct {
+		name          string
+		advance       time.Duration
+		key           string
+		wantAllowed   bool
+		wantRemaining int
+	}{
+		{"new bucket starts full", 0, "a", true, 1},
+		{"second request uses the burst", 0, "a", true, 0},
+		{"empty bucket is limited", 0, "a", false, 0},
+		{"other clients have their own bucket", 0, "b", true, 1},
+		{"bucket refills at the rate", time.Second, "a", true, 0},
+		{"bucket does not refill beyond the burst", time.Minute, "a", true, 1},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			now = now.Add(tc.advance)
+			res := l.Take(tc.key, 1, 2)
+			if res.Allowed != tc.wantAllowed || res.Remaining != tc.wantRemaining {
+				t.Errorf("failed got allowed %v remaining %d wanted %v %d", res.Allowed, res.Remaining, tc.wantAllowed, tc.wantRemaining)
+			}
+			if !res.Allowed && res.RetryAfter != time.Second {
+				t.Errorf("failed got retry after %v wanted %v", res.RetryAfter, time.Second)
+			}
+		})
+	}
+}
@@ -2,22 +2,245 @@
 package routes
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"net/url"
+	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/gorilla/mux"
 
 	"github.com/spoonboy-io/dujour/internal"
-	"github.com/spoonboy-io/koan"
+	"github.com/spoonboy-io/dujour/internal/certificate"
+	"github.com/spoonboy-io/dujour/internal/config"
+	"github.com/spoonboy-io/dujour/internal/mask"
+	"github.com/spoonboy-io/dujour/internal/metrics"
+	"github.com/spoonboy-io/dujour/internal/openapi"
+	"github.com/spoonboy-io/dujour/internal/payload"
+	"github.com/spoonboy-io/dujour/internal/problem"
+	"github.com/spoonboy-io/dujour/internal/schema"
+	"github.com/spoonboy-io/dujour/internal/store"
 )
 
+// App is the handler context for the datasources of a data folder, which are held in Store
 type App struct {
-	Logger      *koan.Logger
-	Datasources map[string]internal.Datasource
-	Mtx         *sync.Mutex
+	Logger     internal.Logger
+	Store      store.Store
+	Config     *config.Config
+	DataFolder string
+	Version    string
+
+	// set while the watcher is running, the app is created after the initial load
+	watching int32
+
+	// guards Config and the serialised datasources and their schemas, rebuilt when a datasource is
+	// reloaded, and the OpenAPI document regenerated when any datasource changes
+	mtx     sync.Mutex
+	cache   map[cacheKey]cachedPayload
+	openAPI *payload.Payload
+
+	// the files loaded from the data folder by the last Load or Reload, only these are deleted from the
+	// store when they are no longer found, as a shared store also holds the files of other instances
+	files map[string]bool
+}
+
+type cacheKey struct {
+	fileName string
+	masked   bool
+	schema   bool
+}
+
+type cachedPayload struct {
+	loadedAt time.Time
+	payload  *payload.Payload
+}
+
+// this is the information we will output for status
+type statusDS struct {
+	Endpoint    string     `json:"endpoint"`
+	Source      string     `json:"source"`
+	Type        string     `json:"type"`
+	Records     int        `json:"records"`
+	Bytes       int64      `json:"bytes"`
+	LastLoad    *time.Time `json:"lastLoad"`
+	Hash        string     `json:"hash"`
+	LastError   string     `json:"lastError"`
+	Schema      string     `json:"schema,omitempty"`
+	Quarantined []string   `json:"quarantined,omitempty"`
+}
+
+type status struct {
+	Ready       bool       `json:"ready"`
+	Datasources []statusDS `json:"datasources"`
+}
+
+// SetWatching records whether the watcher for the app data folder is running, it is passed to watcher.Monitor
+func (a *App) SetWatching(watching bool) {
+	var v int32
+	if watching {
+		v = 1
+	}
+	atomic.StoreInt32(&a.watching, v)
+}
+
+// Ready reports whether the initial load of datasources has completed and the watcher is running
+func (a *App) Ready() bool {
+	return atomic.LoadInt32(&a.watching) == 1
+}
+
+// Load puts the datasources loaded from the data folder in the store, keeping those the store holds
+// from the same or newer files, see store.Load
+func (a *App) Load(datasources map[string]internal.Datasource) {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+	store.Load(a.Store, datasources)
+	a.files = fileNames(datasources)
+}
+
+// Reload replaces the configuration and reloads the datasources served by the app, a datasource which
+// fails to load keeps its previous version. Datasources the store holds from the same or newer files are
+// kept, and only datasources whose file was loaded by the app and has since been removed are deleted
+func (a *App) Reload(cfg *config.Config, datasources map[string]internal.Datasource) {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+
+	loaded := map[string]internal.Datasource{}
+	for k, v := range datasources {
+		// keep serving the previous version of a datasource which failed to load, recording the error
+		if prev, ok := a.Store.Get(k); ok && v.LastError != "" && prev.Data != nil {
+			prev.LastError = v.LastError
+			a.Store.Put(prev)
+			continue
+		}
+		loaded[k] = v
+	}
+	a.Config = cfg
+
+	for k := range a.files {
+		if _, ok := datasources[k]; !ok {
+			a.Store.Delete(k)
+		}
+	}
+	store.Load(a.Store, loaded)
+	a.files = fileNames(datasources)
+
+	// masking rules may have changed
+	a.cache = nil
+	a.prime()
+}
+
+func fileNames(datasources map[string]internal.Datasource) map[string]bool {
+	files := make(map[string]bool, len(datasources))
+	for k := range datasources {
+		files[k] = true
+	}
+	return files
+}
+
+// CurrentConfig returns the configuration, which is replaced when it is reloaded
+func (a *App) CurrentConfig() *config.Config {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+	return a.Config
+}
+
+// Known reports whether a datasource is served at the endpoint, it is passed to middleware.Metrics so only
+// served datasources are used as metric labels
+func (a *App) Known(endpoint string) bool {
+	_, ok := a.Store.Find(strings.ToLower(endpoint))
+	return ok
+}
+
+// CORSPolicy returns the cross-origin policy for the datasource served at endpoint, it is passed to
+// middleware.CORS so policy changes take effect when the configuration is reloaded
+func (a *App) CORSPolicy(endpoint string) config.CORS {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+	return a.Config.CORSPolicy(endpoint)
+}
+
+// Prime serialises every datasource so the first requests are served from the cache, drops cached
+// datasources which have been removed and regenerates the OpenAPI document
+func (a *App) Prime() {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+	a.prime()
+}
+
+// Follow primes the app each time the datasources in the store change, it blocks until ctx is cancelled.
+// Changes which arrive together are primed once
+func (a *App) Follow(ctx context.Context) {
+	changes, cancel := a.Store.Subscribe()
+	defer cancel()
+
+	// catch up with changes made before subscribing
+	a.Prime()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-changes:
+		}
+
+	drain:
+		for {
+			select {
+			case <-changes:
+			default:
+				break drain
+			}
+		}
+		a.Prime()
+	}
+}
+
+func (a *App) prime() {
+	list := a.Store.List()
+
+	current := map[string]bool{}
+	for _, v := range list {
+		current[v.FileName] = true
+	}
+	for k := range a.cache {
+		if !current[k.fileName] {
+			delete(a.cache, k)
+		}
+	}
+
+	for _, v := range list {
+		if v.Data == nil {
+			continue
+		}
+		for _, masked := range []bool{false, true} {
+			if _, err := a.cachedPayload(v, masked); err != nil {
+				a.Logger.Error(fmt.Sprintf("Could not serialise datasource '%s'", v.FileName), err)
+			}
+			if _, err := a.cachedSchema(v, masked); err != nil {
+				a.Logger.Error(fmt.Sprintf("Could not serialise schema of datasource '%s'", v.FileName), err)
+			}
+		}
+	}
+
+	// the document describes the data as unprivileged callers see it, like the schema of each datasource
+	documented := make([]internal.Datasource, 0, len(list))
+	for _, v := range list {
+		v.Data = mask.Apply(v.Data, a.Config.MaskRules(v.EndpointName))
+		documented = append(documented, v)
+	}
+	doc, err := openapi.Generate(documented, a.Version)
+	if err == nil {
+		a.openAPI, err = payload.FromBytes(doc, time.Now())
+	}
+	if err != nil {
+		a.Logger.Error("Could not generate the OpenAPI document", err)
+	}
 }
 
 // this is the information we will output for list
@@ -26,9 +249,94 @@ type listDS struct {
 	Source   string `json:"source"`
 }
 
+// Snapshot returns a copy of the datasources currently served by the app
+func (a *App) Snapshot() []internal.Datasource {
+	return a.Store.List()
+}
+
+// applyMask applies the masking rules configured for the datasource, unless the request
+// presents a privileged key in which case the data is returned unmodified
+func (a *App) applyMask(r *http.Request, endpoint string, data interface{}) interface{} {
+	if a.Config.IsPrivileged(r.Header.Get(internal.API_KEY_HEADER)) {
+		return data
+	}
+	return mask.Apply(data, a.Config.MaskRules(endpoint))
+}
+
+// setPrivacyHeaders varies the response on the API key when the datasource has masking rules, so a shared cache
+// does not serve unmasked data to other clients, and marks privileged responses private. It reports whether the
+// request is privileged and must be called with mtx held
+func (a *App) setPrivacyHeaders(w http.ResponseWriter, r *http.Request, endpoint string) bool {
+	if len(a.Config.MaskRules(endpoint)) > 0 {
+		w.Header().Add("Vary", internal.API_KEY_HEADER)
+	}
+	privileged := a.Config.IsPrivileged(r.Header.Get(internal.API_KEY_HEADER))
+	if privileged {
+		w.Header().Set("Cache-Control", "private")
+	}
+	return privileged
+}
+
+// cachedPayload returns the serialised datasource, masked unless the request is privileged, building it
+// if the datasource has been loaded since it was cached. It must be called with mtx held
+func (a *App) cachedPayload(ds internal.Datasource, masked bool) (*payload.Payload, error) {
+	// without masking rules both variants are the same
+	rules := a.Config.MaskRules(ds.EndpointName)
+	key := cacheKey{fileName: ds.FileName, masked: masked && len(rules) > 0}
+
+	if c, ok := a.cache[key]; ok && c.loadedAt.Equal(ds.LoadedAt) {
+		return c.payload, nil
+	}
+
+	data := ds.Data
+	if key.masked {
+		data = mask.Apply(data, rules)
+	}
+	p, err := payload.New(data, ds.ModTime)
+	if err != nil {
+		return nil, err
+	}
+
+	if a.cache == nil {
+		a.cache = map[cacheKey]cachedPayload{}
+	}
+	a.cache[key] = cachedPayload{loadedAt: ds.LoadedAt, payload: p}
+	return p, nil
+}
+
+// cachedSchema returns the serialised schema inferred from the datasource, masked unless the request is
+// privileged, building it if the datasource has been loaded since it was cached. It must be called with
+// mtx held
+func (a *App) cachedSchema(ds internal.Datasource, masked bool) (*payload.Payload, error) {
+	rules := a.Config.MaskRules(ds.EndpointName)
+	key := cacheKey{fileName: ds.FileName, masked: masked && len(rules) > 0, schema: true}
+
+	if c, ok := a.cache[key]; ok && c.loadedAt.Equal(ds.LoadedAt) {
+		return c.payload, nil
+	}
+
+	data := ds.Data
+	if key.masked {
+		data = mask.Apply(data, rules)
+	}
+	doc, err := schema.Document(schema.Describe(data), ds.EndpointName)
+	if err != nil {
+		return nil, err
+	}
+	p, err := payload.FromBytes(doc, ds.ModTime)
+	if err != nil {
+		return nil, err
+	}
+
+	if a.cache == nil {
+		a.cache = map[cacheKey]cachedPayload{}
+	}
+	a.cache[key] = cachedPayload{loadedAt: ds.LoadedAt, payload: p}
+	return p, nil
+}
+
 // Home provides basic instruction on how to poll the datasources hosted by the application as text format.
 func (a *App) Home(w http.ResponseWriter, _ *http.Request) {
-	w.Header().Set("Access-Control-Allow-Origin", "*")
 	w.Header().Set("Content-Type", "text/plain")
 
 	res := "Dujour - JSON/CSV Data Server\n"
@@ -38,21 +346,151 @@ func (a *App) Home(w http.ResponseWriter, _ *http.Request) {
 	res += "GET /list \t\t- JSON array of all loaded datasources\n"
 	res += "GET /{datasource} \t- JSON representing all elements/rows for requested {datasource} or 404\n"
 	res += "GET /{datasource}/{id} \t- JSON representing element/row matching {id} from requested {datasource} or 404\n"
+	res += "GET /{datasource}/_schema - JSON Schema inferred from the records of requested {datasource} or 404\n"
+	res += "GET /ca.pem \t\t- PEM certificate of the local certificate authority when running in CA mode or 404\n"
+	res += "GET /metrics \t\t- Prometheus metrics\n"
+	res += "GET /healthz \t\t- Liveness check\n"
+	res += "GET /readyz \t\t- Readiness check, 503 until datasources are loaded and watched\n"
+	res += "GET /status \t\t- JSON status of every datasource including load errors\n"
+	res += "GET /openapi.json \t- OpenAPI document describing the datasources\n"
+	res += "GET /docs \t\t- HTML viewer for the OpenAPI document when enabled or 404\n"
 
-	a.Logger.Info("Served GET / request - 200 OK")
 	_, _ = fmt.Fprint(w, res)
 }
 
+// CACertificate serves the certificate of the local certificate authority in PEM format, so that clients
+// can trust the CA once rather than each server certificate. It is only available in CA mode
+func (a *App) CACertificate(w http.ResponseWriter, r *http.Request) {
+	a.mtx.Lock()
+	caMode := a.Config != nil && a.Config.TLS.Mode == config.TLS_MODE_CA
+	a.mtx.Unlock()
+
+	if !caMode {
+		problem.Write(w, r, http.StatusNotFound, problem.CODE_CA_NOT_ENABLED, "The local certificate authority is only available when tls.mode is 'ca'")
+		return
+	}
+
+	res, err := certificate.CACertificate()
+	if err != nil {
+		a.Logger.Error("Reading CA certificate:", err)
+		problem.Write(w, r, http.StatusInternalServerError, problem.CODE_CA_UNAVAILABLE, "The CA certificate could not be read")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-pem-file")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(res)
+}
+
+// OpenAPI serves the OpenAPI document describing the datasources, it is regenerated whenever they change
+func (a *App) OpenAPI(w http.ResponseWriter, r *http.Request) {
+	a.mtx.Lock()
+	if a.openAPI == nil {
+		a.prime()
+	}
+	p := a.openAPI
+	a.mtx.Unlock()
+
+	if p == nil {
+		problem.Write(w, r, http.StatusInternalServerError, problem.CODE_SERIALISATION_FAILED, "The OpenAPI document could not be generated")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	p.Serve(w, r)
+}
+
+// Docs serves an HTML viewer for the OpenAPI document when it is enabled in the configuration
+func (a *App) Docs(w http.ResponseWriter, r *http.Request) {
+	if !a.CurrentConfig().OpenAPIViewer() {
+		problem.Write(w, r, http.StatusNotFound, problem.CODE_VIEWER_NOT_ENABLED, "The OpenAPI viewer is only available when openapi.viewer is enabled")
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(openapi.Viewer)
+}
+
+// Health reports that the process is alive
+func (a *App) Health(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_, _ = fmt.Fprint(w, `{"status":"ok"}`)
+}
+
+// Readiness reports whether the app is ready to serve datasources, 503 Service Unavailable if not
+func (a *App) Readiness(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if !a.Ready() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		_, _ = fmt.Fprint(w, `{"status":"not ready"}`)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	_, _ = fmt.Fprint(w, `{"status":"ready"}`)
+}
+
+// Status lists every datasource including those which failed to load, with load metadata and the last
+// error so broken files can be seen without reading the logs
+func (a *App) Status(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	res := status{
+		Ready:       a.Ready(),
+		Datasources: []statusDS{},
+	}
+
+	for _, v := range a.Snapshot() {
+		ds := statusDS{
+			Endpoint:    v.EndpointName,
+			Source:      v.FileName,
+			Type:        "json",
+			Records:     v.RecordCount(),
+			Bytes:       v.Size,
+			Hash:        v.Hash,
+			LastError:   v.LastError,
+			Schema:      v.Schema,
+			Quarantined: v.Quarantined,
+		}
+		if v.FileType == internal.TYPE_CSV {
+			ds.Type = "csv"
+		}
+		if !v.LoadedAt.IsZero() {
+			loadedAt := v.LoadedAt
+			ds.LastLoad = &loadedAt
+		}
+		res.Datasources = append(res.Datasources, ds)
+	}
+
+	sort.Slice(res.Datasources, func(i, j int) bool {
+		return res.Datasources[i].Source < res.Datasources[j].Source
+	})
+
+	out, err := json.MarshalIndent(res, "", "  ")
+	if err != nil {
+		a.Logger.Error("Marshalling Status:", err)
+		problem.Write(w, r, http.StatusInternalServerError, problem.CODE_SERIALISATION_FAILED, "Status could not be serialised")
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	_, _ = fmt.Fprint(w, string(out))
+}
+
 // ListDatasources provides a summary of datasources hosted by the application in JSON format
-func (a *App) ListDatasources(w http.ResponseWriter, _ *http.Request) {
-	w.Header().Set("Access-Control-Allow-Origin", "*")
+func (a *App) ListDatasources(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
 	list := []listDS{}
 
 	// iterate the datasources
-	a.Mtx.Lock()
-	for _, v := range a.Datasources {
+	for _, v := range a.Store.List() {
+		if !v.Available() {
+			continue
+		}
 		ds := listDS{
 			v.EndpointName,
 			v.FileName,
@@ -60,179 +498,154 @@ func (a *App) ListDatasources(w http.ResponseWriter, _ *http.Request) {
 
 		list = append(list, ds)
 	}
-	a.Mtx.Unlock()
+
+	// a stable order regardless of map iteration
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Endpoint < list[j].Endpoint
+	})
 
 	res, err := json.MarshalIndent(list, "", "  ")
 	if err != nil {
 		a.Logger.Error("Marshalling ListDatasources:", err)
-		w.WriteHeader(http.StatusInternalServerError)
+		problem.Write(w, r, http.StatusInternalServerError, problem.CODE_SERIALISATION_FAILED, "Datasource list could not be serialised")
 		return
 	}
 
-	a.Logger.Info("Served GET /list request - 200 OK")
 	w.WriteHeader(http.StatusOK)
 	_, _ = fmt.Fprint(w, string(res))
 }
 
-// DatasourceGetAll will retrieve all data for a datasource in JSON format
+// DatasourceGetAll will retrieve all data for a datasource in JSON format. The response is serialised and
+// compressed once per load of the datasource and served from the cache until the file is reloaded
 func (a *App) DatasourceGetAll(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Access-Control-Allow-Origin", "*")
 	w.Header().Set("Content-Type", "application/json")
 
+	if _, err := url.ParseQuery(r.URL.RawQuery); err != nil {
+		problem.Write(w, r, http.StatusBadRequest, problem.CODE_MALFORMED_QUERY, fmt.Sprintf("Query string could not be parsed; %v", err))
+		return
+	}
+
 	vars := mux.Vars(r)
 	dsReq := strings.ToLower(vars["datasource"])
-	foundMarker := false
-	var res []byte
+
+	var p *payload.Payload
 	var err error
+	if ds, ok := a.Store.Find(dsReq); ok {
+		a.mtx.Lock()
+		p, err = a.cachedPayload(ds, !a.setPrivacyHeaders(w, r, ds.EndpointName))
+		a.mtx.Unlock()
+	}
 
-	// we are reading from the map, should not need mutex but adding
-	a.Mtx.Lock()
-	for _, v := range a.Datasources {
-		if v.EndpointName == dsReq {
-			foundMarker = true
-			res, err = json.MarshalIndent(v.Data, "", "  ")
-			if err != nil {
-				a.Logger.Error("Marshaling DatasourceGetAll:", err)
-				w.WriteHeader(http.StatusInternalServerError)
-				return
-			}
-		}
+	if err != nil {
+		a.Logger.Error("Marshaling DatasourceGetAll:", err)
+		problem.Write(w, r, http.StatusInternalServerError, problem.CODE_SERIALISATION_FAILED, fmt.Sprintf("Datasource '%s' could not be serialised", dsReq))
+		return
 	}
-	a.Mtx.Unlock()
 
-	if !foundMarker {
-		logMsg := fmt.Sprintf("Served GET /%s request - 404 Not Found", dsReq)
-		a.Logger.Info(logMsg)
-		w.WriteHeader(http.StatusNotFound)
-		_, _ = fmt.Fprint(w, "404 page not found")
+	if p == nil {
+		problem.Write(w, r, http.StatusNotFound, problem.CODE_DATASOURCE_NOT_FOUND, fmt.Sprintf("Datasource '%s' does not exist", dsReq))
 		return
 	}
 
-	logMsg := fmt.Sprintf("Served GET /%s request - 200 OK", dsReq)
-	a.Logger.Info(logMsg)
-	w.WriteHeader(http.StatusOK)
-	_, _ = fmt.Fprint(w, string(res))
+	p.Serve(w, r)
+}
+
+// DatasourceSchema serves a JSON Schema inferred from the data of a datasource, describing one of its
+// records, masked unless the request is privileged. The schema is inferred and compressed once per load
+// of the datasource and served from the cache until the file is reloaded
+func (a *App) DatasourceSchema(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", schema.CONTENT_TYPE)
+
+	vars := mux.Vars(r)
+	dsReq := strings.ToLower(vars["datasource"])
+
+	ds, ok := a.Store.Find(dsReq)
+	if !ok {
+		problem.Write(w, r, http.StatusNotFound, problem.CODE_DATASOURCE_NOT_FOUND, fmt.Sprintf("Datasource '%s' does not exist", dsReq))
+		return
+	}
+
+	a.mtx.Lock()
+	p, err := a.cachedSchema(ds, !a.setPrivacyHeaders(w, r, ds.EndpointName))
+	a.mtx.Unlock()
+
+	if err != nil {
+		a.Logger.Error("Marshalling DatasourceSchema:", err)
+		problem.Write(w, r, http.StatusInternalServerError, problem.CODE_SERIALISATION_FAILED, fmt.Sprintf("Schema for datasource '%s' could not be serialised", dsReq))
+		return
+	}
+	p.Serve(w, r)
 }
 
 // DatasourceGetByID will process a request for a datasource and return the element that matches the ID in JSON format
 func (a *App) DatasourceGetByID(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Access-Control-Allow-Origin", "*")
 	w.Header().Set("Content-Type", "application/json")
 
+	if _, err := url.ParseQuery(r.URL.RawQuery); err != nil {
+		problem.Write(w, r, http.StatusBadRequest, problem.CODE_MALFORMED_QUERY, fmt.Sprintf("Query string could not be parsed; %v", err))
+		return
+	}
+
 	vars := mux.Vars(r)
 	dsReq := vars["datasource"]
 	id := vars["id"]
 
-	foundMarker := false
-	var res []byte
-	var err error
-
-	a.Mtx.Lock()
-	for _, v := range a.Datasources {
-		if v.EndpointName == dsReq {
-			// we have the datasource but what about the id?
-			// need a type assertion to discover what we have need to parse
-			switch v.Data.(type) {
-			case []map[string]interface{}:
-				// 1
-				for _, v1 := range v.Data.([]map[string]interface{}) {
-					// key could be string
-					if fid, ok := v1["id"].(string); ok {
-						if fid == id {
-							foundMarker = true
-							res, err = json.MarshalIndent(v1, "", "  ")
-							if err != nil {
-								a.Logger.Error("Marshaling DatasourceGetByID (case 1, string):", err)
-								w.WriteHeader(http.StatusInternalServerError)
-								return
-							}
-						}
-					}
-					// key could be integer
-					if fid, ok := v1["id"].(int); ok {
-						if fmt.Sprint(fid) == id {
-							foundMarker = true
-							res, err = json.MarshalIndent(v1, "", "  ")
-							if err != nil {
-								a.Logger.Error("Marshaling DatasourceGetByID (case 1, int):", err)
-								w.WriteHeader(http.StatusInternalServerError)
-								return
-							}
-						}
-					}
-
-				}
-			case map[string]interface{}:
-				// 2
-				for _, v1 := range v.Data.(map[string]interface{}) {
-					// the value stored should a slice otherwise we don't have a list of data, only an object
-					if v2, ok := v1.([]interface{}); ok {
-						for _, v3 := range v2 {
-							if v4, ok := v3.(map[string]interface{}); ok {
-								// we can now inspect v4 for data
-								// key could be string
-								if fid, ok := v4["id"].(string); ok {
-									if fid == id {
-										foundMarker = true
-										res, err = json.MarshalIndent(v4, "", "  ")
-										if err != nil {
-											a.Logger.Error("Marshaling DatasourceGetByID (case 2, sttring):", err)
-											w.WriteHeader(http.StatusInternalServerError)
-											return
-										}
-									}
-								}
-								// key could be integer
-								if fid, ok := v4["id"].(int); ok {
-									if fmt.Sprint(fid) == id {
-										foundMarker = true
-										res, err = json.MarshalIndent(v4, "", "  ")
-										if err != nil {
-											a.Logger.Error("Marshaling DatasourceGetByID (case 2, int):", err)
-											w.WriteHeader(http.StatusInternalServerError)
-											return
-										}
-									}
-								}
-							}
-						}
-					}
-				}
-			case []map[string]string:
-				// 3
-				for _, v := range v.Data.([]map[string]string) {
-					if fid, ok := v["id"]; ok {
-						if fid == id {
-							foundMarker = true
-							res, err = json.MarshalIndent(v, "", "  ")
-							if err != nil {
-								a.Logger.Error("Marshaling DatasourceGetByID (case 3):", err)
-								w.WriteHeader(http.StatusInternalServerError)
-								return
-							}
-						}
-					}
-				}
-			default:
-				// something has gone wrong
-				a.Logger.Warn("DatasourceGetByID unexpected Type. Unhandled")
-				w.WriteHeader(http.StatusInternalServerError)
-				return
-			}
-		}
+	ds, record, err := a.Store.Record(dsReq, id)
+	switch {
+	case errors.Is(err, store.ErrUnsupportedData):
+		a.Logger.Warn("DatasourceGetByID unexpected Type. Unhandled")
+		problem.Write(w, r, http.StatusInternalServerError, problem.CODE_UNSUPPORTED_DATA_TYPE, fmt.Sprintf("Datasource '%s' has data of a type which cannot be searched by id", dsReq))
+		return
+	case errors.Is(err, store.ErrDatasourceNotFound):
+		problem.Write(w, r, http.StatusNotFound, problem.CODE_DATASOURCE_NOT_FOUND, fmt.Sprintf("Datasource '%s' does not exist", dsReq))
+		return
+	case errors.Is(err, store.ErrRecordNotFound):
+		problem.Write(w, r, http.StatusNotFound, problem.CODE_RECORD_NOT_FOUND, fmt.Sprintf("Datasource '%s' has no record with id '%s'", dsReq, id))
+		return
+	case err != nil:
+		a.Logger.Error("Finding DatasourceGetByID record:", err)
+		problem.Write(w, r, http.StatusServiceUnavailable, problem.CODE_STORE_UNAVAILABLE, fmt.Sprintf("Datasource '%s' could not be read", dsReq))
+		return
 	}
-	a.Mtx.Unlock()
 
-	if !foundMarker {
-		logMsg := fmt.Sprintf("Served GET /%s/%s request - 404 Not Found", dsReq, id)
-		a.Logger.Info(logMsg)
-		w.WriteHeader(http.StatusNotFound)
-		_, _ = fmt.Fprint(w, "404 page not found")
+	a.mtx.Lock()
+	a.setPrivacyHeaders(w, r, ds.EndpointName)
+	record = a.applyMask(r, dsReq, record)
+	a.mtx.Unlock()
+
+	res, err := json.MarshalIndent(record, "", "  ")
+	if err != nil {
+		a.Logger.Error("Marshaling DatasourceGetByID:", err)
+		problem.Write(w, r, http.StatusInternalServerError, problem.CODE_SERIALISATION_FAILED, "Record could not be serialised")
 		return
 	}
 
-	logMsg := fmt.Sprintf("Served GET /%s/%s request - 200 OK", dsReq, id)
-	a.Logger.Info(logMsg)
-	w.WriteHeader(http.StatusOK)
-	_, _ = fmt.Fprint(w, string(res))
+	p := &payload.Payload{Body: res, ETag: payload.ETag(res), ModTime: ds.ModTime}
+	p.Serve(w, r)
+}
+
+// Register adds the handlers of the app to the router
+func Register(r *mux.Router, app *App) {
+	r.NotFoundHandler = problem.NotFoundHandler()
+	r.MethodNotAllowedHandler = problem.MethodNotAllowedHandler()
+
+	r.HandleFunc(`/`, app.Home).Methods("GET", "HEAD", "OPTIONS")
+
+	// the fixed routes are the reserved endpoints, which datasources cannot use
+	fixed := map[string]http.Handler{
+		"list":         http.HandlerFunc(app.ListDatasources),
+		"ca.pem":       http.HandlerFunc(app.CACertificate),
+		"metrics":      metrics.Handler(),
+		"healthz":      http.HandlerFunc(app.Health),
+		"readyz":       http.HandlerFunc(app.Readiness),
+		"status":       http.HandlerFunc(app.Status),
+		"openapi.json": http.HandlerFunc(app.OpenAPI),
+		"docs":         http.HandlerFunc(app.Docs),
+	}
+	for _, v := range internal.RESERVED_ENDPOINTS {
+		r.Handle("/"+v, fixed[v]).Methods("GET", "HEAD", "OPTIONS")
+	}
+	r.HandleFunc("/{datasource:[a-z0-9=\\-\\/]+}/_schema", app.DatasourceSchema).Methods("GET", "HEAD", "OPTIONS")
+	r.HandleFunc("/{datasource:[a-z0-9=\\-\\/]+}/{id:[a-zA-Z0-9=\\-\\/]+}", app.DatasourceGetByID).Methods("GET", "HEAD", "OPTIONS")
+	r.HandleFunc("/{datasource:[a-z0-9=\\-\\/]+}", app.DatasourceGetAll).Methods("GET", "HEAD", "OPTIONS")
 }
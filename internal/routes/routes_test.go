@@ -6,17 +6,23 @@ import (
 	"net/http/httptest"
 	"reflect"
 	"strings"
-	"sync"
 	"testing"
+	"time"
 
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
 	"github.com/gorilla/mux"
 
 	"github.com/spoonboy-io/dujour/internal"
+	"github.com/spoonboy-io/dujour/internal/config"
+	"github.com/spoonboy-io/dujour/internal/file"
+	"github.com/spoonboy-io/dujour/internal/mask"
+	"github.com/spoonboy-io/dujour/internal/problem"
+	"github.com/spoonboy-io/dujour/internal/store"
 	"github.com/spoonboy-io/koan"
 )
 
 func createTestAppContext() *App {
-	testMtx := &sync.Mutex{}
 	testLogger := &koan.Logger{}
 	testDatasources := map[string]internal.Datasource{
 		"data/people.csv": internal.Datasource{
@@ -51,9 +57,8 @@ func createTestAppContext() *App {
 	}
 
 	testApp := &App{
-		Logger:      testLogger,
-		Datasources: testDatasources,
-		Mtx:         testMtx,
+		Logger: testLogger,
+		Store:  store.NewMemory(testDatasources),
 	}
 
 	return testApp
@@ -69,6 +74,14 @@ func TestHomeHandler(t *testing.T) {
 	expected += "GET /list \t\t- JSON array of all loaded datasources\n"
 	expected += "GET /{datasource} \t- JSON representing all elements/rows for requested {datasource} or 404\n"
 	expected += "GET /{datasource}/{id} \t- JSON representing element/row matching {id} from requested {datasource} or 404\n"
+	expected += "GET /{datasource}/_schema - JSON Schema inferred from the records of requested {datasource} or 404\n"
+	expected += "GET /ca.pem \t\t- PEM certificate of the local certificate authority when running in CA mode or 404\n"
+	expected += "GET /metrics \t\t- Prometheus metrics\n"
+	expected += "GET /healthz \t\t- Liveness check\n"
+	expected += "GET /readyz \t\t- Readiness check, 503 until datasources are loaded and watched\n"
+	expected += "GET /status \t\t- JSON status of every datasource including load errors\n"
+	expected += "GET /openapi.json \t- OpenAPI document describing the datasources\n"
+	expected += "GET /docs \t\t- HTML viewer for the OpenAPI document when enabled or 404\n"
 
 	req, err := http.NewRequest("GET", "/", nil)
 	if err != nil {
@@ -154,6 +167,93 @@ func TestListDatasources(t *testing.T) {
 	}
 }
 
+func TestReservedEndpoints(t *testing.T) {
+	testLogger := &koan.Logger{}
+
+	// a file named after a fixed route is refused when it is loaded
+	reserved, err := file.LoadAndValidate(file.InitDatasource("data/healthz.json"), nil, testLogger)
+	if err == nil {
+		t.Fatalf("failed got nil wanted error loading reserved endpoint")
+	}
+	reserved.LastError = err.Error()
+
+	app := createTestAppContext()
+	datasources := map[string]internal.Datasource{reserved.FileName: reserved}
+	for _, v := range app.Store.List() {
+		datasources[v.FileName] = v
+	}
+	app.Store = store.NewMemory(datasources)
+
+	testMux := mux.NewRouter()
+	Register(testMux, app)
+
+	rr := httptest.NewRecorder()
+	testMux.ServeHTTP(rr, httptest.NewRequest("GET", "/list", nil))
+	gotListDS := []listDS{}
+	if err := json.Unmarshal(rr.Body.Bytes(), &gotListDS); err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range gotListDS {
+		if v.Endpoint == "healthz" {
+			t.Errorf("failed got reserved endpoint in list %v", gotListDS)
+		}
+	}
+
+	rr = httptest.NewRecorder()
+	testMux.ServeHTTP(rr, httptest.NewRequest("GET", "/healthz", nil))
+	if rr.Code != http.StatusOK || rr.Body.String() != `{"status":"ok"}` {
+		t.Errorf("failed got %v %q wanted the health check", rr.Code, rr.Body.String())
+	}
+
+	// every reserved endpoint is served by the server
+	for _, v := range internal.RESERVED_ENDPOINTS {
+		var match mux.RouteMatch
+		if !testMux.Match(httptest.NewRequest("GET", "/"+v, nil), &match) {
+			t.Fatalf("failed reserved endpoint '/%s' is not routed", v)
+		}
+		if tmpl, _ := match.Route.GetPathTemplate(); tmpl != "/"+v {
+			t.Errorf("failed got route %q for reserved endpoint '/%s'", tmpl, v)
+		}
+	}
+}
+
+func TestHeadRequests(t *testing.T) {
+	app := createTestAppContext()
+	testMux := mux.NewRouter()
+	Register(testMux, app)
+
+	testCases := []struct {
+		name       string
+		method     string
+		requestURI string
+		wantStatus int
+	}{
+		{"head datasource", "HEAD", "/people", http.StatusOK},
+		{"head record", "HEAD", "/people/1", http.StatusOK},
+		{"head schema", "HEAD", "/people/_schema", http.StatusOK},
+		{"head fixed route", "HEAD", "/list", http.StatusOK},
+		{"head unknown datasource", "HEAD", "/nothing", http.StatusNotFound},
+		{"unsupported method", "POST", "/people", http.StatusMethodNotAllowed},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			testMux.ServeHTTP(rr, httptest.NewRequest(tc.method, tc.requestURI, nil))
+			if rr.Code != tc.wantStatus {
+				t.Errorf("failed got %v wanted %v", rr.Code, tc.wantStatus)
+			}
+		})
+	}
+
+	// a datasource answers HEAD with the headers of GET and no body
+	rr := httptest.NewRecorder()
+	testMux.ServeHTTP(rr, httptest.NewRequest("HEAD", "/people", nil))
+	if rr.Header().Get("ETag") == "" || rr.Body.Len() != 0 {
+		t.Errorf("failed got ETag %q and %d byte body wanted ETag and no body", rr.Header().Get("ETag"), rr.Body.Len())
+	}
+}
+
 func TestDatasourceGetAll(t *testing.T) {
 	testCases := []struct {
 		name          string
@@ -174,14 +274,14 @@ func TestDatasourceGetAll(t *testing.T) {
 			"GET",
 			"/servers",
 			http.StatusNotFound,
-			"404pagenotfound",
+			`{"type":"urn:dujour:problem:datasource_not_found","title":"NotFound","status":404,"detail":"Datasource'servers'doesnotexist","instance":"/servers","code":"datasource_not_found"}`,
 		},
 		{
 			"request for /People endpoint should be 404 Not Found (we support lowercase routes by design)",
 			"GET",
 			"/People",
 			http.StatusNotFound,
-			"404pagenotfound",
+			`{"type":"urn:dujour:problem:not_found","title":"NotFound","status":404,"detail":"Noroutematches'/People'","instance":"/People","code":"not_found"}`,
 		},
 	}
 
@@ -197,6 +297,7 @@ func TestDatasourceGetAll(t *testing.T) {
 
 			rr := httptest.NewRecorder()
 			testMux := mux.NewRouter()
+			testMux.NotFoundHandler = problem.NotFoundHandler()
 			testMux.HandleFunc("/{datasource:[a-z0-9=\\-\\/]+}", app.DatasourceGetAll).Methods("GET")
 			testMux.ServeHTTP(rr, req)
 
@@ -237,7 +338,21 @@ func TestDatasourceGetByID(t *testing.T) {
 			"GET",
 			"/people/10",
 			http.StatusNotFound,
-			"404pagenotfound",
+			`{"type":"urn:dujour:problem:record_not_found","title":"NotFound","status":404,"detail":"Datasource'people'hasnorecordwithid'10'","instance":"/people/10","code":"record_not_found"}`,
+		},
+		{
+			"request for /cities/12 endpoint with a numeric id from a JSON file should be 200 OK",
+			"GET",
+			"/cities/12",
+			http.StatusOK,
+			"{\"id\":12,\"name\":\"London\"}",
+		},
+		{
+			"request for /servers/1 endpoint should be 404 Not Found",
+			"GET",
+			"/servers/1",
+			http.StatusNotFound,
+			`{"type":"urn:dujour:problem:datasource_not_found","title":"NotFound","status":404,"detail":"Datasource'servers'doesnotexist","instance":"/servers/1","code":"datasource_not_found"}`,
 		},
 	}
 
@@ -246,6 +361,13 @@ func TestDatasourceGetByID(t *testing.T) {
 
 			app := createTestAppContext()
 
+			// numbers are decoded from a JSON file as float64
+			cities, err := file.Parse(file.InitDatasource("data/cities.json"), []byte(`[{"id": 12, "name": "London"}]`))
+			if err != nil {
+				t.Fatal(err)
+			}
+			app.Store.Put(cities)
+
 			req, err := http.NewRequest(tc.requestMethod, tc.requestURI, nil)
 			if err != nil {
 				t.Fatal(err)
@@ -272,3 +394,610 @@ func TestDatasourceGetByID(t *testing.T) {
 		})
 	}
 }
+
+func TestDatasourceMasking(t *testing.T) {
+	testCases := []struct {
+		name       string
+		requestURI string
+		apiKey     string
+		wantBody   string
+	}{
+		{
+			"request for /people should have masking applied",
+			"/people",
+			"",
+			"[{\"id\":\"1\",\"name\":\"**st\"},{\"id\":\"2\",\"name\":\"***t2\"}]",
+		},
+		{
+			"request for /people/1 should have masking applied",
+			"/people/1",
+			"",
+			"{\"id\":\"1\",\"name\":\"**st\"}",
+		},
+		{
+			"request for /people with an unknown key should have masking applied",
+			"/people",
+			"not-a-key",
+			"[{\"id\":\"1\",\"name\":\"**st\"},{\"id\":\"2\",\"name\":\"***t2\"}]",
+		},
+		{
+			"request for /people with a privileged key should not have masking applied",
+			"/people",
+			"secret-key",
+			"[{\"age\":\"100\",\"id\":\"1\",\"name\":\"Test\"},{\"age\":\"25\",\"id\":\"2\",\"name\":\"Test2\"}]",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+
+			app := createTestAppContext()
+			app.Config = &config.Config{
+				PrivilegedKeys: []string{"secret-key"},
+				Datasources: map[string]config.Datasource{
+					"people": {
+						Mask: []mask.Rule{
+							{Field: "age", Action: mask.ACTION_DROP},
+							{Field: "name", Action: mask.ACTION_PARTIAL, Keep: 2},
+						},
+					},
+				},
+			}
+
+			req, err := http.NewRequest("GET", tc.requestURI, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tc.apiKey != "" {
+				req.Header.Set(internal.API_KEY_HEADER, tc.apiKey)
+			}
+
+			rr := httptest.NewRecorder()
+			testMux := mux.NewRouter()
+			testMux.HandleFunc("/{datasource:[a-z0-9=\\-\\/]+}/{id:[a-zA-Z0-9=\\-\\/]+}", app.DatasourceGetByID).Methods("GET")
+			testMux.HandleFunc("/{datasource:[a-z0-9=\\-\\/]+}", app.DatasourceGetAll).Methods("GET")
+			testMux.ServeHTTP(rr, req)
+
+			if status := rr.Code; status != http.StatusOK {
+				t.Errorf("handler returned wrong status code: got %v want %v",
+					status, http.StatusOK)
+			}
+
+			gotBody := strings.ReplaceAll(rr.Body.String(), "\n", "")
+			gotBody = strings.ReplaceAll(gotBody, " ", "")
+			if gotBody != tc.wantBody {
+				t.Errorf("handler returned unexpected body: got %v want %v",
+					gotBody, tc.wantBody)
+			}
+		})
+	}
+}
+
+func TestPrivacyHeaders(t *testing.T) {
+	testCases := []struct {
+		name             string
+		requestURI       string
+		apiKey           string
+		wantVary         bool
+		wantCacheControl string
+	}{
+		{"masked datasource varies on the key", "/people", "", true, ""},
+		{"masked record varies on the key", "/people/1", "", true, ""},
+		{"masked schema varies on the key", "/people/_schema", "", true, ""},
+		{"privileged datasource is private", "/people", "secret-key", true, "private"},
+		{"privileged record is private", "/people/1", "secret-key", true, "private"},
+		{"privileged schema is private", "/people/_schema", "secret-key", true, "private"},
+		{"unmasked datasource does not vary on the key", "/people2", "", false, ""},
+		{"privileged unmasked datasource is private", "/people2", "secret-key", false, "private"},
+		{"unknown key is not private", "/people", "not-a-key", true, ""},
+	}
+
+	app := createTestAppContext()
+	app.Config = &config.Config{
+		PrivilegedKeys: []string{"secret-key"},
+		Datasources: map[string]config.Datasource{
+			"people": {Mask: []mask.Rule{{Field: "age", Action: mask.ACTION_DROP}}},
+		},
+	}
+	testMux := mux.NewRouter()
+	testMux.HandleFunc("/{datasource:[a-z0-9=\\-\\/]+}/_schema", app.DatasourceSchema).Methods("GET")
+	testMux.HandleFunc("/{datasource:[a-z0-9=\\-\\/]+}/{id:[a-zA-Z0-9=\\-\\/]+}", app.DatasourceGetByID).Methods("GET")
+	testMux.HandleFunc("/{datasource:[a-z0-9=\\-\\/]+}", app.DatasourceGetAll).Methods("GET")
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", tc.requestURI, nil)
+			if tc.apiKey != "" {
+				req.Header.Set(internal.API_KEY_HEADER, tc.apiKey)
+			}
+			rr := httptest.NewRecorder()
+			testMux.ServeHTTP(rr, req)
+
+			if rr.Code != http.StatusOK {
+				t.Fatalf("failed got status %v wanted %v", rr.Code, http.StatusOK)
+			}
+			gotVary := false
+			for _, v := range rr.Header().Values("Vary") {
+				if v == internal.API_KEY_HEADER {
+					gotVary = true
+				}
+			}
+			if gotVary != tc.wantVary {
+				t.Errorf("failed got Vary %v wanted %s %v", rr.Header().Values("Vary"), internal.API_KEY_HEADER, tc.wantVary)
+			}
+			if got := rr.Header().Get("Cache-Control"); got != tc.wantCacheControl {
+				t.Errorf("failed got Cache-Control %q wanted %q", got, tc.wantCacheControl)
+			}
+		})
+	}
+}
+
+func TestReadiness(t *testing.T) {
+	testCases := []struct {
+		name       string
+		watching   bool
+		wantStatus int
+	}{
+		{"watcher not running should be 503 Service Unavailable", false, http.StatusServiceUnavailable},
+		{"watcher running should be 200 OK", true, http.StatusOK},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			app := createTestAppContext()
+			app.SetWatching(tc.watching)
+
+			req, err := http.NewRequest("GET", "/readyz", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			rr := httptest.NewRecorder()
+			http.HandlerFunc(app.Readiness).ServeHTTP(rr, req)
+
+			if status := rr.Code; status != tc.wantStatus {
+				t.Errorf("handler returned wrong status code: got %v want %v",
+					status, tc.wantStatus)
+			}
+		})
+	}
+}
+
+func TestStatus(t *testing.T) {
+	app := createTestAppContext()
+	app.SetWatching(true)
+	app.Store.Put(internal.Datasource{
+		FileName:     "data/broken.json",
+		FileType:     internal.TYPE_JSON,
+		EndpointName: "broken",
+		LastError:    "unexpected end of JSON input",
+	})
+
+	req, err := http.NewRequest("GET", "/status", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(app.Status).ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusOK)
+	}
+
+	got := status{}
+	if err := json.Unmarshal(rr.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+
+	want := status{
+		Ready: true,
+		Datasources: []statusDS{
+			{Endpoint: "broken", Source: "data/broken.json", Type: "json", LastError: "unexpected end of JSON input"},
+			{Endpoint: "people", Source: "data/people.csv", Type: "csv", Records: 2},
+			{Endpoint: "people2", Source: "data/people2.json", Type: "json", Records: 2},
+			{Endpoint: "people3", Source: "data/people3.json", Type: "json", Records: 2},
+		},
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("handler returned unexpected body: got %v want %v", got, want)
+	}
+}
+
+func TestUnavailableDatasourceNotServed(t *testing.T) {
+	app := createTestAppContext()
+	app.Store.Put(internal.Datasource{
+		FileName:     "data/broken.json",
+		FileType:     internal.TYPE_JSON,
+		EndpointName: "broken",
+		LastError:    "unexpected end of JSON input",
+	})
+
+	req, err := http.NewRequest("GET", "/broken", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	testMux := mux.NewRouter()
+	testMux.HandleFunc("/{datasource:[a-z0-9=\\-\\/]+}", app.DatasourceGetAll).Methods("GET")
+	testMux.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusNotFound {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusNotFound)
+	}
+}
+
+func TestConditionalGet(t *testing.T) {
+	app := createTestAppContext()
+	modTime := time.Date(2022, 3, 1, 12, 0, 0, 0, time.UTC)
+	ds, _ := app.Store.Get("data/people.csv")
+	ds.ModTime = modTime
+	app.Store.Put(ds)
+	app.Config = &config.Config{
+		PrivilegedKeys: []string{"secret-key"},
+		Datasources: map[string]config.Datasource{
+			"people": {Mask: []mask.Rule{{Field: "age", Action: mask.ACTION_DROP}}},
+		},
+	}
+
+	testMux := mux.NewRouter()
+	testMux.HandleFunc("/{datasource:[a-z0-9=\\-\\/]+}/{id:[a-zA-Z0-9=\\-\\/]+}", app.DatasourceGetByID).Methods("GET")
+	testMux.HandleFunc("/{datasource:[a-z0-9=\\-\\/]+}", app.DatasourceGetAll).Methods("GET")
+
+	get := func(uri string, headers map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", uri, nil)
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		rr := httptest.NewRecorder()
+		testMux.ServeHTTP(rr, req)
+		return rr
+	}
+
+	all := get("/people", nil)
+	record := get("/people/1", nil)
+	if all.Header().Get("ETag") == "" || all.Header().Get("ETag") == record.Header().Get("ETag") {
+		t.Fatalf("failed got ETags %q and %q", all.Header().Get("ETag"), record.Header().Get("ETag"))
+	}
+	if got := all.Header().Get("Last-Modified"); got != modTime.Format(http.TimeFormat) {
+		t.Errorf("failed got Last-Modified %q wanted %q", got, modTime.Format(http.TimeFormat))
+	}
+
+	testCases := []struct {
+		name       string
+		requestURI string
+		headers    map[string]string
+		wantStatus int
+	}{
+		{"matching ETag", "/people", map[string]string{"If-None-Match": all.Header().Get("ETag")}, http.StatusNotModified},
+		{"matching record ETag", "/people/1", map[string]string{"If-None-Match": record.Header().Get("ETag")}, http.StatusNotModified},
+		{"stale ETag", "/people", map[string]string{"If-None-Match": `"stale"`}, http.StatusOK},
+		{"record ETag for list", "/people", map[string]string{"If-None-Match": record.Header().Get("ETag")}, http.StatusOK},
+		{"not modified since", "/people", map[string]string{"If-Modified-Since": modTime.Format(http.TimeFormat)}, http.StatusNotModified},
+		{"modified since", "/people", map[string]string{"If-Modified-Since": modTime.Add(-time.Hour).Format(http.TimeFormat)}, http.StatusOK},
+		{"privileged response has its own ETag", "/people", map[string]string{"If-None-Match": all.Header().Get("ETag"), internal.API_KEY_HEADER: "secret-key"}, http.StatusOK},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rr := get(tc.requestURI, tc.headers)
+			if rr.Code != tc.wantStatus {
+				t.Errorf("failed got %v wanted %v", rr.Code, tc.wantStatus)
+			}
+			if tc.wantStatus == http.StatusNotModified && rr.Body.Len() != 0 {
+				t.Errorf("failed got body %q for 304", rr.Body.String())
+			}
+		})
+	}
+}
+
+func TestDatasourceGetAllCache(t *testing.T) {
+	app := createTestAppContext()
+	app.Prime()
+
+	testMux := mux.NewRouter()
+	testMux.HandleFunc("/{datasource:[a-z0-9=\\-\\/]+}", app.DatasourceGetAll).Methods("GET")
+
+	get := func() string {
+		rr := httptest.NewRecorder()
+		testMux.ServeHTTP(rr, httptest.NewRequest("GET", "/people", nil))
+		return rr.Body.String()
+	}
+
+	before := get()
+
+	// replacing the data without a new load time serves the cached response
+	ds, _ := app.Store.Get("data/people.csv")
+	ds.Data = []map[string]string{{"id": "3", "name": "Reloaded"}}
+	app.Store.Put(ds)
+	if got := get(); got != before {
+		t.Errorf("failed got %s wanted cached %s", got, before)
+	}
+
+	// a reload invalidates the cached response
+	ds.LoadedAt = time.Now()
+	app.Store.Put(ds)
+	if got := get(); !strings.Contains(got, "Reloaded") {
+		t.Errorf("failed got %s wanted reloaded data", got)
+	}
+
+	// removed datasources are dropped from the cache
+	app.Store.Delete("data/people.csv")
+	app.Prime()
+	for k := range app.cache {
+		if k.fileName == "data/people.csv" {
+			t.Errorf("failed removed datasource still cached")
+		}
+	}
+}
+
+func TestProblemResponses(t *testing.T) {
+	app := createTestAppContext()
+	app.Store.Put(internal.Datasource{
+		FileName:     "data/odd.json",
+		FileType:     internal.TYPE_JSON,
+		EndpointName: "odd",
+		Data:         []string{"not", "records"},
+	})
+
+	testMux := mux.NewRouter()
+	testMux.NotFoundHandler = problem.NotFoundHandler()
+	testMux.MethodNotAllowedHandler = problem.MethodNotAllowedHandler()
+	testMux.HandleFunc("/ca.pem", app.CACertificate).Methods("GET")
+	testMux.HandleFunc("/{datasource:[a-z0-9=\\-\\/]+}/{id:[a-zA-Z0-9=\\-\\/]+}", app.DatasourceGetByID).Methods("GET")
+	testMux.HandleFunc("/{datasource:[a-z0-9=\\-\\/]+}", app.DatasourceGetAll).Methods("GET")
+
+	testCases := []struct {
+		name       string
+		method     string
+		requestURI string
+		wantStatus int
+		wantCode   string
+	}{
+		{"unknown datasource", "GET", "/servers", http.StatusNotFound, problem.CODE_DATASOURCE_NOT_FOUND},
+		{"unknown id", "GET", "/people/10", http.StatusNotFound, problem.CODE_RECORD_NOT_FOUND},
+		{"malformed query", "GET", "/people?name=%zz", http.StatusBadRequest, problem.CODE_MALFORMED_QUERY},
+		{"unsupported data type", "GET", "/odd/1", http.StatusInternalServerError, problem.CODE_UNSUPPORTED_DATA_TYPE},
+		{"ca not enabled", "GET", "/ca.pem", http.StatusNotFound, problem.CODE_CA_NOT_ENABLED},
+		{"no matching route", "GET", "/People", http.StatusNotFound, problem.CODE_NOT_FOUND},
+		{"method not allowed", "DELETE", "/people", http.StatusMethodNotAllowed, problem.CODE_METHOD_NOT_ALLOWED},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			testMux.ServeHTTP(rr, httptest.NewRequest(tc.method, tc.requestURI, nil))
+
+			if rr.Code != tc.wantStatus {
+				t.Errorf("failed got %v wanted %v", rr.Code, tc.wantStatus)
+			}
+			if got := rr.Header().Get("Content-Type"); got != problem.CONTENT_TYPE {
+				t.Errorf("failed got Content-Type %q wanted %q", got, problem.CONTENT_TYPE)
+			}
+
+			got := problem.Problem{}
+			if err := json.Unmarshal(rr.Body.Bytes(), &got); err != nil {
+				t.Fatalf("failed to parse problem: %v", err)
+			}
+			if got.Code != tc.wantCode || got.Status != tc.wantStatus || got.Detail == "" {
+				t.Errorf("failed got %+v wanted code %s", got, tc.wantCode)
+			}
+		})
+	}
+}
+
+func TestOpenAPI(t *testing.T) {
+	app := createTestAppContext()
+	app.Version = "v1.2.3"
+
+	testMux := mux.NewRouter()
+	testMux.HandleFunc("/openapi.json", app.OpenAPI).Methods("GET")
+	testMux.HandleFunc("/docs", app.Docs).Methods("GET")
+
+	get := func(uri string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		testMux.ServeHTTP(rr, httptest.NewRequest("GET", uri, nil))
+		return rr
+	}
+
+	rr := get("/openapi.json")
+	doc := struct {
+		Info  map[string]string          `json:"info"`
+		Paths map[string]json.RawMessage `json:"paths"`
+	}{}
+	if err := json.Unmarshal(rr.Body.Bytes(), &doc); err != nil {
+		t.Fatalf("failed to parse document: %v", err)
+	}
+	if doc.Info["version"] != "v1.2.3" {
+		t.Errorf("failed got version %q", doc.Info["version"])
+	}
+	for _, path := range []string{"/people", "/people/{id}", "/people2", "/people2/{id}", "/people3", "/people3/{id}"} {
+		if _, ok := doc.Paths[path]; !ok {
+			t.Errorf("failed wanted path %s got %d paths", path, len(doc.Paths))
+		}
+	}
+
+	// the document is regenerated when the datasources change
+	app.Store.Delete("data/people2.json")
+	app.Prime()
+	if strings.Contains(get("/openapi.json").Body.String(), "/people2") {
+		t.Errorf("failed removed datasource still documented")
+	}
+
+	// fields dropped by masking are not documented
+	app.Config = &config.Config{Datasources: map[string]config.Datasource{
+		"people": {Mask: []mask.Rule{{Field: "age", Action: mask.ACTION_DROP}}},
+	}}
+	app.Prime()
+	masked := struct {
+		Components struct {
+			Schemas map[string]struct {
+				Properties map[string]json.RawMessage `json:"properties"`
+			} `json:"schemas"`
+		} `json:"components"`
+	}{}
+	if err := json.Unmarshal(get("/openapi.json").Body.Bytes(), &masked); err != nil {
+		t.Fatalf("failed to parse document: %v", err)
+	}
+	record := masked.Components.Schemas["PeopleRecord"].Properties
+	if _, ok := record["age"]; ok || record["name"] == nil {
+		t.Errorf("failed got properties %v wanted name without dropped age", record)
+	}
+
+	if rr := get("/docs"); rr.Code != http.StatusNotFound {
+		t.Errorf("failed got %v wanted %v for disabled viewer", rr.Code, http.StatusNotFound)
+	}
+	app.Config = &config.Config{OpenAPI: config.OpenAPI{Viewer: true}}
+	if rr := get("/docs"); rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), "openapi.json") {
+		t.Errorf("failed got %v wanted viewer", rr.Code)
+	}
+}
+
+func TestDatasourceSchema(t *testing.T) {
+	app := createTestAppContext()
+
+	testMux := mux.NewRouter()
+	testMux.HandleFunc("/{datasource}/_schema", app.DatasourceSchema).Methods("GET")
+
+	testCases := []struct {
+		name       string
+		uri        string
+		wantStatus int
+		wantTypes  map[string]string
+	}{
+		{"csv fields are strings", "/people/_schema", http.StatusOK, map[string]string{"id": "string", "age": "string"}},
+		{"json fields keep their types", "/people2/_schema", http.StatusOK, map[string]string{"id": "integer", "name": "string"}},
+		{"unknown datasource", "/unknown/_schema", http.StatusNotFound, nil},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			testMux.ServeHTTP(rr, httptest.NewRequest("GET", tc.uri, nil))
+			if rr.Code != tc.wantStatus {
+				t.Fatalf("failed got %v wanted %v", rr.Code, tc.wantStatus)
+			}
+			if tc.wantTypes == nil {
+				return
+			}
+
+			doc := struct {
+				Schema     string                       `json:"$schema"`
+				Properties map[string]map[string]string `json:"properties"`
+			}{}
+			if err := json.Unmarshal(rr.Body.Bytes(), &doc); err != nil {
+				t.Fatalf("failed to parse schema: %v", err)
+			}
+			if doc.Schema == "" {
+				t.Errorf("failed schema has no dialect")
+			}
+			for field, want := range tc.wantTypes {
+				if got := doc.Properties[field]["type"]; got != want {
+					t.Errorf("failed got %v wanted %v for %s", got, want, field)
+				}
+			}
+		})
+	}
+
+	get := func() string {
+		rr := httptest.NewRecorder()
+		testMux.ServeHTTP(rr, httptest.NewRequest("GET", "/people/_schema", nil))
+		return rr.Body.String()
+	}
+	before := get()
+
+	// replacing the data without a new load time serves the cached schema
+	ds, _ := app.Store.Get("data/people.csv")
+	ds.Data = []map[string]string{{"id": "3", "colour": "red"}}
+	app.Store.Put(ds)
+	if got := get(); got != before {
+		t.Errorf("failed got %s wanted cached %s", got, before)
+	}
+
+	// a reload invalidates the cached schema
+	ds.LoadedAt = time.Now()
+	app.Store.Put(ds)
+	if got := get(); !strings.Contains(got, "colour") {
+		t.Errorf("failed got %s wanted schema of reloaded data", got)
+	}
+}
+
+func TestReloadSharedStore(t *testing.T) {
+	testLogger := &koan.Logger{}
+	mr := miniredis.RunT(t)
+	newApp := func() *App {
+		client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+		st, err := store.NewRedis(client, "", "data", testLogger)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() {
+			_ = st.Close()
+			_ = client.Close()
+		})
+		return &App{Logger: testLogger, Store: st}
+	}
+
+	now := time.Now()
+	datasource := func(fileName, hash string, modTime time.Time) internal.Datasource {
+		return internal.Datasource{
+			FileName:     fileName,
+			FileType:     internal.TYPE_JSON,
+			EndpointName: strings.TrimSuffix(strings.TrimPrefix(fileName, "data/"), ".json"),
+			Hash:         hash,
+			ModTime:      modTime,
+			LoadedAt:     modTime,
+			Data:         []map[string]interface{}{{"id": hash}},
+		}
+	}
+
+	// this instance has the newer files, including one the other instance does not have
+	a := newApp()
+	a.Load(map[string]internal.Datasource{
+		"data/people.json": datasource("data/people.json", "new", now),
+		"data/places.json": datasource("data/places.json", "new", now),
+	})
+
+	// the other instance has an older file and a file which it then removes
+	b := newApp()
+	b.Load(map[string]internal.Datasource{
+		"data/people.json":  datasource("data/people.json", "old", now.Add(-time.Hour)),
+		"data/removed.json": datasource("data/removed.json", "old", now.Add(-time.Hour)),
+	})
+	b.Reload(&config.Config{}, map[string]internal.Datasource{
+		"data/people.json": datasource("data/people.json", "old", now.Add(-time.Hour)),
+	})
+
+	testCases := []struct {
+		name     string
+		fileName string
+		wantHash string
+	}{
+		{"datasource from a newer file is kept", "data/people.json", "new"},
+		{"datasource missing from the reloaded folder is kept", "data/places.json", "new"},
+		{"datasource whose file was removed is deleted", "data/removed.json", ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// the reloading instance and the instance sharing the store agree
+			for name, app := range map[string]*App{"reloaded": b, "other": a} {
+				deadline := time.Now().Add(2 * time.Second)
+				for {
+					ds, _ := app.Store.Get(tc.fileName)
+					if ds.Hash == tc.wantHash {
+						break
+					}
+					if time.Now().After(deadline) {
+						t.Fatalf("failed got hash %q wanted %q on %s instance", ds.Hash, tc.wantHash, name)
+					}
+					time.Sleep(5 * time.Millisecond)
+				}
+			}
+		})
+	}
+}
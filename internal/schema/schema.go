@@ -0,0 +1,295 @@
+// Package schema infers JSON Schemas describing the records of a datasource
+package schema
+
+import (
+	"encoding/json"
+	"math"
+	"sort"
+)
+
+const (
+	TYPE_STRING  = "string"
+	TYPE_INTEGER = "integer"
+	TYPE_NUMBER  = "number"
+	TYPE_BOOLEAN = "boolean"
+	TYPE_OBJECT  = "object"
+	TYPE_ARRAY   = "array"
+	TYPE_NULL    = "null"
+
+	CONTENT_TYPE = "application/schema+json"
+
+	// DRAFT is the JSON Schema dialect of documents returned by Document
+	DRAFT = "https://json-schema.org/draft/2020-12/schema"
+
+	// ENUM_MAX_VALUES is the most distinct values a string field can have to be given an enum by Describe
+	ENUM_MAX_VALUES = 10
+)
+
+// Schema is the subset of JSON Schema which can be inferred from data. A field holding values of
+// different types across records has more than one type
+type Schema struct {
+	Types      []string
+	Properties map[string]*Schema
+	Required   []string
+	Items      *Schema
+	Enum       []string
+
+	// the distinct string values seen, until there are too many to be an enum, and how many values
+	// were seen in total
+	values   map[string]bool
+	seen     int
+	overflow bool
+}
+
+// MarshalJSON writes a single type as a string and several as an array
+func (s *Schema) MarshalJSON() ([]byte, error) {
+	out := map[string]interface{}{}
+	switch len(s.Types) {
+	case 0:
+	case 1:
+		out["type"] = s.Types[0]
+	default:
+		out["type"] = s.Types
+	}
+	if s.Properties != nil {
+		out["properties"] = s.Properties
+	}
+	if len(s.Required) > 0 {
+		out["required"] = s.Required
+	}
+	if s.Items != nil {
+		out["items"] = s.Items
+	}
+	if len(s.Enum) > 0 {
+		out["enum"] = s.Enum
+	}
+	return json.Marshal(out)
+}
+
+// Describe returns the schema of a single record of a datasource, as a starting point for a schema to
+// validate it. A datasource without records is described as a whole. String fields with only a few
+// distinct values, each seen more than once, are given an enum of those values
+func Describe(data interface{}) *Schema {
+	collection := Infer(data)
+	s := Record(collection)
+	if s == nil {
+		s = collection
+	}
+	s.enums()
+	return s
+}
+
+// Document returns the schema as an indented standalone JSON Schema document with a title
+func Document(s *Schema, title string) ([]byte, error) {
+	body, err := json.Marshal(s)
+	if err != nil {
+		return nil, err
+	}
+	out := map[string]interface{}{}
+	if err := json.Unmarshal(body, &out); err != nil {
+		return nil, err
+	}
+	out["$schema"] = DRAFT
+	out["title"] = title
+	return json.MarshalIndent(out, "", "  ")
+}
+
+// Infer returns the schema of a value loaded from a CSV or JSON datasource. The items of an array are
+// merged into one schema, a property is required when every object in the array has it
+func Infer(v interface{}) *Schema {
+	switch t := v.(type) {
+	case nil:
+		return &Schema{Types: []string{TYPE_NULL}}
+	case string:
+		return str(t)
+	case bool:
+		return &Schema{Types: []string{TYPE_BOOLEAN}}
+	case int, int64:
+		return &Schema{Types: []string{TYPE_INTEGER}}
+	case float64:
+		if t == math.Trunc(t) && !math.IsInf(t, 0) {
+			return &Schema{Types: []string{TYPE_INTEGER}}
+		}
+		return &Schema{Types: []string{TYPE_NUMBER}}
+	case map[string]string:
+		s := &Schema{Types: []string{TYPE_OBJECT}, Properties: map[string]*Schema{}}
+		for k, v := range t {
+			s.Properties[k] = str(v)
+		}
+		s.Required = keys(s.Properties)
+		return s
+	case map[string]interface{}:
+		s := &Schema{Types: []string{TYPE_OBJECT}, Properties: map[string]*Schema{}}
+		for k, v := range t {
+			s.Properties[k] = Infer(v)
+		}
+		s.Required = keys(s.Properties)
+		return s
+	case []map[string]string:
+		items := make([]interface{}, 0, len(t))
+		for _, v := range t {
+			items = append(items, v)
+		}
+		return array(items)
+	case []map[string]interface{}:
+		items := make([]interface{}, 0, len(t))
+		for _, v := range t {
+			items = append(items, v)
+		}
+		return array(items)
+	case []interface{}:
+		return array(t)
+	default:
+		// an unknown type, accept anything
+		return &Schema{}
+	}
+}
+
+// Record returns the schema of the records which can be requested by id. For an array datasource this
+// is the item schema, for an object it is the merged item schema of its top level arrays of objects. It
+// returns nil when the datasource has no records
+func Record(collection *Schema) *Schema {
+	if collection.Items != nil && collection.Items.isObject() {
+		return collection.Items
+	}
+
+	var record *Schema
+	for _, k := range keys(collection.Properties) {
+		if items := collection.Properties[k].Items; items != nil && items.isObject() {
+			record = Merge(record, items)
+		}
+	}
+	return record
+}
+
+// Merge combines two schemas so the result accepts the values of both, either may be nil
+func Merge(a, b *Schema) *Schema {
+	if a == nil {
+		return b
+	}
+	if b == nil {
+		return a
+	}
+
+	out := &Schema{Types: union(a.Types, b.Types)}
+
+	if a.Properties != nil || b.Properties != nil {
+		out.Properties = map[string]*Schema{}
+		for k, v := range a.Properties {
+			out.Properties[k] = v
+		}
+		for k, v := range b.Properties {
+			out.Properties[k] = Merge(out.Properties[k], v)
+		}
+		// an object only present in one schema keeps its required properties
+		switch {
+		case a.Properties == nil:
+			out.Required = b.Required
+		case b.Properties == nil:
+			out.Required = a.Required
+		default:
+			out.Required = intersect(a.Required, b.Required)
+		}
+	}
+
+	if a.Items != nil || b.Items != nil {
+		out.Items = Merge(a.Items, b.Items)
+	}
+
+	out.seen = a.seen + b.seen
+	out.overflow = a.overflow || b.overflow
+	if !out.overflow && (a.values != nil || b.values != nil) {
+		out.values = map[string]bool{}
+		for _, m := range []map[string]bool{a.values, b.values} {
+			for k := range m {
+				out.values[k] = true
+			}
+		}
+		if len(out.values) > ENUM_MAX_VALUES {
+			out.values = nil
+			out.overflow = true
+		}
+	}
+
+	return out
+}
+
+func str(v string) *Schema {
+	return &Schema{Types: []string{TYPE_STRING}, values: map[string]bool{v: true}, seen: 1}
+}
+
+// enums sets the enum of string fields whose values repeat
+func (s *Schema) enums() {
+	// on average each value must be seen at least twice, so fields such as names and ids are left out
+	if len(s.Types) == 1 && s.Types[0] == TYPE_STRING && !s.overflow && len(s.values) > 0 && s.seen >= 2*len(s.values) {
+		s.Enum = make([]string, 0, len(s.values))
+		for k := range s.values {
+			s.Enum = append(s.Enum, k)
+		}
+		sort.Strings(s.Enum)
+	}
+	for _, v := range s.Properties {
+		v.enums()
+	}
+	if s.Items != nil {
+		s.Items.enums()
+	}
+}
+
+func array(items []interface{}) *Schema {
+	s := &Schema{Types: []string{TYPE_ARRAY}}
+	for _, v := range items {
+		s.Items = Merge(s.Items, Infer(v))
+	}
+	return s
+}
+
+func (s *Schema) isObject() bool {
+	for _, v := range s.Types {
+		if v == TYPE_OBJECT {
+			return true
+		}
+	}
+	return false
+}
+
+func keys(m map[string]*Schema) []string {
+	out := make([]string, 0, len(m))
+	for k := range m {
+		out = append(out, k)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// union returns the types of both in sorted order, integer is dropped when number is present
+// since every integer is a number
+func union(a, b []string) []string {
+	seen := map[string]bool{}
+	for _, v := range append(append([]string{}, a...), b...) {
+		seen[v] = true
+	}
+	if seen[TYPE_NUMBER] {
+		delete(seen, TYPE_INTEGER)
+	}
+	out := make([]string, 0, len(seen))
+	for k := range seen {
+		out = append(out, k)
+	}
+	sort.Strings(out)
+	return out
+}
+
+func intersect(a, b []string) []string {
+	inB := map[string]bool{}
+	for _, v := range b {
+		inB[v] = true
+	}
+	out := []string{}
+	for _, v := range a {
+		if inB[v] {
+			out = append(out, v)
+		}
+	}
+	return out
+}
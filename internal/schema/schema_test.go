@@ -0,0 +1,124 @@
+package schema_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/spoonboy-io/dujour/internal/schema"
+)
+
+func TestInfer(t *testing.T) {
+	testCases := []struct {
+		name string
+		data interface{}
+		want string
+	}{
+		{
+			"csv records are strings",
+			[]map[string]string{{"id": "1", "name": "Test"}},
+			`{"items":{"properties":{"id":{"type":"string"},"name":{"type":"string"}},"required":["id","name"],"type":"object"},"type":"array"}`,
+		},
+		{
+			"optional and mixed type fields",
+			[]map[string]interface{}{
+				{"id": float64(1), "score": float64(2), "tag": "a"},
+				{"id": float64(2), "score": 2.5},
+				{"id": "3", "score": nil},
+			},
+			`{"items":{"properties":{"id":{"type":["integer","string"]},"score":{"type":["null","number"]},"tag":{"type":"string"}},"required":["id","score"],"type":"object"},"type":"array"}`,
+		},
+		{
+			"nested arrays",
+			map[string]interface{}{"result": []interface{}{map[string]interface{}{"id": "a", "tags": []interface{}{"x"}}}},
+			`{"properties":{"result":{"items":{"properties":{"id":{"type":"string"},"tags":{"items":{"type":"string"},"type":"array"}},"required":["id","tags"],"type":"object"},"type":"array"}},"required":["result"],"type":"object"}`,
+		},
+		{"empty array", []interface{}{}, `{"type":"array"}`},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := json.Marshal(schema.Infer(tc.data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tc.want {
+				t.Errorf("failed got %s wanted %s", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestRecord(t *testing.T) {
+	testCases := []struct {
+		name    string
+		data    interface{}
+		wantNil bool
+	}{
+		{"array of records", []map[string]string{{"id": "1"}}, false},
+		{"object with an array of records", map[string]interface{}{"result": []interface{}{map[string]interface{}{"id": "a"}}}, false},
+		{"object without records", map[string]interface{}{"name": "settings"}, true},
+		{"array of strings", []interface{}{"a", "b"}, true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := schema.Record(schema.Infer(tc.data)); (got == nil) != tc.wantNil {
+				t.Errorf("failed got %v wanted nil %v", got, tc.wantNil)
+			}
+		})
+	}
+}
+
+func TestDescribe(t *testing.T) {
+	testCases := []struct {
+		name string
+		data interface{}
+		want string
+	}{
+		{
+			"repeated values are enum candidates",
+			[]map[string]string{
+				{"id": "1", "role": "admin"},
+				{"id": "2", "role": "user"},
+				{"id": "3", "role": "user"},
+				{"id": "4", "role": "admin"},
+			},
+			`{"properties":{"id":{"type":"string"},"role":{"enum":["admin","user"],"type":"string"}},"required":["id","role"],"type":"object"}`,
+		},
+		{
+			"records of an object",
+			map[string]interface{}{"result": []interface{}{
+				map[string]interface{}{"id": float64(1), "note": nil},
+				map[string]interface{}{"id": float64(2), "note": "a"},
+			}},
+			`{"properties":{"id":{"type":"integer"},"note":{"type":["null","string"]}},"required":["id","note"],"type":"object"}`,
+		},
+		{
+			"object without records",
+			map[string]interface{}{"name": "settings"},
+			`{"properties":{"name":{"type":"string"}},"required":["name"],"type":"object"}`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := json.Marshal(schema.Describe(tc.data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tc.want {
+				t.Errorf("failed got %s wanted %s", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestDescribeTooManyValues(t *testing.T) {
+	records := []map[string]string{}
+	for i := 0; i < 2*(schema.ENUM_MAX_VALUES+1); i++ {
+		records = append(records, map[string]string{"code": string(rune('a' + i%(schema.ENUM_MAX_VALUES+1)))})
+	}
+	if got := schema.Describe(records).Properties["code"].Enum; got != nil {
+		t.Errorf("failed got %v wanted no enum", got)
+	}
+}
@@ -0,0 +1,330 @@
+package schema
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// supported lists the JSON Schema keywords the validator understands, documents using any other
+// validation keyword are rejected rather than silently accepting invalid data
+var supported = map[string]bool{
+	"$schema": true, "$id": true, "title": true, "description": true, "default": true, "examples": true, "$comment": true,
+	"type": true, "enum": true, "const": true,
+	"properties": true, "required": true, "additionalProperties": true,
+	"items": true, "minItems": true, "maxItems": true,
+	"minLength": true, "maxLength": true, "pattern": true,
+	"minimum": true, "maximum": true, "exclusiveMinimum": true, "exclusiveMaximum": true,
+}
+
+// Validator checks values against a JSON Schema document. A subset of JSON Schema is supported: type,
+// enum, const, properties, required, additionalProperties, items, minItems, maxItems, minLength,
+// maxLength, pattern, minimum, maximum, exclusiveMinimum and exclusiveMaximum
+type Validator struct {
+	root     map[string]interface{}
+	patterns map[string]*regexp.Regexp
+}
+
+// Compile parses a JSON Schema document and checks it only uses supported keywords
+func Compile(data []byte) (*Validator, error) {
+	root := map[string]interface{}{}
+	if err := json.Unmarshal(data, &root); err != nil {
+		return nil, err
+	}
+
+	v := &Validator{root: root, patterns: map[string]*regexp.Regexp{}}
+	if err := v.check(root, ""); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+func (v *Validator) check(s map[string]interface{}, path string) error {
+	for k, kv := range s {
+		if !supported[k] {
+			return fmt.Errorf("unsupported keyword '%s' at '%s'", k, pointer(path))
+		}
+		switch k {
+		case "pattern":
+			p, ok := kv.(string)
+			if !ok {
+				return fmt.Errorf("pattern at '%s' must be a string", pointer(path))
+			}
+			re, err := regexp.Compile(p)
+			if err != nil {
+				return fmt.Errorf("invalid pattern at '%s'; %v", pointer(path), err)
+			}
+			v.patterns[p] = re
+		case "properties":
+			props, ok := kv.(map[string]interface{})
+			if !ok {
+				return fmt.Errorf("properties at '%s' must be an object", pointer(path))
+			}
+			for name, ps := range props {
+				sub, ok := ps.(map[string]interface{})
+				if !ok {
+					return fmt.Errorf("property '%s' at '%s' must be a schema", name, pointer(path))
+				}
+				if err := v.check(sub, path+"/properties/"+name); err != nil {
+					return err
+				}
+			}
+		case "items", "additionalProperties":
+			if sub, ok := kv.(map[string]interface{}); ok {
+				if err := v.check(sub, path+"/"+k); err != nil {
+					return err
+				}
+			} else if _, ok := kv.(bool); !ok || k == "items" {
+				return fmt.Errorf("%s at '%s' must be a schema", k, pointer(path))
+			}
+		}
+	}
+	return nil
+}
+
+// Validate returns a message for each way value does not match the schema, the messages are prefixed
+// with the path of the field. When coerce is set, strings holding numbers or booleans match those
+// types, for values read from CSV files
+func (v *Validator) Validate(value interface{}, coerce bool) []string {
+	return v.validate(v.root, value, "", coerce)
+}
+
+func (v *Validator) validate(s map[string]interface{}, value interface{}, path string, coerce bool) []string {
+	errs := []string{}
+	fail := func(format string, args ...interface{}) {
+		errs = append(errs, field(path)+fmt.Sprintf(format, args...))
+	}
+
+	if t, ok := s["type"]; ok {
+		types := []string{}
+		switch tv := t.(type) {
+		case string:
+			types = append(types, tv)
+		case []interface{}:
+			for _, x := range tv {
+				if str, ok := x.(string); ok {
+					types = append(types, str)
+				}
+			}
+		}
+		matched := false
+		for _, name := range types {
+			if coerced, ok := matchType(name, value, coerce); ok {
+				value = coerced
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			fail("expected %s got %s", strings.Join(types, " or "), typeOf(value))
+			// the remaining keywords would only repeat the type mismatch
+			return errs
+		}
+	}
+
+	if enum, ok := s["enum"].([]interface{}); ok {
+		found := false
+		for _, e := range enum {
+			if equal(e, value) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			fail("value %s is not one of the allowed values", display(value))
+		}
+	}
+	if c, ok := s["const"]; ok && !equal(c, value) {
+		fail("value %s must be %s", display(value), display(c))
+	}
+
+	switch tv := value.(type) {
+	case string:
+		length := len([]rune(tv))
+		if min, ok := number(s["minLength"]); ok && float64(length) < min {
+			fail("length %d is shorter than %v", length, min)
+		}
+		if max, ok := number(s["maxLength"]); ok && float64(length) > max {
+			fail("length %d is longer than %v", length, max)
+		}
+		if p, ok := s["pattern"].(string); ok && !v.patterns[p].MatchString(tv) {
+			fail("value %s does not match pattern %s", display(tv), p)
+		}
+	case float64:
+		if min, ok := number(s["minimum"]); ok && tv < min {
+			fail("value %v is less than %v", tv, min)
+		}
+		if max, ok := number(s["maximum"]); ok && tv > max {
+			fail("value %v is greater than %v", tv, max)
+		}
+		if min, ok := number(s["exclusiveMinimum"]); ok && tv <= min {
+			fail("value %v must be greater than %v", tv, min)
+		}
+		if max, ok := number(s["exclusiveMaximum"]); ok && tv >= max {
+			fail("value %v must be less than %v", tv, max)
+		}
+	case map[string]interface{}:
+		errs = append(errs, v.validateObject(s, tv, path, coerce)...)
+	case map[string]string:
+		obj := make(map[string]interface{}, len(tv))
+		for k, x := range tv {
+			obj[k] = x
+		}
+		errs = append(errs, v.validateObject(s, obj, path, coerce)...)
+	case []interface{}:
+		if min, ok := number(s["minItems"]); ok && float64(len(tv)) < min {
+			fail("has %d items, fewer than %v", len(tv), min)
+		}
+		if max, ok := number(s["maxItems"]); ok && float64(len(tv)) > max {
+			fail("has %d items, more than %v", len(tv), max)
+		}
+		if items, ok := s["items"].(map[string]interface{}); ok {
+			for i, x := range tv {
+				errs = append(errs, v.validate(items, x, fmt.Sprintf("%s[%d]", path, i), coerce)...)
+			}
+		}
+	}
+
+	return errs
+}
+
+func (v *Validator) validateObject(s map[string]interface{}, obj map[string]interface{}, path string, coerce bool) []string {
+	errs := []string{}
+
+	if required, ok := s["required"].([]interface{}); ok {
+		for _, r := range required {
+			if name, ok := r.(string); ok {
+				if _, present := obj[name]; !present {
+					errs = append(errs, field(join(path, name))+"is required")
+				}
+			}
+		}
+	}
+
+	props, _ := s["properties"].(map[string]interface{})
+	names := make([]string, 0, len(obj))
+	for k := range obj {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if ps, ok := props[name].(map[string]interface{}); ok {
+			errs = append(errs, v.validate(ps, obj[name], join(path, name), coerce)...)
+			continue
+		}
+		switch ap := s["additionalProperties"].(type) {
+		case bool:
+			if !ap {
+				errs = append(errs, field(join(path, name))+"is not allowed")
+			}
+		case map[string]interface{}:
+			errs = append(errs, v.validate(ap, obj[name], join(path, name), coerce)...)
+		}
+	}
+
+	return errs
+}
+
+// matchType reports whether value is of the JSON Schema type, returning the value converted to that
+// type when a CSV string is coerced
+func matchType(name string, value interface{}, coerce bool) (interface{}, bool) {
+	str, isString := value.(string)
+	switch name {
+	case TYPE_STRING:
+		return value, isString
+	case TYPE_BOOLEAN:
+		if _, ok := value.(bool); ok {
+			return value, true
+		}
+		if coerce && isString {
+			if b, err := strconv.ParseBool(str); err == nil {
+				return b, true
+			}
+		}
+	case TYPE_INTEGER, TYPE_NUMBER:
+		f, ok := value.(float64)
+		if i, isInt := value.(int); isInt {
+			f, ok = float64(i), true
+		}
+		if !ok && coerce && isString {
+			if parsed, err := strconv.ParseFloat(str, 64); err == nil {
+				f, ok = parsed, true
+			}
+		}
+		if ok && (name == TYPE_NUMBER || f == math.Trunc(f)) {
+			return f, true
+		}
+	case TYPE_OBJECT:
+		switch value.(type) {
+		case map[string]interface{}, map[string]string:
+			return value, true
+		}
+	case TYPE_ARRAY:
+		_, ok := value.([]interface{})
+		return value, ok
+	case TYPE_NULL:
+		return value, value == nil
+	}
+	return value, false
+}
+
+func typeOf(value interface{}) string {
+	switch value.(type) {
+	case nil:
+		return TYPE_NULL
+	case string:
+		return TYPE_STRING
+	case bool:
+		return TYPE_BOOLEAN
+	case float64, int:
+		return TYPE_NUMBER
+	case []interface{}:
+		return TYPE_ARRAY
+	default:
+		return TYPE_OBJECT
+	}
+}
+
+func equal(a, b interface{}) bool {
+	if i, ok := b.(int); ok {
+		b = float64(i)
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+func number(v interface{}) (float64, bool) {
+	f, ok := v.(float64)
+	return f, ok
+}
+
+func display(v interface{}) string {
+	out, _ := json.Marshal(v)
+	return string(out)
+}
+
+func join(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+func field(path string) string {
+	if path == "" {
+		return ""
+	}
+	return path + ": "
+}
+
+func pointer(path string) string {
+	if path == "" {
+		return "/"
+	}
+	return path
+}
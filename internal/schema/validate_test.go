@@ -0,0 +1,107 @@
+package schema_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/spoonboy-io/dujour/internal/schema"
+)
+
+func TestCompile(t *testing.T) {
+	testCases := []struct {
+		name    string
+		schema  string
+		wantErr bool
+	}{
+		{"supported keywords", `{"type": "object", "properties": {"name": {"type": "string", "pattern": "^[A-Z]"}}}`, false},
+		{"not json", `{"type":`, true},
+		{"unsupported keyword", `{"type": "object", "oneOf": []}`, true},
+		{"unsupported nested keyword", `{"properties": {"name": {"format": "email"}}}`, true},
+		{"invalid pattern", `{"pattern": "("}`, true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := schema.Compile([]byte(tc.schema))
+			if (err != nil) != tc.wantErr {
+				t.Errorf("failed got %v wanted error %v", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	v, err := schema.Compile([]byte(`{
+		"type": "object",
+		"required": ["id", "name"],
+		"additionalProperties": false,
+		"properties": {
+			"id": {"type": "integer", "exclusiveMinimum": 0},
+			"name": {"type": "string", "minLength": 2},
+			"active": {"type": "boolean"},
+			"role": {"enum": ["admin", "user"]},
+			"tags": {"type": "array", "maxItems": 2, "items": {"type": "string"}}
+		}
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		name   string
+		value  interface{}
+		coerce bool
+		want   []string
+	}{
+		{
+			"valid json record",
+			map[string]interface{}{"id": float64(1), "name": "Test", "active": true, "tags": []interface{}{"a"}},
+			false,
+			[]string{},
+		},
+		{
+			"valid csv record",
+			map[string]string{"id": "1", "name": "Test", "active": "true", "role": "admin"},
+			true,
+			[]string{},
+		},
+		{
+			"csv strings without coercion",
+			map[string]string{"id": "1", "name": "Test"},
+			false,
+			[]string{"id: expected integer got string"},
+		},
+		{
+			"missing and unexpected fields",
+			map[string]interface{}{"id": float64(1), "email": "a@b.c"},
+			false,
+			[]string{"name: is required", "email: is not allowed"},
+		},
+		{
+			"constraints",
+			map[string]interface{}{"id": float64(0), "name": "T", "role": "guest", "tags": []interface{}{"a", "b", float64(3)}},
+			false,
+			[]string{
+				"id: value 0 must be greater than 0",
+				"name: length 1 is shorter than 2",
+				`role: value "guest" is not one of the allowed values`,
+				"tags: has 3 items, more than 2",
+				"tags[2]: expected string got number",
+			},
+		},
+		{
+			"not an integer",
+			map[string]interface{}{"id": 1.5, "name": "Test"},
+			false,
+			[]string{"id: expected integer got number"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := v.Validate(tc.value, tc.coerce); !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("failed got %v wanted %v", got, tc.want)
+			}
+		})
+	}
+}
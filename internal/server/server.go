@@ -0,0 +1,140 @@
+// Package server creates the listeners the application serves on, applying the TLS policy
+// and providing the optional HTTP to HTTPS redirect
+package server
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"net/http"
+	"os"
+
+	"github.com/spoonboy-io/dujour/internal"
+	"github.com/spoonboy-io/dujour/internal/config"
+)
+
+// Listener is an HTTP server bound to a single configured address
+type Listener struct {
+	Server  *http.Server
+	Type    string
+	Address string
+}
+
+// New creates a listener for each configured address and the redirecting HTTP listener when configured.
+// HTTPS listeners obtain their certificate from getCertificate
+func New(cfg *config.Config, handler http.Handler, getCertificate func(*tls.ClientHelloInfo) (*tls.Certificate, error)) ([]*Listener, error) {
+	cipherSuites, err := cfg.TLS.CipherSuiteIDs()
+	if err != nil {
+		return nil, err
+	}
+
+	listeners := []*Listener{}
+	for _, v := range cfg.Server.ActiveListeners() {
+		l := &Listener{
+			Type:    v.Type,
+			Address: v.Address,
+			Server:  newServer(v.Address, handler),
+		}
+		if v.Type == config.LISTENER_HTTPS {
+			l.Server.Handler = HSTS(cfg.TLS.HSTS, handler)
+			l.Server.TLSConfig = &tls.Config{
+				MinVersion:     cfg.TLS.Version(),
+				CipherSuites:   cipherSuites,
+				GetCertificate: getCertificate,
+			}
+		}
+		listeners = append(listeners, l)
+	}
+
+	if cfg.Server.RedirectAddress != "" {
+		listeners = append(listeners, &Listener{
+			Type:    config.LISTENER_HTTP,
+			Address: cfg.Server.RedirectAddress,
+			Server:  newServer(cfg.Server.RedirectAddress, Redirect(cfg.Server.HTTPSPort())),
+		})
+	}
+
+	return listeners, nil
+}
+
+func newServer(address string, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:         address,
+		Handler:      handler,
+		ReadTimeout:  internal.SRV_READ_TIMEOUT,
+		WriteTimeout: internal.SRV_WRITE_TIMEOUT,
+	}
+}
+
+// String describes the listener for logging
+func (l *Listener) String() string {
+	switch l.Type {
+	case config.LISTENER_HTTPS:
+		return fmt.Sprintf("HTTPS server on %s", l.Address)
+	case config.LISTENER_UNIX:
+		return fmt.Sprintf("HTTP server on unix socket %s", l.Address)
+	default:
+		return fmt.Sprintf("HTTP server on %s", l.Address)
+	}
+}
+
+// Serve listens on the address and serves requests, it blocks until the server is closed
+func (l *Listener) Serve() error {
+	network := "tcp"
+	if l.Type == config.LISTENER_UNIX {
+		network = "unix"
+		// a socket left behind by a previous run would prevent listening
+		if info, err := os.Stat(l.Address); err == nil && info.Mode()&fs.ModeSocket != 0 {
+			if err := os.Remove(l.Address); err != nil {
+				return fmt.Errorf("Could not remove stale socket '%s'; %v", l.Address, err)
+			}
+		} else if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+
+	ln, err := net.Listen(network, l.Address)
+	if err != nil {
+		return err
+	}
+
+	if l.Type == config.LISTENER_HTTPS {
+		// the certificate is provided by TLSConfig.GetCertificate
+		return l.Server.ServeTLS(ln, "", "")
+	}
+	return l.Server.Serve(ln)
+}
+
+// HSTS wraps next, adding the Strict-Transport-Security header to responses when a max age is configured
+func HSTS(cfg config.HSTS, next http.Handler) http.Handler {
+	if cfg.MaxAge == 0 {
+		return next
+	}
+
+	value := fmt.Sprintf("max-age=%d", cfg.MaxAge)
+	if cfg.IncludeSubdomains {
+		value += "; includeSubDomains"
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Strict-Transport-Security", value)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Redirect returns a handler which redirects every request to the same host and path over HTTPS on port
+func Redirect(port string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(r.Host); err == nil {
+			host = h
+		}
+		if port != "443" {
+			host = net.JoinHostPort(host, port)
+		}
+
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusMovedPermanently)
+	})
+}
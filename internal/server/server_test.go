@@ -0,0 +1,113 @@
+package server_test
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/spoonboy-io/dujour/internal/config"
+	"github.com/spoonboy-io/dujour/internal/server"
+)
+
+func TestRedirect(t *testing.T) {
+	testCases := []struct {
+		name         string
+		port         string
+		requestURI   string
+		host         string
+		wantLocation string
+	}{
+		{"host with port", "18651", "/users?x=1", "dujour.example:8080", "https://dujour.example:18651/users?x=1"},
+		{"host without port", "18651", "/users/1", "dujour.example", "https://dujour.example:18651/users/1"},
+		{"default https port is omitted", "443", "/list", "dujour.example:80", "https://dujour.example/list"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", tc.requestURI, nil)
+			req.Host = tc.host
+
+			rr := httptest.NewRecorder()
+			server.Redirect(tc.port).ServeHTTP(rr, req)
+
+			if rr.Code != http.StatusMovedPermanently {
+				t.Errorf("failed got status %v wanted %v", rr.Code, http.StatusMovedPermanently)
+			}
+			if got := rr.Header().Get("Location"); got != tc.wantLocation {
+				t.Errorf("failed got location %v wanted %v", got, tc.wantLocation)
+			}
+		})
+	}
+}
+
+func TestHSTS(t *testing.T) {
+	testCases := []struct {
+		name       string
+		hsts       config.HSTS
+		wantHeader string
+	}{
+		{"not configured", config.HSTS{}, ""},
+		{"max age only", config.HSTS{MaxAge: 3600}, "max-age=3600"},
+		{"include subdomains", config.HSTS{MaxAge: 3600, IncludeSubdomains: true}, "max-age=3600; includeSubDomains"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			next := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			})
+
+			rr := httptest.NewRecorder()
+			server.HSTS(tc.hsts, next).ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+
+			if got := rr.Header().Get("Strict-Transport-Security"); got != tc.wantHeader {
+				t.Errorf("failed got %v wanted %v", got, tc.wantHeader)
+			}
+		})
+	}
+}
+
+func TestNew(t *testing.T) {
+	cfg := &config.Config{
+		Server: config.Server{
+			Listeners: []config.Listener{
+				{Type: config.LISTENER_HTTPS, Address: ":8443"},
+				{Type: config.LISTENER_HTTP, Address: "127.0.0.1:8080"},
+				{Type: config.LISTENER_UNIX, Address: "/tmp/dujour.sock"},
+			},
+			RedirectAddress: ":8081",
+		},
+		TLS: config.TLS{
+			MinVersion:   "1.3",
+			CipherSuites: []string{"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256"},
+		},
+	}
+
+	listeners, err := server.New(cfg, http.NotFoundHandler(), nil)
+	if err != nil {
+		t.Fatalf("New unexpected error: %v", err)
+	}
+
+	if len(listeners) != 4 {
+		t.Fatalf("failed got %d listeners wanted 4", len(listeners))
+	}
+
+	https := listeners[0].Server.TLSConfig
+	if https == nil || https.MinVersion != tls.VersionTLS13 {
+		t.Errorf("failed https listener did not apply the tls policy")
+	}
+	if https != nil && (len(https.CipherSuites) != 1 || https.CipherSuites[0] != tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256) {
+		t.Errorf("failed https listener cipher suites got %v", https.CipherSuites)
+	}
+
+	for _, l := range listeners[1:] {
+		if l.Server.TLSConfig != nil {
+			t.Errorf("failed %s should not use tls", l)
+		}
+	}
+
+	if listeners[3].Address != ":8081" {
+		t.Errorf("failed redirect listener address got %v", listeners[3].Address)
+	}
+}
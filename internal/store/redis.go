@@ -0,0 +1,408 @@
+package store
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+
+	"github.com/spoonboy-io/dujour/internal"
+)
+
+const (
+	REDIS_PREFIX  = "dujour"
+	REDIS_TIMEOUT = 5 * time.Second
+)
+
+// Redis is a Store shared by every instance using the same Redis namespace. The datasources are held in
+// a Redis hash and a copy is kept in memory to serve requests. Changes are published on a Redis channel,
+// so each instance updates its copy from Redis and notifies its subscribers
+type Redis struct {
+	Subscribers
+
+	client   redis.UniversalClient
+	key      string
+	channel  string
+	instance string
+	logger   internal.Logger
+
+	mtx         sync.RWMutex
+	datasources map[string]internal.Datasource
+	// datasources put or deleted while Redis could not be reached, written again once it reconnects
+	pending map[string]bool
+
+	pubsub *redis.PubSub
+	done   chan struct{}
+}
+
+// redisChange is published for each change, the datasource itself is read from the hash
+type redisChange struct {
+	Op       string `json:"op"`
+	FileName string `json:"fileName"`
+	Endpoint string `json:"endpoint"`
+	Instance string `json:"instance"`
+}
+
+// redisDatasource is how a datasource is stored, the type of Data is restored from FileType
+type redisDatasource struct {
+	Meta internal.Datasource `json:"meta"`
+	Data json.RawMessage     `json:"data"`
+}
+
+// NewRedis creates a store for the namespace, such as the data folder, and reads the datasources already
+// held in Redis. Close stops following changes made by other instances
+func NewRedis(client redis.UniversalClient, prefix, namespace string, logger internal.Logger) (*Redis, error) {
+	if prefix == "" {
+		prefix = REDIS_PREFIX
+	}
+	r := &Redis{
+		client:      client,
+		key:         fmt.Sprintf("%s:%s:datasources", prefix, namespace),
+		channel:     fmt.Sprintf("%s:%s:changes", prefix, namespace),
+		instance:    newInstanceID(),
+		logger:      logger,
+		datasources: map[string]internal.Datasource{},
+		pending:     map[string]bool{},
+		done:        make(chan struct{}),
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), REDIS_TIMEOUT)
+	defer cancel()
+
+	// subscribe before reading so no change is missed
+	r.pubsub = client.Subscribe(ctx, r.channel)
+	if _, err := r.pubsub.Receive(ctx); err != nil {
+		_ = r.pubsub.Close()
+		return nil, fmt.Errorf("Could not subscribe to '%s'; %v", r.channel, err)
+	}
+	if err := r.sync(ctx); err != nil {
+		_ = r.pubsub.Close()
+		return nil, err
+	}
+
+	go r.follow()
+
+	return r, nil
+}
+
+// Close stops following changes, it does not close the client
+func (r *Redis) Close() error {
+	err := r.pubsub.Close()
+	<-r.done
+	return err
+}
+
+func (r *Redis) List() []internal.Datasource {
+	r.mtx.RLock()
+	defer r.mtx.RUnlock()
+
+	list := make([]internal.Datasource, 0, len(r.datasources))
+	for _, v := range r.datasources {
+		list = append(list, v)
+	}
+	return list
+}
+
+func (r *Redis) Get(fileName string) (internal.Datasource, bool) {
+	r.mtx.RLock()
+	defer r.mtx.RUnlock()
+
+	ds, ok := r.datasources[fileName]
+	return ds, ok
+}
+
+func (r *Redis) Find(endpoint string) (internal.Datasource, bool) {
+	r.mtx.RLock()
+	defer r.mtx.RUnlock()
+
+	for _, v := range r.datasources {
+		if v.EndpointName == endpoint && v.Available() {
+			return v, true
+		}
+	}
+	return internal.Datasource{}, false
+}
+
+func (r *Redis) Record(endpoint, id string) (internal.Datasource, interface{}, error) {
+	ds, ok := r.Find(endpoint)
+	if !ok {
+		return ds, nil, ErrDatasourceNotFound
+	}
+	record, err := FindRecord(ds.Data, id)
+	return ds, record, err
+}
+
+// Put writes the datasource to Redis and publishes the change, the copy held by this instance is
+// updated even if Redis cannot be reached and written to Redis once the subscription reconnects
+func (r *Redis) Put(ds internal.Datasource) {
+	r.mtx.Lock()
+	r.datasources[ds.FileName] = ds
+	r.mtx.Unlock()
+	r.Notify(Change{Op: CHANGE_PUT, FileName: ds.FileName, EndpointName: ds.EndpointName})
+
+	r.write(ds)
+}
+
+// write writes the datasource to Redis and publishes the change
+func (r *Redis) write(ds internal.Datasource) {
+	value, err := encodeDatasource(ds)
+	if err != nil {
+		r.logger.Error(fmt.Sprintf("Could not encode datasource '%s' for redis", ds.FileName), err)
+		return
+	}
+	r.publish(redisChange{Op: CHANGE_PUT, FileName: ds.FileName, Endpoint: ds.EndpointName}, func(pipe redis.Pipeliner) {
+		pipe.HSet(context.Background(), r.key, ds.FileName, value)
+	})
+}
+
+// Delete removes the datasource from Redis and publishes the change, like Put it is retried once the
+// subscription reconnects if Redis cannot be reached
+func (r *Redis) Delete(fileName string) {
+	r.mtx.Lock()
+	ds, ok := r.datasources[fileName]
+	delete(r.datasources, fileName)
+	r.mtx.Unlock()
+	if ok {
+		r.Notify(Change{Op: CHANGE_DELETE, FileName: fileName, EndpointName: ds.EndpointName})
+	}
+
+	r.publish(redisChange{Op: CHANGE_DELETE, FileName: fileName, Endpoint: ds.EndpointName}, func(pipe redis.Pipeliner) {
+		pipe.HDel(context.Background(), r.key, fileName)
+	})
+}
+
+// publish applies the write and publishes the change in a single transaction
+func (r *Redis) publish(c redisChange, write func(redis.Pipeliner)) {
+	c.Instance = r.instance
+	msg, err := json.Marshal(c)
+	if err != nil {
+		r.logger.Error("Could not encode redis change", err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), REDIS_TIMEOUT)
+	defer cancel()
+	_, err = r.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		write(pipe)
+		pipe.Publish(ctx, r.channel, msg)
+		return nil
+	})
+
+	r.mtx.Lock()
+	if err != nil {
+		r.pending[c.FileName] = true
+	} else {
+		delete(r.pending, c.FileName)
+	}
+	r.mtx.Unlock()
+	if err != nil {
+		r.logger.Error(fmt.Sprintf("Could not write datasource '%s' to redis", c.FileName), err)
+	}
+}
+
+// follow applies the changes published by other instances until the store is closed. The datasources are
+// read again whenever the subscription is re-established, as changes may have been missed
+func (r *Redis) follow() {
+	defer close(r.done)
+
+	for msg := range r.pubsub.ChannelWithSubscriptions(context.Background(), SUBSCRIBER_BUFFER) {
+		ctx, cancel := context.WithTimeout(context.Background(), REDIS_TIMEOUT)
+		switch m := msg.(type) {
+		case *redis.Subscription:
+			if m.Kind == "subscribe" {
+				if err := r.sync(ctx); err != nil {
+					r.logger.Error("Could not read datasources from redis after reconnecting", err)
+				} else {
+					r.republish()
+				}
+			}
+		case *redis.Message:
+			c := redisChange{}
+			if err := json.Unmarshal([]byte(m.Payload), &c); err != nil {
+				r.logger.Error("Could not decode redis change", err)
+			} else if c.Instance != r.instance {
+				if err := r.apply(ctx, c); err != nil {
+					r.logger.Error(fmt.Sprintf("Could not read datasource '%s' from redis", c.FileName), err)
+				}
+			}
+		}
+		cancel()
+	}
+}
+
+// apply updates the copy of a datasource changed by another instance, the datasource is read from the
+// hash rather than trusting the order of messages, so concurrent writes settle on the value in Redis
+func (r *Redis) apply(ctx context.Context, c redisChange) error {
+	value, err := r.client.HGet(ctx, r.key, c.FileName).Result()
+	if err == redis.Nil {
+		r.mtx.Lock()
+		ds, ok := r.datasources[c.FileName]
+		delete(r.datasources, c.FileName)
+		r.mtx.Unlock()
+		if ok {
+			r.Notify(Change{Op: CHANGE_DELETE, FileName: c.FileName, EndpointName: ds.EndpointName})
+		}
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	ds, err := decodeDatasource(value)
+	if err != nil {
+		return err
+	}
+	r.mtx.Lock()
+	r.datasources[ds.FileName] = ds
+	r.mtx.Unlock()
+	r.Notify(Change{Op: CHANGE_PUT, FileName: ds.FileName, EndpointName: ds.EndpointName})
+	return nil
+}
+
+// sync merges the datasources in Redis with the copy held by this instance, notifying each which changed.
+// The copy held here is kept, and written to Redis by republish, for a datasource put or deleted while
+// Redis could not be reached, or loaded from a newer file than the one in Redis. When Redis holds no
+// datasources at all, such as after a restart without persistence, the whole copy is kept
+func (r *Redis) sync(ctx context.Context) error {
+	values, err := r.client.HGetAll(ctx, r.key).Result()
+	if err != nil {
+		return fmt.Errorf("Could not read datasources from '%s'; %v", r.key, err)
+	}
+
+	datasources := map[string]internal.Datasource{}
+	for k, v := range values {
+		ds, err := decodeDatasource(v)
+		if err != nil {
+			r.logger.Error(fmt.Sprintf("Could not decode datasource '%s' from redis", k), err)
+			continue
+		}
+		datasources[k] = ds
+	}
+
+	r.mtx.Lock()
+	// after a restart without persistence Redis holds nothing, so every datasource held here is kept
+	lost := len(values) == 0
+	merged := map[string]internal.Datasource{}
+	for k, v := range datasources {
+		if _, ok := r.datasources[k]; !ok && r.pending[k] {
+			// deleted while Redis could not be reached
+			continue
+		}
+		merged[k] = v
+	}
+	for k, v := range r.datasources {
+		remote, ok := datasources[k]
+		switch {
+		case ok && remote.ModTime.After(v.ModTime):
+			// loaded from a newer file by another instance
+			delete(r.pending, k)
+		case r.pending[k] || lost || ok && v.ModTime.After(remote.ModTime):
+			merged[k] = v
+			r.pending[k] = true
+		}
+	}
+
+	changes := []Change{}
+	for k, v := range r.datasources {
+		if _, ok := merged[k]; !ok {
+			changes = append(changes, Change{Op: CHANGE_DELETE, FileName: k, EndpointName: v.EndpointName})
+		}
+	}
+	for k, v := range merged {
+		if prev, ok := r.datasources[k]; !ok || !prev.LoadedAt.Equal(v.LoadedAt) || prev.Hash != v.Hash {
+			changes = append(changes, Change{Op: CHANGE_PUT, FileName: k, EndpointName: v.EndpointName})
+		}
+	}
+	r.datasources = merged
+	r.mtx.Unlock()
+
+	for _, v := range changes {
+		r.Notify(v)
+	}
+	return nil
+}
+
+// republish writes the datasources put or deleted while Redis could not be reached
+func (r *Redis) republish() {
+	r.mtx.RLock()
+	puts := []internal.Datasource{}
+	deletes := []string{}
+	for k := range r.pending {
+		if ds, ok := r.datasources[k]; ok {
+			puts = append(puts, ds)
+		} else {
+			deletes = append(deletes, k)
+		}
+	}
+	r.mtx.RUnlock()
+
+	for _, ds := range puts {
+		r.logger.Info(fmt.Sprintf("Writing datasource '%s' to redis after reconnecting", ds.FileName))
+		r.write(ds)
+	}
+	for _, fileName := range deletes {
+		r.logger.Info(fmt.Sprintf("Deleting datasource '%s' from redis after reconnecting", fileName))
+		r.publish(redisChange{Op: CHANGE_DELETE, FileName: fileName}, func(pipe redis.Pipeliner) {
+			pipe.HDel(context.Background(), r.key, fileName)
+		})
+	}
+}
+
+func encodeDatasource(ds internal.Datasource) ([]byte, error) {
+	data, err := json.Marshal(ds.Data)
+	if err != nil {
+		return nil, err
+	}
+	ds.Data = nil
+	return json.Marshal(redisDatasource{Meta: ds, Data: data})
+}
+
+// decodeDatasource restores the datasource with Data of the types it has when loaded from a file
+func decodeDatasource(value string) (internal.Datasource, error) {
+	rds := redisDatasource{}
+	if err := json.Unmarshal([]byte(value), &rds); err != nil {
+		return internal.Datasource{}, err
+	}
+	ds := rds.Meta
+	if len(rds.Data) == 0 || string(rds.Data) == "null" {
+		return ds, nil
+	}
+
+	if ds.FileType == internal.TYPE_CSV {
+		rows := []map[string]string{}
+		if err := json.Unmarshal(rds.Data, &rows); err == nil {
+			ds.Data = rows
+			return ds, nil
+		}
+	}
+
+	arr := []map[string]interface{}{}
+	if err := json.Unmarshal(rds.Data, &arr); err == nil {
+		ds.Data = arr
+		return ds, nil
+	}
+	obj := map[string]interface{}{}
+	if err := json.Unmarshal(rds.Data, &obj); err == nil {
+		ds.Data = obj
+		return ds, nil
+	}
+	var data interface{}
+	if err := json.Unmarshal(rds.Data, &data); err != nil {
+		return ds, err
+	}
+	ds.Data = data
+	return ds, nil
+}
+
+func newInstanceID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%d", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,199 @@
+package store_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+
+	"github.com/spoonboy-io/dujour/internal"
+	"github.com/spoonboy-io/dujour/internal/store"
+)
+
+type testLogger struct {
+	t *testing.T
+}
+
+func (l testLogger) Info(msg string)             { l.t.Log(msg) }
+func (l testLogger) Warn(msg string)             { l.t.Log(msg) }
+func (l testLogger) Error(msg string, err error) { l.t.Logf("%s; %v", msg, err) }
+
+func newRedisStore(t *testing.T, addr, namespace string) *store.Redis {
+	t.Helper()
+	client := redis.NewClient(&redis.Options{Addr: addr})
+	st, err := store.NewRedis(client, "", namespace, testLogger{t})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = st.Close()
+		_ = client.Close()
+	})
+	return st
+}
+
+// eventually waits for the change published by another store to be applied
+func eventually(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("failed change was not applied")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestRedis(t *testing.T) {
+	mr := miniredis.RunT(t)
+	a := newRedisStore(t, mr.Addr(), "data")
+	b := newRedisStore(t, mr.Addr(), "data")
+	other := newRedisStore(t, mr.Addr(), "vhost")
+	changes, cancel := b.Subscribe()
+	defer cancel()
+
+	loadedAt := time.Date(2022, 3, 1, 12, 0, 0, 0, time.UTC)
+	a.Put(internal.Datasource{
+		FileName:     "data/people.csv",
+		FileType:     internal.TYPE_CSV,
+		EndpointName: "people",
+		LoadedAt:     loadedAt,
+		Data:         []map[string]string{{"id": "1", "name": "Ann"}},
+	})
+	a.Put(internal.Datasource{
+		FileName:     "data/places.json",
+		FileType:     internal.TYPE_JSON,
+		EndpointName: "places",
+		Data:         map[string]interface{}{"places": []interface{}{map[string]interface{}{"id": 12, "name": "London"}}},
+	})
+
+	// changes made by one instance are applied by the others in the namespace
+	eventually(t, func() bool { return len(b.List()) == 2 })
+	if got := (<-changes); got.Op != store.CHANGE_PUT || got.EndpointName != "people" {
+		t.Errorf("failed got %+v wanted put of people", got)
+	}
+
+	testCases := []struct {
+		name     string
+		endpoint string
+		id       string
+		wantName string
+	}{
+		{"CSV rows keep their type", "people", "1", "Ann"},
+		{"JSON numbers are found by id", "places", "12", "London"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, record, err := b.Record(tc.endpoint, tc.id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var name interface{}
+			switch r := record.(type) {
+			case map[string]string:
+				name = r["name"]
+			case map[string]interface{}:
+				name = r["name"]
+			}
+			if name != tc.wantName {
+				t.Errorf("failed got %v wanted %s", name, tc.wantName)
+			}
+		})
+	}
+
+	if ds, _ := b.Get("data/people.csv"); !ds.LoadedAt.Equal(loadedAt) {
+		t.Errorf("failed got loaded at %v wanted %v", ds.LoadedAt, loadedAt)
+	}
+	if got := len(other.List()); got != 0 {
+		t.Errorf("failed got %d datasources in another namespace wanted none", got)
+	}
+
+	b.Delete("data/people.csv")
+	eventually(t, func() bool {
+		_, ok := a.Get("data/people.csv")
+		return !ok
+	})
+
+	// a new instance reads the datasources already shared
+	c := newRedisStore(t, mr.Addr(), "data")
+	if _, ok := c.Find("places"); !ok || len(c.List()) != 1 {
+		t.Errorf("failed got %d datasources wanted places", len(c.List()))
+	}
+}
+
+func TestRedisReconnect(t *testing.T) {
+	mr := miniredis.RunT(t)
+	a := newRedisStore(t, mr.Addr(), "data")
+	b := newRedisStore(t, mr.Addr(), "data")
+
+	now := time.Now()
+	a.Put(internal.Datasource{FileName: "data/people.json", EndpointName: "people", ModTime: now, Data: []map[string]interface{}{}})
+	a.Put(internal.Datasource{FileName: "data/removed.json", EndpointName: "removed", ModTime: now, Data: []map[string]interface{}{}})
+	eventually(t, func() bool { return len(b.List()) == 2 })
+
+	// Redis restarts without persistence, changes made while it is down are kept by the instance
+	mr.Close()
+	mr.FlushAll()
+	a.Put(internal.Datasource{FileName: "data/places.json", EndpointName: "places", ModTime: now, Data: []map[string]interface{}{}})
+	a.Delete("data/removed.json")
+	if err := mr.Restart(); err != nil {
+		t.Fatal(err)
+	}
+
+	// once reconnected the instances write back what Redis lost, rather than dropping their datasources
+	want := []string{"data/people.json", "data/places.json"}
+	eventually(t, func() bool {
+		for _, st := range []*store.Redis{a, b} {
+			for _, v := range want {
+				if _, ok := st.Get(v); !ok {
+					return false
+				}
+			}
+			if _, ok := st.Get("data/removed.json"); ok {
+				return false
+			}
+		}
+		keys, _ := mr.HKeys("dujour:data:datasources")
+		return len(keys) == len(want)
+	})
+	if keys, _ := mr.HKeys("dujour:data:datasources"); len(keys) != len(want) {
+		t.Errorf("failed got %v in redis wanted %v", keys, want)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	now := time.Now()
+	m := store.NewMemory(map[string]internal.Datasource{
+		"data/newer.json":  {FileName: "data/newer.json", Hash: "b", ModTime: now, Data: []map[string]interface{}{}},
+		"data/older.json":  {FileName: "data/older.json", Hash: "b", ModTime: now.Add(-time.Hour), Data: []map[string]interface{}{}},
+		"data/broken.json": {FileName: "data/broken.json", Hash: "b", ModTime: now.Add(-time.Hour), Data: []map[string]interface{}{}},
+	})
+
+	store.Load(m, map[string]internal.Datasource{
+		"data/newer.json":  {FileName: "data/newer.json", Hash: "a", ModTime: now.Add(-time.Minute), Data: []map[string]interface{}{}},
+		"data/older.json":  {FileName: "data/older.json", Hash: "a", ModTime: now, Data: []map[string]interface{}{}},
+		"data/broken.json": {FileName: "data/broken.json", ModTime: now, LastError: "unexpected end of JSON input"},
+		"data/added.json":  {FileName: "data/added.json", Hash: "a", ModTime: now, Data: []map[string]interface{}{}},
+	})
+
+	testCases := []struct {
+		name     string
+		fileName string
+		wantHash string
+	}{
+		{"datasource from a newer file is kept", "data/newer.json", "b"},
+		{"datasource from an older file is replaced", "data/older.json", "a"},
+		{"datasource is kept when the file fails to load", "data/broken.json", "b"},
+		{"datasource is added", "data/added.json", "a"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if ds, _ := m.Get(tc.fileName); ds.Hash != tc.wantHash {
+				t.Errorf("failed got hash %q wanted %q", ds.Hash, tc.wantHash)
+			}
+		})
+	}
+}
@@ -0,0 +1,241 @@
+// Package store holds the datasources being served, the default store keeps them in memory
+package store
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"sync"
+
+	"github.com/spoonboy-io/dujour/internal"
+)
+
+const (
+	CHANGE_PUT    = "put"
+	CHANGE_DELETE = "delete"
+
+	// changes are dropped for a subscriber which falls this far behind
+	SUBSCRIBER_BUFFER = 64
+)
+
+var (
+	ErrDatasourceNotFound = errors.New("datasource not found")
+	ErrRecordNotFound     = errors.New("record not found")
+	ErrUnsupportedData    = errors.New("data cannot be searched by id")
+)
+
+// Store holds the datasources being served keyed by the file each was loaded from. It is safe for
+// concurrent use, the handlers read from it while the watcher updates it
+type Store interface {
+	// List returns every datasource, including those which failed to load
+	List() []internal.Datasource
+	// Get returns the datasource loaded from the file
+	Get(fileName string) (internal.Datasource, bool)
+	// Find returns the available datasource served at the endpoint
+	Find(endpoint string) (internal.Datasource, bool)
+	// Record returns the record with the id from the available datasource served at the endpoint, along
+	// with the datasource. The error is ErrDatasourceNotFound, ErrRecordNotFound or ErrUnsupportedData
+	Record(endpoint, id string) (internal.Datasource, interface{}, error)
+	// Put adds or replaces the datasource
+	Put(ds internal.Datasource)
+	// Delete removes the datasource loaded from the file
+	Delete(fileName string)
+	// Subscribe returns a channel which receives every change to the store until cancel is called
+	Subscribe() (changes <-chan Change, cancel func())
+}
+
+// Change is a datasource which was put in or deleted from a store
+type Change struct {
+	Op           string
+	FileName     string
+	EndpointName string
+}
+
+// Load puts datasources loaded from files in the store. A datasource the store already holds is kept
+// when it was loaded from the same file with the same schema result or from a newer file, or the file
+// failed to load, so an instance started or reloaded with older files does not replace the datasources
+// it shares with other instances
+func Load(st Store, datasources map[string]internal.Datasource) {
+	for k, v := range datasources {
+		prev, ok := st.Get(k)
+		if ok && prev.Available() && (v.LastError != "" || sameLoad(prev, v) || prev.ModTime.After(v.ModTime)) {
+			continue
+		}
+		st.Put(v)
+	}
+}
+
+// sameLoad reports whether the datasources were loaded from the same file content and validated with the
+// same result, a changed schema or schema policy changes the records served from the same file
+func sameLoad(a, b internal.Datasource) bool {
+	return a.Hash == b.Hash && a.Schema == b.Schema && reflect.DeepEqual(a.Quarantined, b.Quarantined)
+}
+
+// FindRecord returns the record with the id from the data of a datasource, searching the elements of an
+// array or of the top level arrays of an object
+func FindRecord(data interface{}, id string) (interface{}, error) {
+	switch data := data.(type) {
+	case []map[string]interface{}:
+		for _, v := range data {
+			if matchID(v["id"], id) {
+				return v, nil
+			}
+		}
+	case map[string]interface{}:
+		for _, v := range data {
+			// the value stored should a slice otherwise we don't have a list of data, only an object
+			if arr, ok := v.([]interface{}); ok {
+				for _, v1 := range arr {
+					if record, ok := v1.(map[string]interface{}); ok && matchID(record["id"], id) {
+						return record, nil
+					}
+				}
+			}
+		}
+	case []map[string]string:
+		for _, v := range data {
+			if fid, ok := v["id"]; ok && fid == id {
+				return v, nil
+			}
+		}
+	default:
+		return nil, ErrUnsupportedData
+	}
+	return nil, ErrRecordNotFound
+}
+
+// matchID reports whether a JSON id, which could be a string or integer, matches the requested id. Numbers
+// decoded from a JSON file are float64
+func matchID(fid interface{}, id string) bool {
+	switch v := fid.(type) {
+	case string:
+		return v == id
+	case int:
+		return fmt.Sprint(v) == id
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64) == id
+	default:
+		return false
+	}
+}
+
+// Subscribers delivers changes to the subscribers of a store, a Store implementation embeds it to
+// provide Subscribe
+type Subscribers struct {
+	mtx  sync.Mutex
+	next int
+	subs map[int]chan Change
+}
+
+func (s *Subscribers) Subscribe() (<-chan Change, func()) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if s.subs == nil {
+		s.subs = map[int]chan Change{}
+	}
+	id := s.next
+	s.next++
+	ch := make(chan Change, SUBSCRIBER_BUFFER)
+	s.subs[id] = ch
+
+	once := sync.Once{}
+	return ch, func() {
+		once.Do(func() {
+			s.mtx.Lock()
+			defer s.mtx.Unlock()
+			delete(s.subs, id)
+			close(ch)
+		})
+	}
+}
+
+// Notify sends the change to every subscriber without blocking
+func (s *Subscribers) Notify(c Change) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	for _, ch := range s.subs {
+		select {
+		case ch <- c:
+		default:
+		}
+	}
+}
+
+// Memory is a Store which keeps the datasources in memory
+type Memory struct {
+	Subscribers
+
+	mtx         sync.RWMutex
+	datasources map[string]internal.Datasource
+}
+
+// NewMemory creates an in-memory store holding the datasources
+func NewMemory(datasources map[string]internal.Datasource) *Memory {
+	m := &Memory{datasources: map[string]internal.Datasource{}}
+	for k, v := range datasources {
+		m.datasources[k] = v
+	}
+	return m
+}
+
+func (m *Memory) List() []internal.Datasource {
+	m.mtx.RLock()
+	defer m.mtx.RUnlock()
+
+	list := make([]internal.Datasource, 0, len(m.datasources))
+	for _, v := range m.datasources {
+		list = append(list, v)
+	}
+	return list
+}
+
+func (m *Memory) Get(fileName string) (internal.Datasource, bool) {
+	m.mtx.RLock()
+	defer m.mtx.RUnlock()
+
+	ds, ok := m.datasources[fileName]
+	return ds, ok
+}
+
+func (m *Memory) Find(endpoint string) (internal.Datasource, bool) {
+	m.mtx.RLock()
+	defer m.mtx.RUnlock()
+
+	for _, v := range m.datasources {
+		if v.EndpointName == endpoint && v.Available() {
+			return v, true
+		}
+	}
+	return internal.Datasource{}, false
+}
+
+func (m *Memory) Record(endpoint, id string) (internal.Datasource, interface{}, error) {
+	ds, ok := m.Find(endpoint)
+	if !ok {
+		return ds, nil, ErrDatasourceNotFound
+	}
+	record, err := FindRecord(ds.Data, id)
+	return ds, record, err
+}
+
+func (m *Memory) Put(ds internal.Datasource) {
+	m.mtx.Lock()
+	m.datasources[ds.FileName] = ds
+	m.mtx.Unlock()
+
+	m.Notify(Change{Op: CHANGE_PUT, FileName: ds.FileName, EndpointName: ds.EndpointName})
+}
+
+func (m *Memory) Delete(fileName string) {
+	m.mtx.Lock()
+	ds, ok := m.datasources[fileName]
+	delete(m.datasources, fileName)
+	m.mtx.Unlock()
+
+	if ok {
+		m.Notify(Change{Op: CHANGE_DELETE, FileName: fileName, EndpointName: ds.EndpointName})
+	}
+}
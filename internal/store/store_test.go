@@ -0,0 +1,128 @@
+package store_test
+
+import (
+	"testing"
+
+	"github.com/spoonboy-io/dujour/internal"
+	"github.com/spoonboy-io/dujour/internal/store"
+)
+
+func TestMemory(t *testing.T) {
+	m := store.NewMemory(map[string]internal.Datasource{
+		"data/people.json": {FileName: "data/people.json", EndpointName: "people", Data: []map[string]interface{}{}},
+		"data/broken.json": {FileName: "data/broken.json", EndpointName: "broken", LastError: "unexpected end of JSON input"},
+	})
+	m.Put(internal.Datasource{FileName: "data/places.csv", EndpointName: "places", Data: []map[string]string{}})
+
+	testCases := []struct {
+		name     string
+		endpoint string
+		wantFile string
+		wantOK   bool
+	}{
+		{"datasource is found by endpoint", "people", "data/people.json", true},
+		{"added datasource is found", "places", "data/places.csv", true},
+		{"unavailable datasource is not found", "broken", "", false},
+		{"unknown endpoint is not found", "nothing", "", false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ds, ok := m.Find(tc.endpoint)
+			if ok != tc.wantOK || ds.FileName != tc.wantFile {
+				t.Errorf("failed got %v %s wanted %v %s", ok, ds.FileName, tc.wantOK, tc.wantFile)
+			}
+		})
+	}
+
+	if got := len(m.List()); got != 3 {
+		t.Errorf("failed got %d datasources wanted %d", got, 3)
+	}
+
+	m.Delete("data/people.json")
+	if _, ok := m.Get("data/people.json"); ok {
+		t.Errorf("failed deleted datasource still held")
+	}
+	if got := len(m.List()); got != 2 {
+		t.Errorf("failed got %d datasources wanted %d", got, 2)
+	}
+}
+
+func TestRecord(t *testing.T) {
+	m := store.NewMemory(map[string]internal.Datasource{
+		"data/people.csv": {FileName: "data/people.csv", EndpointName: "people", Data: []map[string]string{{"id": "1", "name": "Ann"}}},
+		"data/places.json": {FileName: "data/places.json", EndpointName: "places", Data: []map[string]interface{}{
+			{"id": float64(12), "name": "London"},
+			{"id": 13, "name": "Paris"},
+			{"id": 1.5, "name": "Halfway"},
+		}},
+		"data/teams.json": {FileName: "data/teams.json", EndpointName: "teams", Data: map[string]interface{}{
+			"teams": []interface{}{map[string]interface{}{"id": "red", "name": "Red"}},
+		}},
+		"data/odd.json": {FileName: "data/odd.json", EndpointName: "odd", Data: []string{"not", "records"}},
+	})
+
+	testCases := []struct {
+		name     string
+		endpoint string
+		id       string
+		wantName interface{}
+		wantErr  error
+	}{
+		{"CSV record", "people", "1", "Ann", nil},
+		{"number decoded from JSON", "places", "12", "London", nil},
+		{"integer id", "places", "13", "Paris", nil},
+		{"fractional number", "places", "1.5", "Halfway", nil},
+		{"record in an object", "teams", "red", "Red", nil},
+		{"missing record", "places", "120", nil, store.ErrRecordNotFound},
+		{"missing datasource", "nothing", "1", nil, store.ErrDatasourceNotFound},
+		{"unsupported data", "odd", "1", nil, store.ErrUnsupportedData},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, record, err := m.Record(tc.endpoint, tc.id)
+			if err != tc.wantErr {
+				t.Fatalf("failed got %v wanted %v", err, tc.wantErr)
+			}
+			var name interface{}
+			switch r := record.(type) {
+			case map[string]string:
+				name = r["name"]
+			case map[string]interface{}:
+				name = r["name"]
+			}
+			if name != tc.wantName {
+				t.Errorf("failed got %v wanted %v", name, tc.wantName)
+			}
+		})
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	m := store.NewMemory(nil)
+	changes, cancel := m.Subscribe()
+
+	m.Put(internal.Datasource{FileName: "data/people.json", EndpointName: "people"})
+	m.Delete("data/people.json")
+	// deleting a datasource which is not held is not a change
+	m.Delete("data/people.json")
+
+	want := []store.Change{
+		{Op: store.CHANGE_PUT, FileName: "data/people.json", EndpointName: "people"},
+		{Op: store.CHANGE_DELETE, FileName: "data/people.json", EndpointName: "people"},
+	}
+	for _, w := range want {
+		if got := <-changes; got != w {
+			t.Errorf("failed got %+v wanted %+v", got, w)
+		}
+	}
+
+	cancel()
+	m.Put(internal.Datasource{FileName: "data/places.json", EndpointName: "places"})
+	if _, ok := <-changes; ok {
+		t.Errorf("failed got change after cancel")
+	}
+	// cancelling again is safe
+	cancel()
+}
@@ -1,24 +1,32 @@
-// Package watcher monitors a data folder and performs automatic reloading of data
+// Package watcher monitors a data folder and its schema folder and performs automatic reloading of data
 // including updating the datasource cache in memory for deleted and edited data files
 package watcher
 
 import (
+	"context"
 	"fmt"
+	"os"
 	"path/filepath"
 	"strings"
-	"sync"
 
+	"github.com/spoonboy-io/dujour/internal/config"
 	"github.com/spoonboy-io/dujour/internal/file"
+	"github.com/spoonboy-io/dujour/internal/metrics"
+	"github.com/spoonboy-io/dujour/internal/store"
 
 	"github.com/spoonboy-io/dujour/internal"
 
 	"github.com/fsnotify/fsnotify"
-	"github.com/spoonboy-io/koan"
 )
 
-// Monitor creates a file watcher for the data directory
-func Monitor(datasources map[string]internal.Datasource, logger *koan.Logger, mtx *sync.Mutex) error {
-	watchPath := filepath.Join(".", internal.DATA_FOLDER)
+// Monitor creates a file watcher for the data directory, and for its schema folder when there is one, so a
+// data file is reloaded when its schema changes. It blocks until ctx is cancelled. The cfg callback
+// returns the current configuration used to validate datasources. The watching callback is called with
+// true once the folder is being watched, and false when watching stops. Datasources which are added,
+// reloaded or removed are put in or deleted from the store, which notifies its subscribers
+func Monitor(ctx context.Context, dataFolder string, datasources store.Store, logger internal.Logger, cfg func() *config.Config, watching func(bool)) error {
+	watchPath := filepath.Clean(dataFolder)
+	schemaPath := file.SchemaFolder(watchPath)
 
 	logger.Info(fmt.Sprintf("Creating Watcher for '%s' folder", watchPath))
 
@@ -32,7 +40,17 @@ func Monitor(datasources map[string]internal.Datasource, logger *koan.Logger, mt
 	go func() {
 		defer close(done)
 
-		var processAdd = func(event fsnotify.Event) {
+		var processAdd func(event fsnotify.Event)
+		processAdd = func(event fsnotify.Event) {
+			if file.IsSchemaFile(event.Name) {
+				// reload the data files the schema applies to
+				for _, v := range file.DataFiles(event.Name, watchPath) {
+					logger.Info(fmt.Sprintf("Hotloader schema changed for '%s'", v))
+					processAdd(fsnotify.Event{Name: v, Op: fsnotify.Write})
+				}
+				return
+			}
+
 			extension := strings.ToLower(filepath.Ext(event.Name))
 			if (extension != ".csv") && (extension != ".json") {
 				if extension != "" {
@@ -42,24 +60,37 @@ func Monitor(datasources map[string]internal.Datasource, logger *koan.Logger, mt
 			}
 
 			// init & validate the file
-			var hlds internal.Datasource
-			var err error
-			if hlds, err = file.LoadAndValidate(file.InitDatasource(event.Name), logger); err != nil {
+			hlds, err := file.LoadAndValidate(file.InitDatasource(event.Name), cfg(), logger)
+
+			if err != nil {
 				logger.Error(fmt.Sprintf("Could not hotload datasource '%s'", event.Name), err)
+				metrics.Reloads.Inc("failure")
+
+				// keep serving the previous version, if there is one, and record the error
+				if prev, ok := datasources.Get(event.Name); ok && prev.Data != nil {
+					hlds = prev
+				}
+				hlds.LastError = err.Error()
+			} else {
+				metrics.Reloads.Inc("success")
 			}
 
 			// add the datasource
-			mtx.Lock()
-			datasources[event.Name] = hlds
-			mtx.Unlock()
+			datasources.Put(hlds)
 		}
 
 		for {
 			select {
+			case <-ctx.Done():
+				return
 			case event, ok := <-watcher.Events:
 				if !ok {
 					return
 				}
+				// only schemas are read from the schema folder
+				if filepath.Dir(event.Name) == schemaPath && !file.IsSchemaFile(event.Name) {
+					continue
+				}
 				switch event.Op {
 				case fsnotify.Create:
 					logger.Info(fmt.Sprintf("Hotloader file added '%s'", event.Name))
@@ -71,11 +102,14 @@ func Monitor(datasources map[string]internal.Datasource, logger *koan.Logger, mt
 					logger.Info(fmt.Sprintf("Hotloader file written '%s'", event.Name))
 					processAdd(event)
 				case fsnotify.Remove, fsnotify.Rename:
+					if file.IsSchemaFile(event.Name) {
+						logger.Info(fmt.Sprintf("Hotloader schema removed '%s'", event.Name))
+						processAdd(event)
+						continue
+					}
 					logger.Info(fmt.Sprintf("Hotloader file removed '%s'", event.Name))
 					// remove
-					mtx.Lock()
-					delete(datasources, event.Name)
-					mtx.Unlock()
+					datasources.Delete(event.Name)
 				}
 			case err, ok := <-watcher.Errors:
 				if !ok {
@@ -89,6 +123,15 @@ func Monitor(datasources map[string]internal.Datasource, logger *koan.Logger, mt
 	if err := watcher.Add(watchPath); err != nil {
 		return fmt.Errorf("Adding file failed; %v", err)
 	}
+	if info, err := os.Stat(schemaPath); err == nil && info.IsDir() {
+		logger.Info(fmt.Sprintf("Creating Watcher for '%s' folder", schemaPath))
+		if err := watcher.Add(schemaPath); err != nil {
+			return fmt.Errorf("Adding file failed; %v", err)
+		}
+	}
+
+	watching(true)
+	defer watching(false)
 
 	<-done
 
@@ -0,0 +1,98 @@
+package watcher
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/spoonboy-io/dujour/internal"
+	"github.com/spoonboy-io/dujour/internal/config"
+	"github.com/spoonboy-io/dujour/internal/file"
+	"github.com/spoonboy-io/dujour/internal/store"
+	"github.com/spoonboy-io/koan"
+)
+
+func TestMonitorSchemaFolder(t *testing.T) {
+	testLogger := &koan.Logger{}
+
+	// the schema folder is relative to the working directory
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.Chdir(wd) }()
+
+	// two virtual host folders with data files of the same name
+	for _, folder := range []string{"data-a", "data-b", filepath.Join(internal.SCHEMA_FOLDER, "data-a")} {
+		if err := os.MkdirAll(folder, os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, folder := range []string{"data-a", "data-b"} {
+		if err := os.WriteFile(filepath.Join(folder, "users.json"), []byte(`[{"id": 1, "name": "Jo"}, {"id": 2}]`), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cfg := &config.Config{Datasources: map[string]config.Datasource{
+		"users": {SchemaPolicy: config.SCHEMA_POLICY_QUARANTINE},
+	}}
+	current := func() *config.Config { return cfg }
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	stores := map[string]store.Store{}
+	for _, folder := range []string{"data-a", "data-b"} {
+		datasources, err := file.LoadAndValidateDatasources(folder, cfg, testLogger)
+		if err != nil {
+			t.Fatal(err)
+		}
+		st := store.NewMemory(datasources)
+		stores[folder] = st
+
+		watching := make(chan bool, 2)
+		go func(folder string) {
+			if err := Monitor(ctx, folder, st, testLogger, current, func(v bool) { watching <- v }); err != nil {
+				t.Errorf("Monitor unexpected error: %v", err)
+			}
+		}(folder)
+		select {
+		case <-watching:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("failed watcher for '%s' did not start", folder)
+		}
+	}
+
+	// a schema added to the schema folder of one data folder applies only to that folder
+	schemaFile := filepath.Join(file.SchemaFolder("data-a"), "users.schema.json")
+	if err := os.WriteFile(schemaFile, []byte(`{"type": "object", "required": ["name"]}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	records := func(folder string) (internal.Datasource, bool) {
+		ds, ok := stores[folder].Get(filepath.Join(folder, "users.json"))
+		return ds, ok && ds.Schema == schemaFile && ds.RecordCount() == 1
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, ok := records("data-a"); ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			ds, _ := records("data-a")
+			t.Fatalf("failed got schema %q and %d records wanted schema %q and 1 record", ds.Schema, ds.RecordCount(), schemaFile)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	ds, _ := stores["data-b"].Get(filepath.Join("data-b", "users.json"))
+	if ds.Schema != "" || ds.RecordCount() != 2 {
+		t.Errorf("failed got schema %q and %d records for other folder wanted no schema and 2 records", ds.Schema, ds.RecordCount())
+	}
+}